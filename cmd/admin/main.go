@@ -2,16 +2,14 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/ardanlabs/conf"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/sankarvj/seedgo/internal/platform/auth"
 	"github.com/sankarvj/seedgo/internal/platform/database"
@@ -70,9 +68,18 @@ func run() error {
 	case "seed":
 		err = seed(dbConfig)
 	case "useradd":
-		err = useradd(dbConfig, cfg.Args.Num(1), cfg.Args.Num(2))
+		err = useradd(dbConfig, cfg.Args.Num(1), cfg.Args.Num(2), cfg.Args.Num(3), []string{auth.RoleAdmin, auth.RoleUser})
+	case "superadmin":
+		err = useradd(dbConfig, cfg.Args.Num(1), cfg.Args.Num(2), cfg.Args.Num(3), []string{auth.RoleSuperAdmin})
 	case "keygen":
-		err = keygen(cfg.Args.Num(1))
+		switch cfg.Args.Num(1) {
+		case "rotate":
+			err = keyrotate(cfg.Args.Num(2), cfg.Args.Num(3))
+		case "retire":
+			err = keyretire(cfg.Args.Num(2), cfg.Args.Num(3))
+		default:
+			err = keygen(cfg.Args.Num(1), cfg.Args.Num(2))
+		}
 	default:
 		err = errors.New("Must specify a command")
 	}
@@ -114,18 +121,20 @@ func seed(cfg database.Config) error {
 	return nil
 }
 
-func useradd(cfg database.Config, email, password string) error {
+// useradd creates a user with the roles in the account. It acts as a
+// superadmin so it can create users in every account and grant every role.
+func useradd(cfg database.Config, email, password, accountID string, roles []string) error {
 	db, err := database.Open(cfg)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	if email == "" || password == "" {
-		return errors.New("useradd command must be called with two additional arguments for email and password")
+	if email == "" || password == "" || accountID == "" {
+		return errors.New("useradd command must be called with three additional arguments for email, password and account id")
 	}
 
-	fmt.Printf("Admin user will be created with email %q and password %q\n", email, password)
+	fmt.Printf("User with roles %v will be created in account %q with email %q and password %q\n", roles, accountID, email, password)
 	fmt.Print("Continue? (1/0) ")
 
 	var confirm bool
@@ -141,13 +150,15 @@ func useradd(cfg database.Config, email, password string) error {
 	ctx := context.Background()
 
 	nu := user.NewUser{
+		AccountID:       accountID,
 		Email:           email,
 		Password:        password,
 		PasswordConfirm: password,
-		Roles:           []string{auth.RoleAdmin, auth.RoleUser},
+		Roles:           roles,
 	}
 
-	u, err := user.Create(ctx, db, nu, time.Now())
+	claims := auth.Claims{Roles: []string{auth.RoleSuperAdmin}}
+	u, err := user.Create(ctx, claims, db, nu, time.Now())
 	if err != nil {
 		return err
 	}
@@ -156,29 +167,33 @@ func useradd(cfg database.Config, email, password string) error {
 	return nil
 }
 
-// keygen creates an x509 private key for signing auth tokens.
-func keygen(path string) error {
+// keygen creates a private key for signing auth tokens with the algorithm:
+// RS256 (the default), RS384, RS512, ES256, ES384, EdDSA or HS256.
+func keygen(path, algorithm string) error {
 	if path == "" {
 		return errors.New("keygen missing argument for key path")
 	}
+	if algorithm == "" {
+		algorithm = auth.AlgRS256
+	}
 
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	key, err := auth.GenerateKey(algorithm)
 	if err != nil {
 		return errors.Wrap(err, "generating keys")
 	}
 
-	file, err := os.Create(path)
+	data, err := auth.EncodePrivateKeyPEM(key)
 	if err != nil {
-		return errors.Wrap(err, "creating private file")
+		return err
 	}
-	defer file.Close()
 
-	block := pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "creating private file")
 	}
+	defer file.Close()
 
-	if err := pem.Encode(file, &block); err != nil {
+	if _, err := file.Write(data); err != nil {
 		return errors.Wrap(err, "encoding to private file")
 	}
 
@@ -188,3 +203,85 @@ func keygen(path string) error {
 
 	return nil
 }
+
+// keyrotate adds a new private key for the algorithm to the keyring in dir
+// and makes it the active signing key. The previously active key becomes
+// verify-only so the tokens it signed stay valid until they expire.
+func keyrotate(dir, algorithm string) error {
+	if dir == "" {
+		return errors.New("keygen rotate missing argument for keyring directory")
+	}
+	if algorithm == "" {
+		algorithm = auth.AlgRS256
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "creating keyring directory")
+	}
+
+	m, err := auth.ReadKeyringManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	kid := uuid.New().String()
+	file := kid + ".pem"
+	if err := keygen(filepath.Join(dir, file), algorithm); err != nil {
+		return err
+	}
+
+	for i := range m.Keys {
+		if m.Keys[i].State == auth.KeyActive {
+			m.Keys[i].State = auth.KeyVerifyOnly
+		}
+	}
+	m.Keys = append(m.Keys, auth.KeyringEntry{
+		KeyID:     kid,
+		File:      file,
+		Algorithm: algorithm,
+		State:     auth.KeyActive,
+		CreatedAt: time.Now().UTC(),
+	})
+
+	if err := auth.WriteKeyringManifest(dir, m); err != nil {
+		return err
+	}
+
+	fmt.Println("Active key rotated to:", kid)
+	return nil
+}
+
+// keyretire marks a verify-only key in the keyring in dir as retired. Tokens
+// signed with it are rejected once services reload the keyring.
+func keyretire(dir, kid string) error {
+	if dir == "" || kid == "" {
+		return errors.New("keygen retire must be called with two additional arguments for keyring directory and key id")
+	}
+
+	m, err := auth.ReadKeyringManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	var found bool
+	for i := range m.Keys {
+		if m.Keys[i].KeyID != kid {
+			continue
+		}
+		if m.Keys[i].State == auth.KeyActive {
+			return errors.Errorf("key %q is active, rotate before retiring it", kid)
+		}
+		m.Keys[i].State = auth.KeyRetired
+		found = true
+	}
+	if !found {
+		return errors.Errorf("key %q not found in keyring", kid)
+	}
+
+	if err := auth.WriteKeyringManifest(dir, m); err != nil {
+		return err
+	}
+
+	fmt.Println("Key retired:", kid)
+	return nil
+}
@@ -5,9 +5,12 @@ import (
 	"net/http"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	"github.com/sankarvj/seedgo/internal/account"
 	"github.com/sankarvj/seedgo/internal/platform/auth"
 	"github.com/sankarvj/seedgo/internal/platform/web"
+	"github.com/sankarvj/seedgo/internal/policy"
+	"github.com/sankarvj/seedgo/internal/revocation"
 	"go.opencensus.io/trace"
 )
 
@@ -15,6 +18,7 @@ import (
 type Account struct {
 	db            *sqlx.DB
 	authenticator *auth.Authenticator
+	revocations   *revocation.Store
 	// ADD OTHER STATE LIKE THE LOGGER AND CONFIG HERE.
 }
 
@@ -30,8 +34,107 @@ func (a *Account) List(ctx context.Context, w http.ResponseWriter, r *http.Reque
 
 	accounts, err := account.List(ctx, claims, a.db)
 	if err != nil {
-		return err
+		switch err {
+		case policy.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrap(err, "listing accounts")
+		}
 	}
 
 	return web.Respond(ctx, w, accounts, http.StatusOK)
 }
+
+// Update modifies the specified account, such as to make a second factor
+// mandatory for its admins.
+func (a *Account) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Account.Update")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from context")
+	}
+
+	var upd account.UpdateAccount
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	if err := account.Update(ctx, claims, a.db, params["id"], upd, v.Now); err != nil {
+		return accountError(err, params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes the specified account and logs its users out everywhere.
+// The account and its users can be restored until they are purged.
+func (a *Account) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Account.Delete")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from context")
+	}
+
+	userIDs, err := account.Delete(ctx, claims, a.db, params["id"], v.Now)
+	if err != nil {
+		return accountError(err, params["id"])
+	}
+
+	for _, id := range userIDs {
+		if err := logoutUser(ctx, a.db, a.revocations, id, v.Now); err != nil {
+			return err
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Restore brings back the specified deleted account along with the users
+// deleted with it.
+func (a *Account) Restore(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Account.Restore")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from context")
+	}
+
+	if err := account.Restore(ctx, claims, a.db, params["id"], v.Now); err != nil {
+		return accountError(err, params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// accountError translates the errors returned by the account package into
+// request errors.
+func accountError(err error, id string) error {
+	switch err {
+	case account.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case policy.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrapf(err, "Id: %s", id)
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/platform/web"
+	"go.opencensus.io/trace"
+)
+
+// Keys publishes the public keys used to verify the tokens we issue.
+type Keys struct {
+	authenticator *auth.Authenticator
+}
+
+// JWKS returns every public key the authenticator trusts as a JSON Web Key
+// Set. Clients may cache the response for a short while, which keeps the
+// window small between a key being rotated in and others trusting it.
+func (k *Keys) JWKS(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Keys.JWKS")
+	defer span.End()
+
+	w.Header().Set("Cache-Control", "public, max-age=300, must-revalidate")
+
+	return web.Respond(ctx, w, k.authenticator.JWKS(), http.StatusOK)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// TestJWKS validates the public keys are published as a cacheable JSON Web
+// Key Set other services can verify our tokens with.
+func TestJWKS(t *testing.T) {
+	key, err := auth.GenerateKey(auth.AlgRS256)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	kr, err := auth.NewKeyring("kid-1", auth.AlgRS256, key)
+	if err != nil {
+		t.Fatalf("constructing keyring: %s", err)
+	}
+	a, err := auth.NewKeyringAuthenticator(kr, kr.Lookup)
+	if err != nil {
+		t.Fatalf("constructing authenticator: %s", err)
+	}
+	k := Keys{authenticator: a}
+
+	t.Log("Given the need to publish the keys verifying our tokens.")
+	{
+		t.Log("\tWhen fetching /.well-known/jwks.json.")
+		{
+			r := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+			w := httptest.NewRecorder()
+
+			if err := k.JWKS(tests.Context(), w, r, nil); err != nil {
+				t.Fatalf("\t%s\tShould be able to publish the keys : %s.", tests.Failed, err)
+			}
+			if w.Code != http.StatusOK {
+				t.Fatalf("\t%s\tShould receive a status code of 200 for the response : %v.", tests.Failed, w.Code)
+			}
+			t.Logf("\t%s\tShould receive a status code of 200 for the response.", tests.Success)
+
+			if got, exp := w.Header().Get("Cache-Control"), "public, max-age=300, must-revalidate"; got != exp {
+				t.Fatalf("\t%s\tShould let clients cache the keys briefly : got %q, want %q.", tests.Failed, got, exp)
+			}
+			t.Logf("\t%s\tShould let clients cache the keys briefly.", tests.Success)
+
+			var set auth.JWKSet
+			if err := json.NewDecoder(w.Body).Decode(&set); err != nil {
+				t.Fatalf("\t%s\tShould be able to decode the key set : %s.", tests.Failed, err)
+			}
+			if len(set.Keys) != 1 || set.Keys[0].KeyID != "kid-1" {
+				t.Fatalf("\t%s\tShould publish the active key : %+v.", tests.Failed, set)
+			}
+			t.Logf("\t%s\tShould publish the active key.", tests.Success)
+
+			vk, err := set.Keys[0].VerifyKey()
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to use the published key : %s.", tests.Failed, err)
+			}
+			tkn, err := a.GenerateToken(auth.NewClaims("subject", []string{auth.RoleUser}, time.Now(), time.Hour))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to sign a token : %s.", tests.Failed, err)
+			}
+			other, err := auth.NewAuthenticator(key, "kid-1", auth.AlgRS256, auth.NewSimpleKeyLookupFunc("kid-1", vk))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to construct an authenticator : %s.", tests.Failed, err)
+			}
+			if _, err := other.ParseClaims(tkn); err != nil {
+				t.Fatalf("\t%s\tShould be able to verify our tokens with the published key : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to verify our tokens with the published key.", tests.Success)
+		}
+	}
+}
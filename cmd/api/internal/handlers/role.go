@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/platform/web"
+	"github.com/sankarvj/seedgo/internal/policy"
+	"github.com/sankarvj/seedgo/internal/role"
+	"go.opencensus.io/trace"
+)
+
+// Role represents the Role API method handler set.
+type Role struct {
+	db *sqlx.DB
+	// ADD OTHER STATE LIKE THE LOGGER AND CONFIG HERE.
+}
+
+// List returns the system roles and the roles of the caller's account.
+func (ro *Role) List(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Role.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from context")
+	}
+
+	roles, err := role.List(ctx, claims, ro.db)
+	if err != nil {
+		return roleError(err, "")
+	}
+
+	return web.Respond(ctx, w, roles, http.StatusOK)
+}
+
+// Retrieve returns the specified role.
+func (ro *Role) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Role.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from context")
+	}
+
+	rl, err := role.Retrieve(ctx, claims, ro.db, params["id"])
+	if err != nil {
+		return roleError(err, params["id"])
+	}
+
+	return web.Respond(ctx, w, rl, http.StatusOK)
+}
+
+// Create adds a role to the caller's account.
+func (ro *Role) Create(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Role.Create")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from context")
+	}
+
+	var nr role.NewRole
+	if err := web.Decode(r, &nr); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	rl, err := role.Create(ctx, claims, ro.db, nr, v.Now)
+	if err != nil {
+		return roleError(err, "")
+	}
+
+	return web.Respond(ctx, w, rl, http.StatusCreated)
+}
+
+// Update modifies the specified role of the caller's account.
+func (ro *Role) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Role.Update")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from context")
+	}
+
+	var upd role.UpdateRole
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	if err := role.Update(ctx, claims, ro.db, params["id"], upd, v.Now); err != nil {
+		return roleError(err, params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes the specified role of the caller's account.
+func (ro *Role) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Role.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from context")
+	}
+
+	if err := role.Delete(ctx, claims, ro.db, params["id"]); err != nil {
+		return roleError(err, params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// roleError translates the errors returned by the role package into request
+// errors.
+func roleError(err error, id string) error {
+	switch err {
+	case role.ErrInvalidID, role.ErrNameTaken, role.ErrInvalidPermission:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case role.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case role.ErrInUse:
+		return web.NewRequestError(err, http.StatusConflict)
+	case role.ErrForbidden, role.ErrPermissionNotHeld, role.ErrOwnRole, policy.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrapf(err, "Id: %s", id)
+	}
+}
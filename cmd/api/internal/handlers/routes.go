@@ -1,47 +1,188 @@
 package handlers
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/sankarvj/seedgo/internal/apikey"
 	"github.com/sankarvj/seedgo/internal/mid"
 	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/platform/throttle"
 	"github.com/sankarvj/seedgo/internal/platform/web"
+	"github.com/sankarvj/seedgo/internal/reset"
+	"github.com/sankarvj/seedgo/internal/revocation"
+	"github.com/sankarvj/seedgo/internal/verify"
 )
 
+// Options holds the settings that tune the behavior of the handlers.
+type Options struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	RevocationSync  time.Duration
+
+	// ProvisionRule chooses the account of users logging in through the
+	// identity provider for the first time. Blank disables provisioning.
+	ProvisionRule string
+
+	// RequireVerified refuses tokens to users whose email is not verified.
+	RequireVerified bool
+
+	// MFAIssuer names the service in authenticator apps.
+	MFAIssuer string
+
+	// MFAChallengeTTL is how long the second step of a login may take.
+	MFAChallengeTTL time.Duration
+
+	// ImpersonationTTL is how long a token issued to an admin acting as
+	// another user is valid.
+	ImpersonationTTL time.Duration
+
+	// ScopedTokenMaxTTL caps how long a token narrowed to scopes is valid.
+	// Zero falls back on AccessTokenTTL.
+	ScopedTokenMaxTTL time.Duration
+
+	// Throttle limits failed logins per identifier such as an email.
+	// IPThrottle limits failed requests per IP address across the
+	// unauthenticated endpoints.
+	Throttle   throttle.Config
+	IPThrottle throttle.Config
+}
+
+// maxTokenTTL returns the longest lifetime an access token can be issued
+// with, whether at login or for impersonation. Tokens narrowed to scopes never
+// outlive the token they narrow.
+func (o Options) maxTokenTTL() time.Duration {
+	ttl := o.AccessTokenTTL
+	if o.ImpersonationTTL > ttl {
+		ttl = o.ImpersonationTTL
+	}
+	return ttl
+}
+
+// scopedTokenMaxTTL returns the longest lifetime a token narrowed to scopes
+// can be issued with. It falls back on AccessTokenTTL when unset.
+func (o Options) scopedTokenMaxTTL() time.Duration {
+	if o.ScopedTokenMaxTTL <= 0 {
+		return o.AccessTokenTTL
+	}
+	return o.ScopedTokenMaxTTL
+}
+
 // API constructs an http.Handler with all application routes defined.
-func API(shutdown chan os.Signal, log *log.Logger, db *sqlx.DB, authenticator *auth.Authenticator) http.Handler {
+func API(shutdown chan os.Signal, log *log.Logger, db *sqlx.DB, authenticator *auth.Authenticator, provider auth.IdentityProvider, verifier *verify.Verifier, resetter *reset.Resetter, opts Options) http.Handler {
 
 	// Construct the web.App which holds all routes as well as common Middleware.
 	app := web.NewApp(shutdown, log, mid.Logger(log), mid.Errors(log), mid.Metrics(), mid.Panics(log))
 
+	// Every authenticated route accepts access tokens and API keys and rejects
+	// revoked tokens.
+	revocations := revocation.NewStore(db, opts.RevocationSync, opts.maxTokenTTL())
+	apiKeys := func(ctx context.Context, key string, now time.Time) (auth.Claims, error) {
+		return apikey.Authenticate(ctx, db, now, key, opts.AccessTokenTTL)
+	}
+	authenticate := mid.Authenticate(authenticator, revocations, apiKeys)
+
 	// Register health check endpoint. This route is not authenticated.
 	check := Check{
 		db: db,
 	}
 	app.Handle("GET", "/v1/health", check.Health)
 
+	// Register the public signing keys. This route is not authenticated.
+	k := Keys{
+		authenticator: authenticator,
+	}
+	app.Handle("GET", "/.well-known/jwks.json", k.JWKS)
+
+	// Failed attempts to authenticate are throttled per IP address on the
+	// unauthenticated endpoints and per identifier where one is known.
+	ips := throttle.New(opts.IPThrottle)
+	throttled := mid.Throttle(log, ips)
+
+	// Tokens issued to an admin acting as another user cannot be used for
+	// these sensitive actions: managing credentials, sessions and access.
+	sensitive := mid.NotImpersonated()
+
+	// Every authenticated route declares the scopes a narrowed token needs to
+	// use it. Scopes are named after permissions. Whether the caller may act on
+	// the resource is decided by the policy package within each business call.
+	scope := mid.RequireScope
+
 	// Register user management and authentication endpoints.
 	u := User{
+		log:           log,
 		db:            db,
 		authenticator: authenticator,
+		provider:      provider,
+		revocations:   revocations,
+		verifier:      verifier,
+		resetter:      resetter,
+		throttle:      throttle.New(opts.Throttle),
+		ips:           ips,
+		opts:          opts,
 	}
-	// This route is not authenticated
-	app.Handle("GET", "/v1/users/token/:id", u.Token)
-	app.Handle("GET", "/v1/users", u.List, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("POST", "/v1/users", u.Create, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/v1/users/:id", u.Retrieve, mid.Authenticate(authenticator))
-	app.Handle("PUT", "/v1/users/:id", u.Update, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("DELETE", "/v1/users/:id", u.Delete, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	// These routes are not authenticated
+	app.Handle("GET", "/v1/users/token/:id", u.Token, throttled)
+	app.Handle("POST", "/v1/users/token", u.Login, throttled)
+	app.Handle("POST", "/v1/users/token/refresh", u.Refresh, throttled)
+	app.Handle("POST", "/v1/users/token/mfa", u.Challenge, throttled)
+	app.Handle("POST", "/v1/users/token/mfa/enroll", u.ChallengeEnroll, throttled)
+	app.Handle("POST", "/v1/users/verify", u.Verify, throttled)
+	app.Handle("POST", "/v1/users/verify/resend", u.ResendVerification, throttled)
+	app.Handle("POST", "/v1/users/password/forgot", u.ForgotPassword, throttled)
+	app.Handle("POST", "/v1/users/password/reset", u.ResetPassword, throttled)
+
+	// A token needs no scope to revoke itself or to be narrowed further.
+	app.Handle("POST", "/v1/users/token/revoke", u.RevokeToken, authenticate)
+	app.Handle("POST", "/v1/users/token/scoped", u.ScopedToken, authenticate, sensitive)
+	app.Handle("POST", "/v1/users/mfa/enroll", u.EnrollMFA, authenticate, scope(auth.PermUsersWrite), sensitive)
+	app.Handle("POST", "/v1/users/mfa/confirm", u.ConfirmMFA, authenticate, scope(auth.PermUsersWrite), sensitive)
+	app.Handle("DELETE", "/v1/users/mfa", u.DisableMFA, authenticate, scope(auth.PermUsersWrite), sensitive)
+	app.Handle("GET", "/v1/users", u.List, authenticate, scope(auth.PermUsersRead))
+	app.Handle("POST", "/v1/users", u.Create, authenticate, scope(auth.PermUsersWrite), sensitive)
+	app.Handle("GET", "/v1/users/:id", u.Retrieve, authenticate, scope(auth.PermUsersRead))
+	app.Handle("PUT", "/v1/users/:id", u.Update, authenticate, scope(auth.PermUsersWrite), sensitive)
+	app.Handle("PATCH", "/v1/users/:id", u.Patch, authenticate, scope(auth.PermUsersWrite), sensitive)
+	app.Handle("DELETE", "/v1/users/:id", u.Delete, authenticate, scope(auth.PermUsersWrite), sensitive)
+	app.Handle("POST", "/v1/users/:id/restore", u.Restore, authenticate, scope(auth.PermUsersWrite), sensitive)
+	app.Handle("POST", "/v1/users/:id/revoke", u.RevokeAll, authenticate, scope(auth.PermUsersWrite), sensitive)
+	app.Handle("GET", "/v1/users/:id/sessions", u.Sessions, authenticate, scope(auth.PermUsersRead))
+	app.Handle("DELETE", "/v1/users/:id/sessions", u.RevokeAll, authenticate, scope(auth.PermUsersWrite), sensitive)
+	app.Handle("DELETE", "/v1/users/:id/sessions/:sid", u.TerminateSession, authenticate, scope(auth.PermUsersWrite), sensitive)
+	app.Handle("POST", "/v1/users/:id/impersonate", u.Impersonate, authenticate, scope(auth.PermUsersWrite), sensitive)
 
 	a := Account{
 		db:            db,
 		authenticator: authenticator,
+		revocations:   revocations,
 	}
 	// Register accounts management endpoints.
-	app.Handle("GET", "/v1/accounts", a.List, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin, auth.RoleUser))
+	app.Handle("GET", "/v1/accounts", a.List, authenticate, scope(auth.PermAccountsRead))
+	app.Handle("PUT", "/v1/accounts/:id", a.Update, authenticate, scope(auth.PermAccountsWrite), sensitive)
+	app.Handle("DELETE", "/v1/accounts/:id", a.Delete, authenticate, scope(auth.PermAccountsWrite), sensitive)
+	app.Handle("POST", "/v1/accounts/:id/restore", a.Restore, authenticate, scope(auth.PermAccountsWrite), sensitive)
+
+	ro := Role{
+		db: db,
+	}
+	// Register role management endpoints.
+	app.Handle("GET", "/v1/roles", ro.List, authenticate, scope(auth.PermRolesRead))
+	app.Handle("POST", "/v1/roles", ro.Create, authenticate, scope(auth.PermRolesWrite), sensitive)
+	app.Handle("GET", "/v1/roles/:id", ro.Retrieve, authenticate, scope(auth.PermRolesRead))
+	app.Handle("PUT", "/v1/roles/:id", ro.Update, authenticate, scope(auth.PermRolesWrite), sensitive)
+	app.Handle("DELETE", "/v1/roles/:id", ro.Delete, authenticate, scope(auth.PermRolesWrite), sensitive)
+
+	ak := APIKey{
+		db: db,
+	}
+	// Register API key management endpoints.
+	app.Handle("GET", "/v1/apikeys", ak.List, authenticate, scope(auth.PermAPIKeysRead))
+	app.Handle("POST", "/v1/apikeys", ak.Create, authenticate, scope(auth.PermAPIKeysWrite), sensitive)
+	app.Handle("DELETE", "/v1/apikeys/:id", ak.Revoke, authenticate, scope(auth.PermAPIKeysWrite), sensitive)
 
 	return app
 }
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/platform/web"
+	"github.com/sankarvj/seedgo/internal/policy"
+	"github.com/sankarvj/seedgo/internal/refresh"
+	"github.com/sankarvj/seedgo/internal/session"
+	"github.com/sankarvj/seedgo/internal/user"
+	"go.opencensus.io/trace"
+)
+
+// Sessions returns the active sessions of the specified user. Users may list
+// their own sessions, users allowed to read users may list anyone's.
+func (u *User) Sessions(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.Sessions")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if _, err := user.RetrieveFor(ctx, claims, u.db, params["id"], policy.SessionList); err != nil {
+		return userError(err, params["id"])
+	}
+
+	sessions, err := session.List(ctx, u.db, params["id"])
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, sessions, http.StatusOK)
+}
+
+// TerminateSession logs the specified user out of one session. The refresh
+// tokens of the session are revoked and its access tokens are rejected from
+// now on.
+func (u *User) TerminateSession(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.TerminateSession")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if _, err := user.RetrieveFor(ctx, claims, u.db, params["id"], policy.UserLogout); err != nil {
+		return userError(err, params["id"])
+	}
+
+	if err := session.Terminate(ctx, u.db, params["id"], params["sid"], v.Now); err != nil {
+		switch err {
+		case session.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case session.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrapf(err, "Id: %s", params["sid"])
+		}
+	}
+
+	if err := refresh.RevokeFamily(ctx, u.db, params["sid"], v.Now); err != nil {
+		return errors.Wrap(err, "revoking refresh tokens")
+	}
+
+	// Access tokens of the session outlive it by at most their TTL.
+	expiresAt := v.Now.Add(u.opts.AccessTokenTTL)
+	if err := u.revocations.RevokeSession(ctx, params["sid"], expiresAt, v.Now); err != nil {
+		return errors.Wrap(err, "revoking access tokens")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// remoteIP returns the address of the client without its port.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
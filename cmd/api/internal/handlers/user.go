@@ -2,39 +2,95 @@ package handlers
 
 import (
 	"context"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
-	firebase "firebase.google.com/go"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/account"
+	"github.com/sankarvj/seedgo/internal/mfa"
 	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/platform/throttle"
 	"github.com/sankarvj/seedgo/internal/platform/web"
+	"github.com/sankarvj/seedgo/internal/policy"
+	"github.com/sankarvj/seedgo/internal/refresh"
+	"github.com/sankarvj/seedgo/internal/reset"
+	"github.com/sankarvj/seedgo/internal/revocation"
+	"github.com/sankarvj/seedgo/internal/role"
+	"github.com/sankarvj/seedgo/internal/session"
 	"github.com/sankarvj/seedgo/internal/user"
+	"github.com/sankarvj/seedgo/internal/verify"
 	"go.opencensus.io/trace"
-	"google.golang.org/api/option"
 )
 
 // User represents the User API method handler set.
 type User struct {
+	log           *log.Logger
 	db            *sqlx.DB
 	authenticator *auth.Authenticator
+	provider      auth.IdentityProvider
+	revocations   *revocation.Store
+	verifier      *verify.Verifier
+	resetter      *reset.Resetter
+	throttle      *throttle.Throttle
+	ips           *throttle.Throttle
+	opts          Options
 	// ADD OTHER STATE LIKE THE LOGGER AND CONFIG HERE.
 }
 
-// List returns all the existing users in the system.
+// tokenResponse is the document returned by the endpoints issuing tokens.
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// challengeResponse is the document returned instead of tokens when the login
+// needs a second factor. The challenge is exchanged for tokens along with a
+// code. When enrollment is required the factor has to be enrolled with the
+// challenge first, which exchanges it for the challenge to answer.
+type challengeResponse struct {
+	MFARequired        bool   `json:"mfa_required"`
+	EnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
+	Challenge          string `json:"challenge"`
+	ExpiresIn          int64  `json:"expires_in"`
+}
+
+// List returns a page of the users of the caller's account. The query
+// string holds the filters, the sort order and the cursor of the page.
 func (u *User) List(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 	ctx, span := trace.StartSpan(ctx, "handlers.User.List")
 	defer span.End()
 
-	users, err := user.List(ctx, u.db)
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var f user.ListUsers
+	if err := web.DecodeQuery(r, &f); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	page, err := user.List(ctx, claims, u.db, f)
 	if err != nil {
-		return err
+		switch err {
+		case user.ErrInvalidCursor:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case user.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrap(err, "listing users")
+		}
 	}
 
-	return web.Respond(ctx, w, users, http.StatusOK)
+	return web.Respond(ctx, w, page, http.StatusOK)
 }
 
-// Retrieve returns the specified user from the system.
+// Retrieve returns the specified user from the system. Its version is sent
+// as the ETag so it can be updated conditionally.
 func (u *User) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 	ctx, span := trace.StartSpan(ctx, "handlers.User.Retrieve")
 	defer span.End()
@@ -58,6 +114,8 @@ func (u *User) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Requ
 		}
 	}
 
+	w.Header().Set("ETag", web.ETag(usr.Version))
+
 	return web.Respond(ctx, w, usr, http.StatusOK)
 }
 
@@ -71,20 +129,38 @@ func (u *User) Create(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return web.NewShutdownError("web value missing from context")
 	}
 
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
 	var nu user.NewUser
 	if err := web.Decode(r, &nu); err != nil {
 		return errors.Wrap(err, "")
 	}
 
-	usr, err := user.Create(ctx, u.db, nu, v.Now)
+	usr, err := user.Create(ctx, claims, u.db, nu, v.Now)
 	if err != nil {
-		return errors.Wrapf(err, "User: %+v", &usr)
+		switch err {
+		case role.ErrNotFound:
+			return web.NewRequestError(errors.New("unknown role"), http.StatusBadRequest)
+		case account.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case user.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "User: %+v", &usr)
+		}
 	}
 
+	u.sendVerification(ctx, usr, v.Now)
+
 	return web.Respond(ctx, w, usr, http.StatusCreated)
 }
 
-// Update updates the specified user in the system.
+// Update updates the specified user in the system. An If-Match header holding
+// the ETag of the user makes the update fail with 412 Precondition Failed if
+// the user changed since it was read.
 func (u *User) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 	ctx, span := trace.StartSpan(ctx, "handlers.User.Update")
 	defer span.End()
@@ -99,13 +175,36 @@ func (u *User) Update(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return errors.New("claims missing from context")
 	}
 
+	version, err := web.IfMatch(r)
+	if err != nil {
+		return err
+	}
+
 	var upd user.UpdateUser
 	if err := web.Decode(r, &upd); err != nil {
 		return errors.Wrap(err, "")
 	}
 
-	err := user.Update(ctx, claims, u.db, params["id"], upd, v.Now)
-	if err != nil {
+	// Tokens carry the roles they were issued with so a role change must
+	// revoke them, and a new email must be verified again. Capture the current
+	// roles and email before they are replaced.
+	var before *user.User
+	if upd.Roles != nil || upd.Email != nil {
+		var err error
+		if before, err = user.Retrieve(ctx, claims, u.db, params["id"]); err != nil {
+			return userError(err, params["id"])
+		}
+	}
+	if upd.Roles != nil {
+		if err := role.Exist(ctx, u.db, before.AccountID, upd.Roles); err != nil {
+			if err == role.ErrNotFound {
+				return web.NewRequestError(errors.New("unknown role"), http.StatusBadRequest)
+			}
+			return err
+		}
+	}
+
+	if err := user.Update(ctx, claims, u.db, params["id"], upd, version, v.Now); err != nil {
 		switch err {
 		case user.ErrInvalidID:
 			return web.NewRequestError(err, http.StatusBadRequest)
@@ -113,20 +212,126 @@ func (u *User) Update(ctx context.Context, w http.ResponseWriter, r *http.Reques
 			return web.NewRequestError(err, http.StatusNotFound)
 		case user.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
+		case user.ErrVersionMismatch:
+			return web.NewRequestError(err, http.StatusPreconditionFailed)
 		default:
 			return errors.Wrapf(err, "ID: %s  User: %+v", params["id"], &upd)
 		}
 	}
 
+	if upd.Roles != nil && !sameRoles(before.Roles, upd.Roles) {
+		if err := logoutUser(ctx, u.db, u.revocations, params["id"], v.Now); err != nil {
+			return err
+		}
+	}
+
+	// A new email has to be verified again.
+	if upd.Email != nil && *upd.Email != before.Email {
+		usr, err := user.Retrieve(ctx, claims, u.db, params["id"])
+		if err != nil {
+			return userError(err, params["id"])
+		}
+		u.sendVerification(ctx, usr, v.Now)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Patch applies a JSON merge patch (RFC 7396) to the specified user. Members
+// patched to null are cleared. An If-Match header holding the ETag of the
+// user makes the patch fail with 412 Precondition Failed if the user changed
+// since it was read.
+func (u *User) Patch(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.Patch")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	version, err := web.IfMatch(r)
+	if err != nil {
+		return err
+	}
+
+	before, err := user.RetrieveFor(ctx, claims, u.db, params["id"], policy.UserUpdate)
+	if err != nil {
+		return userError(err, params["id"])
+	}
+
+	// The patch applies to the version just read unless the client names the
+	// version it saw.
+	if version == 0 {
+		version = before.Version
+	}
+
+	doc := user.PatchDocument(before)
+	if err := web.DecodeMergePatch(r, &doc); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	rolesChanged := !sameRoles(before.Roles, doc.Roles)
+	if rolesChanged {
+		if err := role.Exist(ctx, u.db, before.AccountID, doc.Roles); err != nil {
+			if err == role.ErrNotFound {
+				return web.NewRequestError(errors.New("unknown role"), http.StatusBadRequest)
+			}
+			return err
+		}
+	}
+
+	if err := user.Patch(ctx, claims, u.db, params["id"], doc, version, v.Now); err != nil {
+		switch err {
+		case user.ErrVersionMismatch:
+			return web.NewRequestError(err, http.StatusPreconditionFailed)
+		default:
+			return userError(err, params["id"])
+		}
+	}
+
+	// Tokens carry the roles they were issued with so a role change must
+	// revoke them.
+	if rolesChanged {
+		if err := logoutUser(ctx, u.db, u.revocations, params["id"], v.Now); err != nil {
+			return err
+		}
+	}
+
+	// A new email has to be verified again.
+	if doc.Email != before.Email {
+		usr, err := user.Retrieve(ctx, claims, u.db, params["id"])
+		if err != nil {
+			return userError(err, params["id"])
+		}
+		u.sendVerification(ctx, usr, v.Now)
+	}
+
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
-// Delete removes the specified user from the system.
+// Delete removes the specified user from the system and logs them out
+// everywhere. The user can be restored until they are purged.
 func (u *User) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 	ctx, span := trace.StartSpan(ctx, "handlers.User.Delete")
 	defer span.End()
 
-	err := user.Delete(ctx, u.db, params["id"])
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	err := user.Delete(ctx, claims, u.db, params["id"], v.Now)
 	if err != nil {
 		switch err {
 		case user.ErrInvalidID:
@@ -140,13 +345,17 @@ func (u *User) Delete(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	if err := logoutUser(ctx, u.db, u.revocations, params["id"], v.Now); err != nil {
+		return err
+	}
+
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
-// Token handles a request to authenticate a user. It expects a request using
-// Code and Provider
-func (u *User) Token(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
-	ctx, span := trace.StartSpan(ctx, "handlers.User.Token")
+// Restore brings back the specified deleted user. They log in again as
+// their sessions were ended when they were deleted.
+func (u *User) Restore(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.Restore")
 	defer span.End()
 
 	v, ok := ctx.Value(web.KeyValues).(*web.Values)
@@ -154,52 +363,975 @@ func (u *User) Token(ctx context.Context, w http.ResponseWriter, r *http.Request
 		return web.NewShutdownError("web value missing from context")
 	}
 
-	opt := option.WithCredentialsFile(u.authenticator.GoogleKeyFile)
-	// Initialize default app
-	app, err := firebase.NewApp(context.Background(), nil, opt)
-	if err != nil {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := user.Restore(ctx, claims, u.db, params["id"], v.Now); err != nil {
+		return userError(err, params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ScopedToken issues the caller a token narrowed to the requested scopes, such
+// as for an integration or a read-only view. Scopes are named after
+// permissions and can only narrow what the caller's token already grants. The
+// token cannot be refreshed and lives at most ScopedTokenMaxTTL, and never
+// longer than the caller's token. API keys cannot be exchanged for tokens.
+func (u *User) ScopedToken(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.ScopedToken")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	// A token would outlive the revocation of the key it came from.
+	if claims.APIKey {
+		return web.NewRequestError(errors.New("API keys cannot be exchanged for tokens"), http.StatusForbidden)
+	}
+
+	var sr user.ScopedTokenRequest
+	if err := web.Decode(r, &sr); err != nil {
 		return errors.Wrap(err, "")
 	}
 
-	// Access auth service from the default app
-	client, err := app.Auth(context.Background())
+	for _, s := range sr.Scope {
+		var known bool
+		for _, p := range auth.Permissions {
+			if s == p {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return web.NewRequestError(errors.Errorf("Unknown scope %q", s), http.StatusBadRequest)
+		}
+	}
+	if !claims.HasPermission(sr.Scope...) || !claims.HasScope(sr.Scope...) {
+		return web.NewRequestError(user.ErrForbidden, http.StatusForbidden)
+	}
+
+	ttl := u.opts.AccessTokenTTL
+	if sr.ExpiresIn > 0 {
+		ttl = time.Duration(sr.ExpiresIn) * time.Second
+	}
+	if max := u.opts.scopedTokenMaxTTL(); ttl > max {
+		ttl = max
+	}
+	if remaining := time.Unix(claims.ExpiresAt, 0).Sub(v.Now); ttl > remaining {
+		ttl = remaining
+	}
+
+	scoped := auth.NewClaims(claims.Subject, claims.Roles, v.Now, ttl)
+	scoped.AccountID = claims.AccountID
+	scoped.Permissions = claims.Permissions
+	scoped.SessionID = claims.SessionID
+	scoped.Scope = strings.Join(sr.Scope, " ")
+
+	tkn := tokenResponse{
+		ExpiresIn: int64(ttl / time.Second),
+	}
+	var err error
+	tkn.Token, err = u.authenticator.GenerateToken(scoped)
 	if err != nil {
+		return errors.Wrap(err, "generating token")
+	}
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// RevokeToken revokes a single access token. Without a jti in the request the
+// token used to make the request is revoked, which logs the caller out. Only
+// users allowed to write users may revoke other tokens and they must name the
+// user the token was issued to.
+func (u *User) RevokeToken(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.RevokeToken")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var rt revocation.RevokeToken
+	if err := web.Decode(r, &rt); err != nil {
 		return errors.Wrap(err, "")
 	}
 
-	token, err := client.VerifyIDToken(ctx, params["id"])
+	jti, userID, expiresAt := claims.Id, claims.Subject, time.Unix(claims.ExpiresAt, 0)
+	if rt.JTI != "" && rt.JTI != claims.Id {
+		if rt.UserID == "" {
+			return web.NewRequestError(errors.New("user_id is required to revoke another token"), http.StatusBadRequest)
+		}
+
+		// Only a token issued to the user is revoked so naming a user of the
+		// caller's account cannot revoke tokens of another account.
+		if _, err := user.RetrieveFor(ctx, claims, u.db, rt.UserID, policy.TokenRevoke); err != nil {
+			return userError(err, rt.UserID)
+		}
+
+		// The expiry of another token is unknown but no token outlives the
+		// longest lifetime one can be issued with.
+		jti, userID, expiresAt = rt.JTI, rt.UserID, v.Now.Add(u.opts.maxTokenTTL())
+	}
+
+	if err := u.revocations.RevokeToken(ctx, jti, userID, expiresAt, v.Now); err != nil {
+		return errors.Wrap(err, "revoking token")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// RevokeAll logs the specified user out everywhere: every session is
+// terminated and every access and refresh token issued to them is revoked.
+// Users may revoke their own tokens, users allowed to write users may revoke
+// anyone's.
+func (u *User) RevokeAll(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.RevokeAll")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if _, err := user.RetrieveFor(ctx, claims, u.db, params["id"], policy.UserLogout); err != nil {
+		return userError(err, params["id"])
+	}
+
+	if err := logoutUser(ctx, u.db, u.revocations, params["id"], v.Now); err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Impersonate issues a short-lived access token for the specified user to an
+// admin of their account. The token names the admin as its actor, cannot be
+// refreshed and cannot be used for sensitive actions. Admins cannot
+// impersonate themselves and only superadmins may impersonate superadmins.
+func (u *User) Impersonate(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.Impersonate")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	target, err := user.RetrieveFor(ctx, claims, u.db, params["id"], policy.UserImpersonate)
+	if err != nil {
+		return userError(err, params["id"])
+	}
+
+	imp, err := user.Claims(ctx, u.db, v.Now, target.ID, u.opts.ImpersonationTTL)
 	if err != nil {
-		return errors.Wrap(err, "verifying token with firebase")
+		return errors.Wrapf(err, "Id: %s", target.ID)
 	}
+	imp.Actor = &auth.Actor{Subject: claims.Subject}
 
-	userRecord, err := client.GetUser(ctx, token.UID)
+	tkn := tokenResponse{
+		ExpiresIn: int64(u.opts.ImpersonationTTL / time.Second),
+	}
+	tkn.Token, err = u.authenticator.GenerateToken(imp)
 	if err != nil {
-		return errors.Wrap(err, "fetching user from token UID")
+		return errors.Wrap(err, "generating token")
+	}
+
+	u.log.Printf("%s : IMPERSONATE : %s acting as %s until %s",
+		v.TraceID, claims.Subject, target.ID,
+		time.Unix(imp.ExpiresAt, 0).UTC().Format(time.RFC3339),
+	)
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// logoutUser terminates every session of the user and revokes every access
+// and refresh token issued to them.
+func logoutUser(ctx context.Context, db *sqlx.DB, revocations *revocation.Store, userID string, now time.Time) error {
+	if err := session.TerminateAll(ctx, db, userID, now); err != nil {
+		return errors.Wrap(err, "terminating sessions")
+	}
+	if err := revocations.RevokeUser(ctx, userID, now); err != nil {
+		return errors.Wrap(err, "revoking access tokens")
+	}
+	if err := refresh.RevokeUser(ctx, db, userID, now); err != nil {
+		return errors.Wrap(err, "revoking refresh tokens")
+	}
+	return nil
+}
+
+// userError translates the errors returned when looking up a user into
+// request errors.
+func userError(err error, id string) error {
+	switch err {
+	case user.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case user.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case user.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrapf(err, "Id: %s", id)
+	}
+}
+
+// sameRoles reports whether both lists hold the same set of roles.
+func sameRoles(a, b []string) bool {
+	set := make(map[string]bool, len(a))
+	for _, r := range a {
+		set[r] = true
+	}
+	for _, r := range b {
+		if !set[r] {
+			return false
+		}
+		delete(set, r)
+	}
+	return len(set) == 0
+}
+
+// Verify marks the email of a user as verified. It expects the token mailed to
+// the user in the request body.
+func (u *User) Verify(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.Verify")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var vr verify.VerifyRequest
+	if err := web.Decode(r, &vr); err != nil {
+		return errors.Wrap(err, "")
 	}
 
-	claims, err := user.Authenticate(ctx, u.db, v.Now, userRecord.Email, token.UID)
+	userID, email, err := u.verifier.Parse(vr.Token)
 	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	if err := user.Verify(ctx, u.db, userID, email, v.Now); err != nil {
 		switch err {
-		case user.ErrAuthenticationFailure:
-			return web.NewRequestError(err, http.StatusUnauthorized)
+		case user.ErrInvalidID, user.ErrNotFound:
+			return web.NewRequestError(verify.ErrInvalidToken, http.StatusBadRequest)
 		default:
-			return errors.Wrap(err, "authenticating")
+			return errors.Wrapf(err, "Id: %s", userID)
 		}
 	}
 
-	var tkn struct {
-		Token string `json:"token"`
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ResendVerification mails a new verification token to the users with the
+// email in the request body. The response is the same whether or not such a
+// user exists. Requests are throttled per email and per IP address.
+func (u *User) ResendVerification(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.ResendVerification")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
 	}
-	tkn.Token, err = u.authenticator.GenerateToken(claims)
-	if err != nil {
-		return errors.Wrap(err, "generating token")
+
+	var rr verify.ResendRequest
+	if err := web.Decode(r, &rr); err != nil {
+		return errors.Wrap(err, "")
 	}
 
-	//dbuser, err := model.CreateNewUserIfNotExists(name, email, phone, avatar, provider, uid, token.Expires, token.IssuedAt, emailVerified)
+	if err := u.throttleMail(w, r, rr.Email, v.TraceID, v.Now); err != nil {
+		return err
+	}
 
+	users, err := user.ListUnverified(ctx, u.db, rr.Email)
 	if err != nil {
-		return errors.Wrap(err, "")
+		return err
 	}
 
-	return web.Respond(ctx, w, tkn, http.StatusOK)
+	// Mail in the background so the time taken to respond does not reveal
+	// whether the email belongs to an unverified user.
+	traceID, now := v.TraceID, v.Now
+	go func() {
+		for _, usr := range users {
+			if usr.Email == "" {
+				continue
+			}
+			if err := u.verifier.Send(context.Background(), usr.ID, usr.Email, now); err != nil {
+				u.log.Printf("%s : ERROR : sending verification to user %s : %v", traceID, usr.ID, err)
+			}
+		}
+	}()
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ForgotPassword mails a password reset link to the users with the email in
+// the request body. The response is the same whether or not such a user
+// exists. Requests are throttled per email and per IP address.
+func (u *User) ForgotPassword(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.ForgotPassword")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var fr reset.ForgotRequest
+	if err := web.Decode(r, &fr); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	if err := u.throttleMail(w, r, fr.Email, v.TraceID, v.Now); err != nil {
+		return err
+	}
+
+	users, err := user.ListByEmail(ctx, u.db, fr.Email)
+	if err != nil {
+		return err
+	}
+
+	// Mail in the background so the time taken to respond does not reveal
+	// whether the email belongs to a user.
+	traceID, now := v.TraceID, v.Now
+	go func() {
+		for _, usr := range users {
+			if err := u.resetter.Send(context.Background(), usr.ID, usr.Email, now); err != nil {
+				u.log.Printf("%s : ERROR : sending password reset to user %s : %v", traceID, usr.ID, err)
+			}
+		}
+	}()
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ResetPassword sets a new password for the user a reset token was mailed to.
+// Every access and refresh token of the user is revoked.
+func (u *User) ResetPassword(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.ResetPassword")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var rr reset.ResetRequest
+	if err := web.Decode(r, &rr); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	// The token is consumed only once the password is set and the user is
+	// logged out, so a failure leaves it usable for another attempt.
+	err := u.resetter.Redeem(ctx, rr.Token, v.Now, func(ctx context.Context, userID string) error {
+		if err := user.SetPassword(ctx, u.db, userID, rr.Password, v.Now); err != nil {
+			return err
+		}
+		return logoutUser(ctx, u.db, u.revocations, userID, v.Now)
+	})
+	if err != nil {
+		switch err {
+		case reset.ErrInvalidToken:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case user.ErrNotFound:
+			return web.NewRequestError(reset.ErrInvalidToken, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "resetting password")
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// throttleMail counts every request mailing the email against both the email
+// and the IP address of the client, whether or not a mail is sent, so the
+// endpoints cannot be used to flood an inbox. It returns the error refusing
+// the request while either of them has to wait.
+func (u *User) throttleMail(w http.ResponseWriter, r *http.Request, email, traceID string, now time.Time) error {
+	emailKey := "mail:" + strings.ToLower(email)
+	ipKey := "mail:" + remoteIP(r)
+
+	if wait := u.throttle.Wait(emailKey, now); wait > 0 {
+		return throttle.Reject(w, wait)
+	}
+	if wait := u.ips.Wait(ipKey, now); wait > 0 {
+		return throttle.Reject(w, wait)
+	}
+
+	if wait, locked := u.throttle.Fail(emailKey, now); locked {
+		u.log.Printf("%s : LOCKOUT : %s locked out for %s", traceID, emailKey, wait)
+	}
+	if wait, locked := u.ips.Fail(ipKey, now); locked {
+		u.log.Printf("%s : LOCKOUT : %s locked out for %s", traceID, ipKey, wait)
+	}
+
+	return nil
+}
+
+// sendVerification mails a verification token to the user unless their email
+// is already verified. Failures are logged rather than returned so they do
+// not fail the request that triggered the email.
+func (u *User) sendVerification(ctx context.Context, usr *user.User, now time.Time) {
+	if usr.Verified || usr.Email == "" {
+		return
+	}
+
+	if err := u.verifier.Send(ctx, usr.ID, usr.Email, now); err != nil {
+		v, _ := ctx.Value(web.KeyValues).(*web.Values)
+		var traceID string
+		if v != nil {
+			traceID = v.TraceID
+		}
+		u.log.Printf("%s : ERROR : sending verification to user %s : %v", traceID, usr.ID, err)
+	}
+}
+
+// requireVerified returns a request error if verification is required and the
+// email of the authenticated user is not verified.
+func (u *User) requireVerified(ctx context.Context, claims auth.Claims) error {
+	if !u.opts.RequireVerified {
+		return nil
+	}
+
+	usr, err := user.Retrieve(ctx, claims, u.db, claims.Subject)
+	if err != nil {
+		return userError(err, claims.Subject)
+	}
+	if !usr.Verified {
+		return web.NewRequestError(user.ErrNotVerified, http.StatusForbidden)
+	}
+
+	return nil
+}
+
+// Token handles a request to authenticate a user with a credential issued by
+// the configured identity provider, such as a Firebase ID token.
+func (u *User) Token(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.Token")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	identity, err := u.provider.Verify(ctx, params["id"])
+	if err != nil {
+		if errors.Cause(err) == auth.ErrInvalidCredential {
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		}
+		return errors.Wrapf(err, "verifying credential with %s", u.provider.Name())
+	}
+
+	// Verified identities that are refused are throttled by subject.
+	key := identity.Provider + ":" + identity.Subject
+	if wait := u.throttle.Wait(key, v.Now); wait > 0 {
+		return throttle.Reject(w, wait)
+	}
+
+	claims, err := user.AuthenticateProvider(ctx, u.db, v.Now, identity.Provider, identity.Subject, u.opts.AccessTokenTTL)
+	if err == user.ErrAuthenticationFailure && u.opts.ProvisionRule != "" {
+		claims, err = u.provision(ctx, identity, v.Now)
+	}
+	if err != nil {
+		switch err {
+		case user.ErrAuthenticationFailure:
+			if wait, locked := u.throttle.Fail(key, v.Now); locked {
+				u.log.Printf("%s : LOCKOUT : %s locked out for %s", v.TraceID, key, wait)
+			}
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "authenticating")
+		}
+	}
+	u.throttle.Reset(key)
+
+	resp, err := u.login(ctx, r, claims, v.Now)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// These are the rules choosing the account of a user provisioned on their
+// first federated login.
+const (
+
+	// ProvisionByDomain adds the user to the account whose domain matches the
+	// domain of their email. The email must be verified by the provider.
+	ProvisionByDomain = "domain"
+
+	// ProvisionPersonal creates a new account owned by the user.
+	ProvisionPersonal = "personal"
+)
+
+// provision creates the user for an identity logging in for the first time and
+// returns their claims. The account is chosen by the configured rule.
+func (u *User) provision(ctx context.Context, id auth.Identity, now time.Time) (auth.Claims, error) {
+	if id.Email == "" {
+		return auth.Claims{}, user.ErrAuthenticationFailure
+	}
+
+	var accountID string
+	roles := []string{auth.RoleUser}
+
+	switch u.opts.ProvisionRule {
+	case ProvisionByDomain:
+		if !id.EmailVerified {
+			return auth.Claims{}, user.ErrAuthenticationFailure
+		}
+
+		domain := id.Email[strings.LastIndex(id.Email, "@")+1:]
+		a, err := account.RetrieveByDomain(ctx, u.db, domain)
+		if err != nil {
+			if err == account.ErrNotFound {
+				return auth.Claims{}, user.ErrAuthenticationFailure
+			}
+			return auth.Claims{}, err
+		}
+		accountID = a.ID
+
+	case ProvisionPersonal:
+
+		// Personal accounts have no domain of their own. The email is used to
+		// keep the domain unique without ever matching an email domain.
+		name := id.Name
+		if name == "" {
+			name = id.Email
+		}
+		a, err := account.Create(ctx, u.db, account.NewAccount{Name: name, Domain: id.Email}, now)
+		if err != nil {
+			return auth.Claims{}, err
+		}
+		accountID = a.ID
+		roles = []string{auth.RoleAdmin, auth.RoleUser}
+
+	default:
+		return auth.Claims{}, errors.Errorf("unknown provision rule %q", u.opts.ProvisionRule)
+	}
+
+	usr, err := user.Provision(ctx, u.db, accountID, roles, id, now)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	u.sendVerification(ctx, usr, now)
+
+	return user.Claims(ctx, u.db, now, usr.ID, u.opts.AccessTokenTTL)
+}
+
+// Login handles a password grant. It expects the email and password of the
+// user in the request body.
+func (u *User) Login(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.Login")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var cred user.Credentials
+	if err := web.Decode(r, &cred); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	// Throttle guesses against the email whatever address they come from.
+	key := "email:" + strings.ToLower(cred.Email)
+	if wait := u.throttle.Wait(key, v.Now); wait > 0 {
+		return throttle.Reject(w, wait)
+	}
+
+	claims, err := user.Authenticate(ctx, u.db, v.Now, cred.AccountID, cred.Email, cred.Password, u.opts.AccessTokenTTL)
+	if err != nil {
+		switch err {
+		case user.ErrAccountRequired:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case user.ErrAuthenticationFailure:
+			if wait, locked := u.throttle.Fail(key, v.Now); locked {
+				u.log.Printf("%s : LOCKOUT : %s locked out for %s", v.TraceID, key, wait)
+			}
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "authenticating")
+		}
+	}
+	u.throttle.Reset(key)
+
+	resp, err := u.login(ctx, r, claims, v.Now)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. Presenting a refresh token twice revokes every token descending from
+// the same login.
+func (u *User) Refresh(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.Refresh")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var rr refresh.RefreshRequest
+	if err := web.Decode(r, &rr); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	t, next, err := refresh.Rotate(ctx, u.db, rr.RefreshToken, v.Now, u.opts.RefreshTokenTTL)
+	if err != nil {
+		switch err {
+		case refresh.ErrInvalidToken, refresh.ErrTokenReused:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "rotating refresh token")
+		}
+	}
+
+	claims, err := user.Claims(ctx, u.db, v.Now, t.UserID, u.opts.AccessTokenTTL)
+	if err != nil {
+		switch err {
+		case user.ErrAuthenticationFailure:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "building claims")
+		}
+	}
+
+	// The refresh token family is the session the login started.
+	claims.SessionID = t.FamilyID
+	if err := session.Touch(ctx, u.db, t.FamilyID, remoteIP(r), v.Now); err != nil {
+		return err
+	}
+
+	tkn := tokenResponse{
+		RefreshToken: next,
+		ExpiresIn:    int64(u.opts.AccessTokenTTL / time.Second),
+	}
+	tkn.Token, err = u.authenticator.GenerateToken(claims)
+	if err != nil {
+		return errors.Wrap(err, "generating token")
+	}
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// login completes the first step of a login. Users with a second factor, and
+// admins of accounts requiring one, get a challenge instead of tokens.
+func (u *User) login(ctx context.Context, r *http.Request, claims auth.Claims, now time.Time) (interface{}, error) {
+	if err := u.requireVerified(ctx, claims); err != nil {
+		return nil, err
+	}
+
+	enabled, err := mfa.Enabled(ctx, u.db, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var enroll bool
+	if !enabled && claims.HasRole(auth.RoleAdmin, auth.RoleSuperAdmin) {
+		enroll, err = account.MFARequiredForAdmins(ctx, u.db, claims.AccountID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !enabled && !enroll {
+		return u.issue(ctx, r, claims, now)
+	}
+
+	challenge, err := mfa.NewChallenge(ctx, u.db, claims.Subject, now, u.opts.MFAChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := challengeResponse{
+		MFARequired:        true,
+		EnrollmentRequired: enroll,
+		Challenge:          challenge,
+		ExpiresIn:          int64(u.opts.MFAChallengeTTL / time.Second),
+	}
+
+	return resp, nil
+}
+
+// Challenge handles the second step of a login. It exchanges the challenge
+// returned by the first step and a TOTP or recovery code for tokens.
+func (u *User) Challenge(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.Challenge")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var cr mfa.ChallengeRequest
+	if err := web.Decode(r, &cr); err != nil {
+		return errors.Wrap(err, "")
+	}
+	if cr.Code == "" {
+		return web.NewRequestError(mfa.ErrInvalidCode, http.StatusBadRequest)
+	}
+
+	userID, err := mfa.Answer(ctx, u.db, cr.Challenge, cr.Code, v.Now)
+	if err != nil {
+		switch err {
+		case mfa.ErrInvalidChallenge, mfa.ErrInvalidCode:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "answering challenge")
+		}
+	}
+
+	claims, err := user.Claims(ctx, u.db, v.Now, userID, u.opts.AccessTokenTTL)
+	if err != nil {
+		switch err {
+		case user.ErrAuthenticationFailure:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "building claims")
+		}
+	}
+
+	tkn, err := u.issue(ctx, r, claims, v.Now)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// ChallengeEnroll starts the enrollment of a second factor for a user who
+// must have one to log in. The challenge returned by the first login step
+// stands in for an access token and is exchanged for a new one to answer.
+func (u *User) ChallengeEnroll(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.ChallengeEnroll")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var cr mfa.ChallengeRequest
+	if err := web.Decode(r, &cr); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	userID, challenge, err := mfa.ChallengeEnroll(ctx, u.db, cr.Challenge, v.Now)
+	if err != nil {
+		switch err {
+		case mfa.ErrInvalidChallenge:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "exchanging challenge")
+		}
+	}
+
+	claims, err := user.Claims(ctx, u.db, v.Now, userID, u.opts.AccessTokenTTL)
+	if err != nil {
+		switch err {
+		case user.ErrAuthenticationFailure:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "building claims")
+		}
+	}
+
+	return u.enroll(ctx, w, claims, challenge, v.Now)
+}
+
+// EnrollMFA starts the enrollment of a second factor for the authenticated
+// user. The factor is enabled once confirmed with a first code.
+func (u *User) EnrollMFA(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.EnrollMFA")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	return u.enroll(ctx, w, claims, "", v.Now)
+}
+
+// enroll starts the enrollment of a second factor for the user of the claims
+// and responds with the secret, the recovery codes and the challenge to answer
+// if the user is enrolling during a login.
+func (u *User) enroll(ctx context.Context, w http.ResponseWriter, claims auth.Claims, challenge string, now time.Time) error {
+	usr, err := user.RetrieveFor(ctx, claims, u.db, claims.Subject, policy.UserMFA)
+	if err != nil {
+		return userError(err, claims.Subject)
+	}
+
+	e, err := mfa.Enroll(ctx, u.db, usr.ID, u.opts.MFAIssuer, usr.Email, now)
+	if err != nil {
+		switch err {
+		case mfa.ErrAlreadyEnrolled:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "Id: %s", usr.ID)
+		}
+	}
+	e.Challenge = challenge
+
+	return web.Respond(ctx, w, e, http.StatusCreated)
+}
+
+// ConfirmMFA enables the second factor of the authenticated user with a first
+// code from their authenticator app.
+func (u *User) ConfirmMFA(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.ConfirmMFA")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var cr mfa.CodeRequest
+	if err := web.Decode(r, &cr); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	if _, err := user.RetrieveFor(ctx, claims, u.db, claims.Subject, policy.UserMFA); err != nil {
+		return userError(err, claims.Subject)
+	}
+
+	if err := mfa.Confirm(ctx, u.db, claims.Subject, cr.Code, v.Now); err != nil {
+		return mfaError(err, claims.Subject)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// DisableMFA removes the second factor of the authenticated user. Admins of
+// accounts requiring a second factor cannot remove theirs.
+func (u *User) DisableMFA(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.User.DisableMFA")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var cr mfa.CodeRequest
+	if err := web.Decode(r, &cr); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	if claims.HasRole(auth.RoleAdmin, auth.RoleSuperAdmin) {
+		required, err := account.MFARequiredForAdmins(ctx, u.db, claims.AccountID)
+		if err != nil {
+			return err
+		}
+		if required {
+			return web.NewRequestError(user.ErrForbidden, http.StatusForbidden)
+		}
+	}
+
+	if _, err := user.RetrieveFor(ctx, claims, u.db, claims.Subject, policy.UserMFA); err != nil {
+		return userError(err, claims.Subject)
+	}
+
+	if err := mfa.Disable(ctx, u.db, claims.Subject, cr.Code, v.Now); err != nil {
+		return mfaError(err, claims.Subject)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// mfaError translates the errors returned when managing a second factor into
+// request errors.
+func mfaError(err error, id string) error {
+	switch err {
+	case mfa.ErrInvalidCode, mfa.ErrNotEnrolled:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case mfa.ErrAlreadyEnrolled:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrapf(err, "Id: %s", id)
+	}
+}
+
+// issue records a new session for the request, generates the access token for
+// the claims and starts the refresh token family of the session.
+func (u *User) issue(ctx context.Context, r *http.Request, claims auth.Claims, now time.Time) (tokenResponse, error) {
+	var tkn tokenResponse
+
+	ns := session.NewSession{
+		UserID:    claims.Subject,
+		Device:    r.Header.Get("X-Device-Name"),
+		IP:        remoteIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if ns.Device == "" {
+		ns.Device = session.Device(ns.UserAgent)
+	}
+	s, err := session.Create(ctx, u.db, ns, now)
+	if err != nil {
+		return tkn, errors.Wrap(err, "recording session")
+	}
+	claims.SessionID = s.ID
+
+	tkn.Token, err = u.authenticator.GenerateToken(claims)
+	if err != nil {
+		return tkn, errors.Wrap(err, "generating token")
+	}
+
+	tkn.RefreshToken, err = refresh.Issue(ctx, u.db, s.ID, claims.Subject, now, u.opts.RefreshTokenTTL)
+	if err != nil {
+		return tkn, errors.Wrap(err, "issuing refresh token")
+	}
+	tkn.ExpiresIn = int64(u.opts.AccessTokenTTL / time.Second)
+
+	return tkn, nil
 }
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/account"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/platform/throttle"
+	"github.com/sankarvj/seedgo/internal/platform/web"
+	"github.com/sankarvj/seedgo/internal/revocation"
+	"github.com/sankarvj/seedgo/internal/tests"
+	"github.com/sankarvj/seedgo/internal/user"
+)
+
+// TestScopedToken validates narrowed tokens never outlive the configured
+// maximum or the token they narrow, and that API keys cannot get one.
+func TestScopedToken(t *testing.T) {
+	key, err := auth.GenerateKey(auth.AlgRS256)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	kr, err := auth.NewKeyring("kid-1", auth.AlgRS256, key)
+	if err != nil {
+		t.Fatalf("constructing keyring: %s", err)
+	}
+	a, err := auth.NewKeyringAuthenticator(kr, kr.Lookup)
+	if err != nil {
+		t.Fatalf("constructing authenticator: %s", err)
+	}
+
+	// scoped requests a token narrowed to users:read for the claims at now
+	// and returns the response and the claims of the token issued.
+	scoped := func(opts Options, claims auth.Claims, now time.Time, expiresIn int64) (*httptest.ResponseRecorder, auth.Claims, error) {
+		u := User{authenticator: a, opts: opts}
+
+		body, _ := json.Marshal(map[string]interface{}{"scope": []string{auth.PermUsersRead}, "expires_in": expiresIn})
+		r := httptest.NewRequest("POST", "/v1/users/token/scoped", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+
+		ctx := tests.Context()
+		ctx.Value(web.KeyValues).(*web.Values).Now = now
+		ctx = context.WithValue(ctx, auth.Key, claims)
+		if err := u.ScopedToken(ctx, w, r, nil); err != nil {
+			return w, auth.Claims{}, err
+		}
+
+		var tkn tokenResponse
+		if err := json.NewDecoder(w.Body).Decode(&tkn); err != nil {
+			return w, auth.Claims{}, errors.Wrap(err, "decoding token")
+		}
+		c, err := a.ParseClaims(tkn.Token)
+		return w, c, err
+	}
+
+	// expiresIn returns how long after now the claims expire.
+	expiresIn := func(c auth.Claims, now time.Time) time.Duration {
+		return time.Unix(c.ExpiresAt, 0).Sub(now.Truncate(time.Second))
+	}
+
+	t.Log("Given the need to issue tokens narrowed to scopes.")
+	{
+		opts := Options{AccessTokenTTL: 10 * time.Minute, ScopedTokenMaxTTL: time.Hour}
+
+		t.Log("\tWhen a user asks for a token within the limits.")
+		{
+			now := time.Now()
+			parent := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleAdmin}, now, time.Hour)
+			w, c, err := scoped(opts, parent, now, 1800)
+			if err != nil || w.Code != http.StatusOK {
+				t.Fatalf("\t%s\tShould be able to get a scoped token : %v.", tests.Failed, err)
+			}
+			if c.Scope != auth.PermUsersRead || c.Subject != parent.Subject || expiresIn(c, now) != 30*time.Minute {
+				t.Fatalf("\t%s\tShould get a token narrowed to the scope for the time asked : %+v.", tests.Failed, c)
+			}
+			t.Logf("\t%s\tShould get a token narrowed to the scope for the time asked.", tests.Success)
+		}
+
+		t.Log("\tWhen a user asks for longer than their token has left.")
+		{
+			now := time.Now()
+			parent := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleAdmin}, now, 5*time.Minute)
+			_, c, err := scoped(opts, parent, now, 1800)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to get a scoped token : %v.", tests.Failed, err)
+			}
+			if c.ExpiresAt > parent.ExpiresAt {
+				t.Fatalf("\t%s\tShould get a token expiring with theirs : got %d, want %d.", tests.Failed, c.ExpiresAt, parent.ExpiresAt)
+			}
+			t.Logf("\t%s\tShould get a token expiring with theirs.", tests.Success)
+		}
+
+		t.Log("\tWhen no maximum lifetime is configured.")
+		{
+			now := time.Now()
+			parent := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleAdmin}, now, time.Hour)
+			_, c, err := scoped(Options{AccessTokenTTL: 10 * time.Minute}, parent, now, 1800)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to get a scoped token : %v.", tests.Failed, err)
+			}
+			if got := expiresIn(c, now); got != 10*time.Minute {
+				t.Fatalf("\t%s\tShould get a token living as long as an access token : got %s.", tests.Failed, got)
+			}
+			t.Logf("\t%s\tShould get a token living as long as an access token.", tests.Success)
+		}
+
+		t.Log("\tWhen an API key asks for a token.")
+		{
+			now := time.Now()
+			parent := auth.NewClaims("a9b8b1f0-66b4-4b0e-9c1f-3e1a0d3f5c11", nil, now, time.Hour)
+			parent.Permissions = []string{auth.PermUsersRead}
+			parent.APIKey = true
+			_, _, err := scoped(opts, parent, now, 1800)
+			if status(err) != http.StatusForbidden {
+				t.Fatalf("\t%s\tShould be refused with 403 : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be refused with 403.", tests.Success)
+		}
+	}
+}
+
+// newTokenHandlers constructs the user handlers of an integration test
+// exchanging credentials of its fake identity provider for tokens.
+func newTokenHandlers(test *tests.Test, opts Options) *User {
+	opts.AccessTokenTTL = time.Hour
+	opts.RefreshTokenTTL = time.Hour
+
+	return &User{
+		log:           test.Log,
+		db:            test.DB,
+		authenticator: test.Authenticator,
+		provider:      test.Provider,
+		revocations:   revocation.NewStore(test.DB, time.Hour, time.Hour),
+		throttle:      throttle.New(opts.Throttle),
+		ips:           throttle.New(opts.IPThrottle),
+		opts:          opts,
+	}
+}
+
+// exchange sends a credential to GET /v1/users/token/:id and returns the
+// claims of the access token issued.
+func exchange(u *User, credential string) (auth.Claims, error) {
+	r := httptest.NewRequest("GET", "/v1/users/token/"+credential, nil)
+	w := httptest.NewRecorder()
+
+	if err := u.Token(tests.Context(), w, r, map[string]string{"id": credential}); err != nil {
+		return auth.Claims{}, err
+	}
+
+	var tkn tokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&tkn); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "decoding token")
+	}
+	return u.authenticator.ParseClaims(tkn.Token)
+}
+
+// status returns the status code of a request error.
+func status(err error) int {
+	if webErr, ok := errors.Cause(err).(*web.Error); ok {
+		return webErr.Status
+	}
+	return 0
+}
+
+// TestToken validates credentials of the identity provider are exchanged for
+// tokens of the users linked to them.
+func TestToken(t *testing.T) {
+	test := tests.NewIntegration(t)
+	defer test.Teardown()
+
+	u := newTokenHandlers(test, Options{})
+
+	t.Log("Given the need to exchange identity provider credentials for tokens.")
+	{
+		t.Log("\tWhen exchanging the credential of a seeded user.")
+		{
+			claims, err := exchange(u, tests.UserCredential)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to get a token : %s.", tests.Failed, err)
+			}
+			if claims.Subject != tests.UserID || claims.SessionID == "" {
+				t.Fatalf("\t%s\tShould get a token of the linked user : %+v.", tests.Failed, claims)
+			}
+			t.Logf("\t%s\tShould get a token of the linked user.", tests.Success)
+		}
+
+		t.Log("\tWhen exchanging a credential after a failure.")
+		{
+			u := newTokenHandlers(test, Options{Throttle: throttle.Config{LockoutAfter: 2}})
+			key := test.Provider.ProviderName + ":" + tests.UserCredential
+
+			u.throttle.Fail(key, time.Now())
+			if _, err := exchange(u, tests.UserCredential); err != nil {
+				t.Fatalf("\t%s\tShould be able to get a token : %s.", tests.Failed, err)
+			}
+			if _, locked := u.throttle.Fail(key, time.Now()); locked {
+				t.Fatalf("\t%s\tShould forget the failures once a token is issued.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould forget the failures once a token is issued.", tests.Success)
+		}
+
+		t.Log("\tWhen exchanging a credential the provider rejects.")
+		{
+			if _, err := exchange(u, "bogus"); status(err) != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tShould receive a status code of 401 : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould receive a status code of 401.", tests.Success)
+		}
+
+		t.Log("\tWhen exchanging the credential of a subject linked to no user.")
+		{
+			test.Provider.Identities["stranger"] = auth.Identity{Subject: "stranger", Email: "stranger@example.com", EmailVerified: true}
+
+			if _, err := exchange(u, "stranger"); status(err) != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tShould receive a status code of 401 : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould receive a status code of 401.", tests.Success)
+		}
+	}
+}
+
+// TestProvision validates users logging in through the identity provider for
+// the first time are provisioned into the account chosen by the rule.
+func TestProvision(t *testing.T) {
+	test := tests.NewIntegration(t)
+	defer test.Teardown()
+
+	ctx := tests.Context()
+	now := time.Now()
+
+	// identity registers an identity with the fake provider and returns the
+	// credential it accepts for it.
+	identity := func(subject, email string, verified bool) string {
+		test.Provider.Identities[subject] = auth.Identity{Subject: subject, Email: email, EmailVerified: verified}
+		return subject
+	}
+
+	t.Log("Given the need to provision users on their first login.")
+	{
+		a, err := account.Create(ctx, test.DB, account.NewAccount{Name: "Acme", Domain: "acme.com"}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
+		}
+		nu := user.NewUser{
+			AccountID:       a.ID,
+			Name:            "Anna Walker",
+			Email:           "anna@acme.com",
+			Roles:           []string{auth.RoleUser},
+			Password:        "gophers",
+			PasswordConfirm: "gophers",
+		}
+		anna, err := user.Create(ctx, auth.Claims{Roles: []string{auth.RoleSuperAdmin}}, test.DB, nu, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+		}
+
+		t.Log("\tWhen provisioning is off.")
+		{
+			u := newTokenHandlers(test, Options{})
+			if _, err := exchange(u, identity("off", "off@acme.com", true)); status(err) != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tShould receive a status code of 401 : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould receive a status code of 401.", tests.Success)
+		}
+
+		t.Log("\tWhen provisioning into the account of the email domain.")
+		{
+			u := newTokenHandlers(test, Options{ProvisionRule: ProvisionByDomain})
+
+			claims, err := exchange(u, identity("new", "new@acme.com", true))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to get a token : %s.", tests.Failed, err)
+			}
+			if claims.AccountID != a.ID || !claims.HasRole(auth.RoleUser) || claims.HasRole(auth.RoleAdmin) {
+				t.Fatalf("\t%s\tShould provision a user of the account : %+v.", tests.Failed, claims)
+			}
+			t.Logf("\t%s\tShould provision a user of the account.", tests.Success)
+
+			if again, err := exchange(u, "new"); err != nil || again.Subject != claims.Subject {
+				t.Fatalf("\t%s\tShould log the same user in the next time : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould log the same user in the next time.", tests.Success)
+
+			if _, err := exchange(u, identity("unverified", "unverified@acme.com", false)); status(err) != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tShould refuse an email the provider did not verify : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse an email the provider did not verify.", tests.Success)
+
+			if _, err := exchange(u, identity("nowhere", "someone@nowhere.com", true)); status(err) != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tShould refuse an email of no account's domain : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse an email of no account's domain.", tests.Success)
+
+			linked, err := exchange(u, identity("anna", "anna@acme.com", true))
+			if err != nil || linked.Subject != anna.ID {
+				t.Fatalf("\t%s\tShould link the existing user with the email : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould link the existing user with the email.", tests.Success)
+
+			if _, err := exchange(u, identity("impostor", "anna@acme.com", true)); status(err) != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tShould NOT link a user already linked to another identity : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT link a user already linked to another identity.", tests.Success)
+		}
+
+		t.Log("\tWhen provisioning into a personal account.")
+		{
+			u := newTokenHandlers(test, Options{ProvisionRule: ProvisionPersonal})
+
+			claims, err := exchange(u, identity("solo", "solo@gmail.com", false))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to get a token : %s.", tests.Failed, err)
+			}
+			if claims.AccountID == "" || claims.AccountID == a.ID || !claims.HasRole(auth.RoleAdmin) {
+				t.Fatalf("\t%s\tShould provision the admin of a new account : %+v.", tests.Failed, claims)
+			}
+			t.Logf("\t%s\tShould provision the admin of a new account.", tests.Success)
+		}
+	}
+}
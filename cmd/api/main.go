@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto/rand"
 	"expvar"
 	"fmt"
 	"github.com/rs/cors"
@@ -15,11 +15,18 @@ import (
 	"time"
 
 	"github.com/ardanlabs/conf"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/sankarvj/seedgo/cmd/api/internal/handlers"
+	"github.com/sankarvj/seedgo/internal/account"
 	"github.com/sankarvj/seedgo/internal/platform/auth"
 	"github.com/sankarvj/seedgo/internal/platform/database"
+	"github.com/sankarvj/seedgo/internal/platform/mail"
+	"github.com/sankarvj/seedgo/internal/platform/throttle"
+	"github.com/sankarvj/seedgo/internal/reset"
+	"github.com/sankarvj/seedgo/internal/revocation"
+	"github.com/sankarvj/seedgo/internal/user"
+	"github.com/sankarvj/seedgo/internal/verify"
 )
 
 // build is the git version of this program. It is set using build flags in the makefile.
@@ -56,10 +63,54 @@ func run() error {
 			DisableTLS bool   `conf:"default:true"`
 		}
 		Auth struct {
-			KeyID          string `conf:"default:1"`
-			PrivateKeyFile string `conf:"default:private.pem"`
-			Algorithm      string `conf:"default:RS256"`
-			GoogleKeyFile  string `conf:"default:config/xxx.json"`
+			KeyID                  string `conf:"default:1"`
+			PrivateKeyFile         string `conf:"default:private.pem"`
+			KeysDir                string
+			KeysReload             time.Duration `conf:"default:1m"`
+			Algorithm              string
+			Provider               string `conf:"default:firebase"`
+			GoogleKeyFile          string `conf:"default:config/xxx.json"`
+			FirebaseProject        string
+			FirebaseCerts          string `conf:"default:https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com"`
+			Provision              string
+			OIDCName               string `conf:"default:oidc"`
+			OIDCIssuer             string
+			OIDCAudience           string
+			OIDCJWKSURL            string
+			JWKSURL                string
+			JWKSRefresh            time.Duration `conf:"default:1h"`
+			JWKSMinRefetch         time.Duration `conf:"default:1m"`
+			AccessTTL              time.Duration `conf:"default:15m"`
+			RefreshTTL             time.Duration `conf:"default:720h"`
+			RevocationSync         time.Duration `conf:"default:30s"`
+			VerifySecret           string        `conf:"noprint"`
+			VerifyTTL              time.Duration `conf:"default:24h"`
+			VerifyURL              string
+			RequireVerified        bool          `conf:"default:false"`
+			ResetTTL               time.Duration `conf:"default:1h"`
+			ResetURL               string
+			MFAIssuer              string        `conf:"default:Seed"`
+			MFAChallengeTTL        time.Duration `conf:"default:5m"`
+			ImpersonationTTL       time.Duration `conf:"default:15m"`
+			ScopedTokenMaxTTL      time.Duration `conf:"default:24h"`
+			ThrottleBackoffAfter   int           `conf:"default:3"`
+			ThrottleLockoutAfter   int           `conf:"default:10"`
+			ThrottleIPBackoffAfter int           `conf:"default:20"`
+			ThrottleIPLockoutAfter int           `conf:"default:100"`
+			ThrottleBaseDelay      time.Duration `conf:"default:1s"`
+			ThrottleLockout        time.Duration `conf:"default:15m"`
+		}
+		Mail struct {
+			Driver       string `conf:"default:log"`
+			File         string
+			SMTPAddr     string `conf:"default:localhost:25"`
+			SMTPUser     string
+			SMTPPassword string `conf:"noprint"`
+			From         string `conf:"default:no-reply@localhost"`
+		}
+		Purge struct {
+			Retention time.Duration `conf:"default:720h"`
+			Interval  time.Duration `conf:"default:1h"`
 		}
 		Zipkin struct {
 			LocalEndpoint string  `conf:"default:0.0.0.0:3000"`
@@ -100,22 +151,172 @@ func run() error {
 
 	log.Println("main : Started : Initializing authentication support")
 
-	keyContents, err := ioutil.ReadFile(cfg.Auth.PrivateKeyFile)
-	if err != nil {
-		return errors.Wrap(err, "reading auth private key")
+	// Background jobs run until the service stops.
+	done := make(chan struct{})
+	defer close(done)
+
+	// A keyring directory supports rotating keys without invalidating live
+	// tokens. Without one the single private key file is used.
+	var keyring *auth.Keyring
+	if cfg.Auth.KeysDir != "" {
+		keyring, err = auth.LoadKeyring(cfg.Auth.KeysDir)
+		if err != nil {
+			return errors.Wrap(err, "loading auth keyring")
+		}
+
+		// Pick up keys rotated in or retired by the admin tool.
+		if cfg.Auth.KeysReload <= 0 {
+			return errors.New("keys reload interval must be positive")
+		}
+		go every(cfg.Auth.KeysReload, done, func() {
+			if err := keyring.Reload(); err != nil {
+				log.Printf("main : Reloading keyring : %v", err)
+			}
+		})
+	} else {
+		keyContents, err := ioutil.ReadFile(cfg.Auth.PrivateKeyFile)
+		if err != nil {
+			return errors.Wrap(err, "reading auth private key")
+		}
+
+		privateKey, err := auth.ParsePrivateKeyPEM(keyContents)
+		if err != nil {
+			return errors.Wrap(err, "parsing auth private key")
+		}
+
+		// A blank algorithm picks the default for the type of the key.
+		keyring, err = auth.NewKeyring(cfg.Auth.KeyID, cfg.Auth.Algorithm, privateKey)
+		if err != nil {
+			return errors.Wrap(err, "constructing auth keyring")
+		}
 	}
 
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(keyContents)
-	if err != nil {
-		return errors.Wrap(err, "parsing auth private key")
+	// Tokens are verified against the keyring unless a JWKS endpoint is
+	// configured, in which case the keys it publishes are trusted instead.
+	f := keyring.Lookup
+	if cfg.Auth.JWKSURL != "" {
+		jwks, err := auth.NewJWKS(auth.JWKSConfig{
+			URL:                cfg.Auth.JWKSURL,
+			RefreshInterval:    cfg.Auth.JWKSRefresh,
+			MinRefetchInterval: cfg.Auth.JWKSMinRefetch,
+			Log:                log,
+		})
+		if err != nil {
+			return errors.Wrap(err, "constructing jwks")
+		}
+		defer jwks.Shutdown()
+		f = jwks.Lookup
 	}
 
-	f := auth.NewSimpleKeyLookupFunc(cfg.Auth.KeyID, privateKey.Public().(*rsa.PublicKey))
-	authenticator, err := auth.NewAuthenticator(privateKey, cfg.Auth.GoogleKeyFile, cfg.Auth.KeyID, cfg.Auth.Algorithm, f)
+	authenticator, err := auth.NewKeyringAuthenticator(keyring, f)
 	if err != nil {
 		return errors.Wrap(err, "constructing authenticator")
 	}
 
+	// Select the identity provider verifying the credentials exchanged for
+	// our tokens.
+	var provider auth.IdentityProvider
+	switch cfg.Auth.Provider {
+	case "firebase":
+
+		// Fall back on the project of the service account credentials.
+		projectID := cfg.Auth.FirebaseProject
+		if projectID == "" {
+			projectID, err = auth.FirebaseProjectID(cfg.Auth.GoogleKeyFile)
+			if err != nil {
+				return errors.Wrap(err, "reading firebase project id")
+			}
+		}
+
+		firebase, err := auth.NewFirebase(auth.FirebaseConfig{
+			ProjectID:    projectID,
+			CertsURL:     cfg.Auth.FirebaseCerts,
+			CertsRefresh: cfg.Auth.JWKSRefresh,
+			Log:          log,
+		})
+		if err != nil {
+			return errors.Wrap(err, "constructing firebase provider")
+		}
+		defer firebase.Shutdown()
+		provider = firebase
+	case "oidc":
+		oidc, err := auth.NewOIDC(context.Background(), auth.OIDCConfig{
+			Name:        cfg.Auth.OIDCName,
+			Issuer:      cfg.Auth.OIDCIssuer,
+			Audience:    cfg.Auth.OIDCAudience,
+			JWKSURL:     cfg.Auth.OIDCJWKSURL,
+			JWKSRefresh: cfg.Auth.JWKSRefresh,
+			Log:         log,
+		})
+		if err != nil {
+			return errors.Wrap(err, "constructing oidc provider")
+		}
+		defer oidc.Shutdown()
+		provider = oidc
+	default:
+		return errors.Errorf("unknown identity provider %q", cfg.Auth.Provider)
+	}
+
+	switch cfg.Auth.Provision {
+	case "", handlers.ProvisionByDomain, handlers.ProvisionPersonal:
+	default:
+		return errors.Errorf("unknown provision rule %q", cfg.Auth.Provision)
+	}
+
+	// =========================================================================
+	// Initialize mail support
+
+	log.Println("main : Started : Initializing mail support")
+
+	var mailer mail.Mailer
+	switch cfg.Mail.Driver {
+	case "smtp":
+		mailer, err = mail.NewSMTP(mail.SMTPConfig{
+			Addr:     cfg.Mail.SMTPAddr,
+			From:     cfg.Mail.From,
+			User:     cfg.Mail.SMTPUser,
+			Password: cfg.Mail.SMTPPassword,
+		})
+		if err != nil {
+			return errors.Wrap(err, "constructing smtp mailer")
+		}
+	case "log":
+
+		// Without a file the messages go to the service log.
+		w := log.Writer()
+		if cfg.Mail.File != "" {
+			file, err := os.OpenFile(cfg.Mail.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				return errors.Wrap(err, "opening mail file")
+			}
+			defer file.Close()
+			w = file
+		}
+		mailer = mail.NewLog(w)
+	default:
+		return errors.Errorf("unknown mail driver %q", cfg.Mail.Driver)
+	}
+
+	// Verification tokens can only be checked by the instance that signed
+	// them unless a secret is shared through the configuration.
+	verifySecret := []byte(cfg.Auth.VerifySecret)
+	if len(verifySecret) == 0 {
+		log.Println("main : No verification secret configured, using a random one")
+		verifySecret = make([]byte, 32)
+		if _, err := rand.Read(verifySecret); err != nil {
+			return errors.Wrap(err, "generating verification secret")
+		}
+	}
+
+	verifier, err := verify.New(verify.Config{
+		Secret: verifySecret,
+		TTL:    cfg.Auth.VerifyTTL,
+		URL:    cfg.Auth.VerifyURL,
+	}, mailer)
+	if err != nil {
+		return errors.Wrap(err, "constructing verifier")
+	}
+
 	// =========================================================================
 	// Start Database
 
@@ -136,6 +337,15 @@ func run() error {
 		db.Close()
 	}()
 
+	// Deleted accounts and users are kept for the retention window so they
+	// can be restored, then removed for good.
+	if cfg.Purge.Interval <= 0 {
+		return errors.New("purge interval must be positive")
+	}
+	go every(cfg.Purge.Interval, done, func() {
+		purge(log, db, time.Now(), cfg.Purge.Retention)
+	})
+
 	go func() {
 		log.Printf("main : Debug Listening %s", cfg.Web.DebugHost)
 		log.Printf("main : Debug Listener closed : %v", http.ListenAndServe(cfg.Web.DebugHost, http.DefaultServeMux))
@@ -146,11 +356,43 @@ func run() error {
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"POST", "GET", "PUT", "OPTIONS", "DELETE"},
-		AllowedHeaders:   []string{"Content-Type", "X-Requested-With", "Authorization"},
+		AllowedMethods:   []string{"POST", "GET", "PUT", "PATCH", "OPTIONS", "DELETE"},
+		AllowedHeaders:   []string{"Content-Type", "X-Requested-With", "Authorization", "X-Device-Name", "If-Match"},
+		ExposedHeaders:   []string{"ETag"},
 		AllowCredentials: true,
 	})
-	handler := c.Handler(handlers.API(shutdown, log, db, authenticator))
+	opts := handlers.Options{
+		AccessTokenTTL:    cfg.Auth.AccessTTL,
+		RefreshTokenTTL:   cfg.Auth.RefreshTTL,
+		RevocationSync:    cfg.Auth.RevocationSync,
+		ProvisionRule:     cfg.Auth.Provision,
+		RequireVerified:   cfg.Auth.RequireVerified,
+		MFAIssuer:         cfg.Auth.MFAIssuer,
+		MFAChallengeTTL:   cfg.Auth.MFAChallengeTTL,
+		ImpersonationTTL:  cfg.Auth.ImpersonationTTL,
+		ScopedTokenMaxTTL: cfg.Auth.ScopedTokenMaxTTL,
+		Throttle: throttle.Config{
+			BackoffAfter: cfg.Auth.ThrottleBackoffAfter,
+			LockoutAfter: cfg.Auth.ThrottleLockoutAfter,
+			BaseDelay:    cfg.Auth.ThrottleBaseDelay,
+			Lockout:      cfg.Auth.ThrottleLockout,
+		},
+		IPThrottle: throttle.Config{
+			BackoffAfter: cfg.Auth.ThrottleIPBackoffAfter,
+			LockoutAfter: cfg.Auth.ThrottleIPLockoutAfter,
+			BaseDelay:    cfg.Auth.ThrottleBaseDelay,
+			Lockout:      cfg.Auth.ThrottleLockout,
+		},
+	}
+	resetter, err := reset.New(db, reset.Config{
+		TTL: cfg.Auth.ResetTTL,
+		URL: cfg.Auth.ResetURL,
+	}, mailer)
+	if err != nil {
+		return errors.Wrap(err, "constructing resetter")
+	}
+
+	handler := c.Handler(handlers.API(shutdown, log, db, authenticator, provider, verifier, resetter, opts))
 
 	api := http.Server{
 		Addr:         cfg.Web.APIHost,
@@ -202,3 +444,56 @@ func run() error {
 
 	return nil
 }
+
+// every calls f at each interval until done is closed.
+func every(interval time.Duration, done <-chan struct{}, f func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f()
+		case <-done:
+			return
+		}
+	}
+}
+
+// purgeLockKey identifies the advisory lock letting a single instance of the
+// service purge at a time.
+const purgeLockKey = 7301
+
+// purge removes the accounts and users deleted longer than the retention
+// ago and the revocations that expired. Users of a purged account go with it.
+// Instances purging at the same time as another skip their turn.
+func purge(log *log.Logger, db *sqlx.DB, now time.Time, retention time.Duration) {
+	ctx := context.Background()
+	before := now.Add(-retention)
+
+	var accounts, users int64
+	locked, err := database.WithAdvisoryLock(ctx, db, purgeLockKey, func() error {
+		var err error
+		if accounts, err = account.Purge(ctx, db, before); err != nil {
+			return errors.Wrap(err, "purging accounts")
+		}
+		if users, err = user.Purge(ctx, db, before); err != nil {
+			return errors.Wrap(err, "purging users")
+		}
+		if _, err := revocation.Purge(ctx, db, now); err != nil {
+			return errors.Wrap(err, "purging revocations")
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("main : Purging : %v", err)
+		return
+	}
+	if !locked {
+		return
+	}
+
+	if accounts > 0 || users > 0 {
+		log.Printf("main : Purged %d accounts and %d users deleted before %s", accounts, users, before.UTC().Format(time.RFC3339))
+	}
+}
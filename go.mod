@@ -3,7 +3,6 @@ module github.com/sankarvj/seedgo
 go 1.13
 
 require (
-	firebase.google.com/go v3.10.0+incompatible
 	github.com/ardanlabs/conf v1.2.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/dimfeld/httptreemux/v5 v5.0.2
@@ -19,6 +18,7 @@ require (
 	github.com/rs/cors v1.7.0
 	go.opencensus.io v0.22.2
 	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
-	google.golang.org/api v0.14.0
+	google.golang.org/appengine v1.5.0 // indirect
+	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/go-playground/validator.v9 v9.30.2
 )
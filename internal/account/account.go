@@ -2,29 +2,217 @@ package account
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/policy"
 	"go.opencensus.io/trace"
 )
 
-// List retrieves a list of existing users from the database.
+// ErrNotFound is used when a specific Account is requested but does not exist.
+var ErrNotFound = errors.New("Account not found")
+
+// List retrieves the account of the caller from the database. Superadmins
+// get every account.
 func List(ctx context.Context, user auth.Claims, db *sqlx.DB) ([]Account, error) {
 	ctx, span := trace.StartSpan(ctx, "internal.account.List")
 	defer span.End()
 
+	if err := policy.Authorize(user, policy.AccountList, policy.Resource{}); err != nil {
+		return nil, err
+	}
+
 	accounts := []Account{}
-	const q = `SELECT a.* FROM accounts as a join users as u on a.account_id = u.account_id where u.user_id = $1`
+	const q = `SELECT * FROM accounts WHERE (account_id::text = $1 OR $2) AND deleted_at IS NULL`
 
-	if err := db.SelectContext(ctx, &accounts, q, user.Subject); err != nil {
+	if err := db.SelectContext(ctx, &accounts, q, user.AccountID, user.HasRole(auth.RoleSuperAdmin)); err != nil {
 		return nil, errors.Wrap(err, "selecting accounts")
 	}
 	return accounts, nil
 }
 
+// RetrieveByDomain gets the account owning the specified domain. Domains are
+// compared case insensitively.
+func RetrieveByDomain(ctx context.Context, db *sqlx.DB, domain string) (*Account, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.account.RetrieveByDomain")
+	defer span.End()
+
+	var a Account
+	const q = `SELECT * FROM accounts WHERE lower(domain) = lower($1) AND deleted_at IS NULL`
+	if err := db.GetContext(ctx, &a, q, domain); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+
+		return nil, errors.Wrapf(err, "selecting account by domain %q", domain)
+	}
+
+	return &a, nil
+}
+
+// Update modifies the account of the caller. Superadmins may modify every
+// account.
+func Update(ctx context.Context, user auth.Claims, db *sqlx.DB, id string, upd UpdateAccount, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.account.Update")
+	defer span.End()
+
+	switch err := policy.Authorize(user, policy.AccountUpdate, policy.Resource{AccountID: id}); err {
+	case nil:
+	case policy.ErrOutsideTenant:
+		return ErrNotFound
+	default:
+		return err
+	}
+
+	const q = `UPDATE accounts SET
+		"name" = COALESCE($2, "name"),
+		"mfa_required_for_admins" = COALESCE($3, "mfa_required_for_admins"),
+		"updated_at" = $4
+		WHERE account_id::text = $1 AND deleted_at IS NULL`
+	res, err := db.ExecContext(ctx, q, id, upd.Name, upd.MFARequiredForAdmins, now.Unix())
+	if err != nil {
+		return errors.Wrapf(err, "updating account %s", id)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete marks the account and its users as deleted. It returns the ids of
+// the users it deleted so their sessions can be ended. Nothing is removed
+// until the account is purged.
+func Delete(ctx context.Context, user auth.Claims, db *sqlx.DB, id string, now time.Time) ([]string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.account.Delete")
+	defer span.End()
+
+	switch err := policy.Authorize(user, policy.AccountDelete, policy.Resource{AccountID: id}); err {
+	case nil:
+	case policy.ErrOutsideTenant:
+		return nil, ErrNotFound
+	default:
+		return nil, err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `UPDATE accounts SET deleted_at = $2, updated_at = $3
+		WHERE account_id::text = $1 AND deleted_at IS NULL`
+	res, err := tx.ExecContext(ctx, q, id, now.UTC(), now.Unix())
+	if err != nil {
+		return nil, errors.Wrapf(err, "deleting account %s", id)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrNotFound
+	}
+
+	// The users share the deletion time of the account so restoring it brings
+	// back only them and not the users deleted before.
+	userIDs := []string{}
+	const u = `UPDATE users SET deleted_at = $2, updated_at = $3, version = version + 1
+		WHERE account_id::text = $1 AND deleted_at IS NULL
+		RETURNING user_id`
+	if err := tx.SelectContext(ctx, &userIDs, u, id, now.UTC(), now.Unix()); err != nil {
+		return nil, errors.Wrapf(err, "deleting users of account %s", id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing transaction")
+	}
+
+	return userIDs, nil
+}
+
+// Restore brings back a deleted account along with the users deleted with it.
+func Restore(ctx context.Context, user auth.Claims, db *sqlx.DB, id string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.account.Restore")
+	defer span.End()
+
+	switch err := policy.Authorize(user, policy.AccountRestore, policy.Resource{AccountID: id}); err {
+	case nil:
+	case policy.ErrOutsideTenant:
+		return ErrNotFound
+	default:
+		return err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	var deletedAt time.Time
+	const s = `SELECT deleted_at FROM accounts
+		WHERE account_id::text = $1 AND deleted_at IS NOT NULL
+		FOR UPDATE`
+	if err := tx.GetContext(ctx, &deletedAt, s, id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrapf(err, "selecting account %q", id)
+	}
+
+	const q = `UPDATE accounts SET deleted_at = NULL, updated_at = $2 WHERE account_id::text = $1`
+	if _, err := tx.ExecContext(ctx, q, id, now.Unix()); err != nil {
+		return errors.Wrapf(err, "restoring account %s", id)
+	}
+
+	const u = `UPDATE users SET deleted_at = NULL, updated_at = $3, version = version + 1
+		WHERE account_id::text = $1 AND deleted_at = $2`
+	if _, err := tx.ExecContext(ctx, u, id, deletedAt, now.Unix()); err != nil {
+		return errors.Wrapf(err, "restoring users of account %s", id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// Purge permanently removes the accounts deleted before the time along with
+// everything they own. It returns how many accounts were removed.
+func Purge(ctx context.Context, db *sqlx.DB, before time.Time) (int64, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.account.Purge")
+	defer span.End()
+
+	const q = `DELETE FROM accounts WHERE deleted_at < $1`
+	res, err := db.ExecContext(ctx, q, before.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "purging accounts")
+	}
+
+	return res.RowsAffected()
+}
+
+// MFARequiredForAdmins reports whether the admins of the account must log in
+// with a second factor.
+func MFARequiredForAdmins(ctx context.Context, db *sqlx.DB, id string) (bool, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.account.MFARequiredForAdmins")
+	defer span.End()
+
+	var required bool
+	const q = `SELECT mfa_required_for_admins FROM accounts WHERE account_id::text = $1 AND deleted_at IS NULL`
+	if err := db.GetContext(ctx, &required, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "selecting account %q", id)
+	}
+
+	return required, nil
+}
+
 // Create inserts a new user into the database.
 func Create(ctx context.Context, db *sqlx.DB, n NewAccount, now time.Time) (*Account, error) {
 	ctx, span := trace.StartSpan(ctx, "internal.account.Create")
@@ -19,6 +19,14 @@ type Account struct {
 	Expiry    time.Time `db:"expiry" json:"expiry"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt int64     `db:"updated_at" json:"updated_at"`
+
+	// MFARequiredForAdmins makes admins of the account log in with a second
+	// factor.
+	MFARequiredForAdmins bool `db:"mfa_required_for_admins" json:"mfa_required_for_admins"`
+
+	// DeletedAt is when the account was deleted. It is purged once the
+	// retention window has passed.
+	DeletedAt *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
 }
 
 // NewAccount contains information needed to create a new Account.
@@ -26,3 +34,11 @@ type NewAccount struct {
 	Name   string `json:"name" validate:"required"`
 	Domain string `json:"domain" validate:"required"`
 }
+
+// UpdateAccount defines what information may be provided to modify an
+// existing Account. All fields are optional so clients can send just the
+// fields they want changed.
+type UpdateAccount struct {
+	Name                 *string `json:"name"`
+	MFARequiredForAdmins *bool   `json:"mfa_required_for_admins"`
+}
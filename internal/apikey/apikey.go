@@ -0,0 +1,209 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/policy"
+	"go.opencensus.io/trace"
+)
+
+// Prefix starts every API key so leaked keys are easy to recognize.
+const Prefix = "sk"
+
+var (
+	// ErrNotFound is used when a specific Key is requested but does not exist.
+	ErrNotFound = errors.New("API key not found")
+
+	// ErrInvalidID occurs when an ID is not in a valid form.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrInvalidKey occurs when an API key is unknown, expired or revoked.
+	ErrInvalidKey = errors.New("API key is not valid")
+
+	// ErrInvalidScope occurs when a key is given a scope that is unknown or
+	// not held by its creator.
+	ErrInvalidScope = errors.New("Scope is not allowed")
+)
+
+// List retrieves the API keys of the caller's account.
+func List(ctx context.Context, claims auth.Claims, db *sqlx.DB) ([]Key, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.apikey.List")
+	defer span.End()
+
+	if err := policy.Authorize(claims, policy.APIKeyList, policy.Resource{}); err != nil {
+		return nil, err
+	}
+
+	keys := []Key{}
+	const q = `SELECT * FROM api_keys WHERE account_id::text = $1 ORDER BY created_at`
+
+	if err := db.SelectContext(ctx, &keys, q, claims.AccountID); err != nil {
+		return nil, errors.Wrap(err, "selecting api keys")
+	}
+
+	return keys, nil
+}
+
+// Create generates a new API key in the caller's account. A key cannot be
+// given a scope its creator does not hold.
+func Create(ctx context.Context, claims auth.Claims, db *sqlx.DB, n NewKey, now time.Time) (*CreatedKey, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.apikey.Create")
+	defer span.End()
+
+	if err := policy.Authorize(claims, policy.APIKeyCreate, policy.Resource{AccountID: claims.AccountID}); err != nil {
+		return nil, err
+	}
+
+	for _, s := range n.Scopes {
+		if !known(s) || !claims.HasPermission(s) {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	prefix, err := random(4)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := random(32)
+	if err != nil {
+		return nil, err
+	}
+	prefix = Prefix + "_" + prefix
+	secret = prefix + "_" + secret
+
+	var expiresAt *time.Time
+	if n.ExpiresAt != nil {
+		t := n.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+
+	k := Key{
+		ID:        uuid.New().String(),
+		AccountID: claims.AccountID,
+		Name:      n.Name,
+		Prefix:    prefix,
+		KeyHash:   hash(secret),
+		Scopes:    n.Scopes,
+		CreatedBy: claims.Subject,
+		ExpiresAt: expiresAt,
+		CreatedAt: now.UTC(),
+	}
+
+	const q = `INSERT INTO api_keys
+		(key_id, account_id, name, prefix, key_hash, scopes, created_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err = db.ExecContext(
+		ctx, q,
+		k.ID, k.AccountID, k.Name, k.Prefix, k.KeyHash,
+		k.Scopes, k.CreatedBy, k.ExpiresAt, k.CreatedAt,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting api key")
+	}
+
+	return &CreatedKey{Key: k, Secret: secret}, nil
+}
+
+// Revoke disables an API key of the caller's account. Revoking a revoked key
+// has no effect.
+func Revoke(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.apikey.Revoke")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := policy.Authorize(claims, policy.APIKeyRevoke, policy.Resource{AccountID: claims.AccountID}); err != nil {
+		return err
+	}
+
+	const q = `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $3)
+		WHERE key_id = $1 AND account_id::text = $2`
+	res, err := db.ExecContext(ctx, q, id, claims.AccountID, now.UTC())
+	if err != nil {
+		return errors.Wrapf(err, "revoking api key %s", id)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Authenticate finds the API key and returns Claims equivalent to those of a
+// user token. The subject is the key id and the permissions are the scopes of
+// the key. The claims expire after the specified duration. The time the key
+// was last used is recorded at most once a minute.
+func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, secret string, expires time.Duration) (auth.Claims, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.apikey.Authenticate")
+	defer span.End()
+
+	if !strings.HasPrefix(secret, Prefix+"_") {
+		return auth.Claims{}, ErrInvalidKey
+	}
+
+	var k Key
+	const q = `SELECT * FROM api_keys WHERE key_hash = $1 AND account_id IN (
+		SELECT account_id FROM accounts WHERE deleted_at IS NULL
+	)`
+	if err := db.GetContext(ctx, &k, q, hash(secret)); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, ErrInvalidKey
+		}
+		return auth.Claims{}, errors.Wrap(err, "selecting api key")
+	}
+
+	if k.RevokedAt != nil || (k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)) {
+		return auth.Claims{}, ErrInvalidKey
+	}
+
+	const u = `UPDATE api_keys SET last_used_at = $2
+		WHERE key_id = $1 AND (last_used_at IS NULL OR last_used_at < $3)`
+	if _, err := db.ExecContext(ctx, u, k.ID, now.UTC(), now.UTC().Add(-time.Minute)); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "recording api key use")
+	}
+
+	claims := auth.NewClaims(k.ID, nil, now, expires)
+	claims.AccountID = k.AccountID
+	claims.Permissions = k.Scopes
+	claims.APIKey = true
+
+	return claims, nil
+}
+
+// known reports whether the scope is one of auth.Permissions.
+func known(scope string) bool {
+	for _, p := range auth.Permissions {
+		if p == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// random returns n random bytes encoded as hex.
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating api key")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hash returns the hex encoded SHA-256 of the key. Keys carry 256 bits of
+// randomness so a fast hash is enough.
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,89 @@
+package apikey_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sankarvj/seedgo/internal/account"
+	"github.com/sankarvj/seedgo/internal/apikey"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// TestAPIKey validates API keys can be created, exchanged for claims and
+// revoked, and that they stop working once expired or revoked.
+func TestAPIKey(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to work with API keys.")
+	{
+		t.Log("\tWhen handling a single key.")
+		{
+			ctx := tests.Context()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			a, err := account.Create(ctx, db, account.NewAccount{Name: "Wayplot", Domain: "Wayplot"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to create account.", tests.Success)
+
+			claims := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleAdmin}, now, time.Hour)
+			claims.AccountID = a.ID
+
+			if _, err := apikey.Create(ctx, claims, db, apikey.NewKey{Name: "ci", Scopes: []string{"bogus"}}, now); err != apikey.ErrInvalidScope {
+				t.Fatalf("\t%s\tShould NOT be able to create a key with an unknown scope : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to create a key with an unknown scope.", tests.Success)
+
+			expiry := now.Add(time.Hour)
+			nk := apikey.NewKey{Name: "ci", Scopes: []string{auth.PermUsersRead}, ExpiresAt: &expiry}
+			k, err := apikey.Create(ctx, claims, db, nk, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create a key : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to create a key.", tests.Success)
+
+			keys, err := apikey.List(ctx, claims, db)
+			if err != nil || len(keys) != 1 || keys[0].ID != k.ID || keys[0].KeyHash == k.Secret {
+				t.Fatalf("\t%s\tShould list the key without its secret : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould list the key without its secret.", tests.Success)
+
+			kc, err := apikey.Authenticate(ctx, db, now.Add(time.Minute), k.Secret, time.Minute)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to authenticate with the key : %s.", tests.Failed, err)
+			}
+			if kc.Subject != k.ID || kc.AccountID != a.ID || !kc.HasPermission(auth.PermUsersRead) || kc.HasPermission(auth.PermUsersWrite) {
+				t.Fatalf("\t%s\tShould get claims limited to the scopes of the key : %+v.", tests.Failed, kc)
+			}
+			t.Logf("\t%s\tShould get claims limited to the scopes of the key.", tests.Success)
+
+			if _, err := apikey.Authenticate(ctx, db, now, k.Secret+"x", time.Minute); err != apikey.ErrInvalidKey {
+				t.Fatalf("\t%s\tShould NOT be able to authenticate with an unknown key : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to authenticate with an unknown key.", tests.Success)
+
+			if _, err := apikey.Authenticate(ctx, db, expiry, k.Secret, time.Minute); err != apikey.ErrInvalidKey {
+				t.Fatalf("\t%s\tShould NOT be able to authenticate with an expired key : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to authenticate with an expired key.", tests.Success)
+
+			other := claims
+			other.AccountID = "a9b8b1f0-66b4-4b0e-9c1f-3e1a0d3f5c11" // Some other account.
+			if err := apikey.Revoke(ctx, other, db, k.ID, now); err != apikey.ErrNotFound {
+				t.Fatalf("\t%s\tShould NOT be able to revoke a key of another account : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to revoke a key of another account.", tests.Success)
+
+			if err := apikey.Revoke(ctx, claims, db, k.ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the key : %s.", tests.Failed, err)
+			}
+			if _, err := apikey.Authenticate(ctx, db, now.Add(time.Minute), k.Secret, time.Minute); err != apikey.ErrInvalidKey {
+				t.Fatalf("\t%s\tShould NOT be able to authenticate with a revoked key : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to authenticate with a revoked key.", tests.Success)
+		}
+	}
+}
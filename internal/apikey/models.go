@@ -0,0 +1,39 @@
+package apikey
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Key represents an API key owned by an account. Only the hash of the secret
+// handed to the client is stored. The prefix is kept in the clear so a key
+// can be recognized in listings and logs.
+type Key struct {
+	ID         string         `db:"key_id" json:"id"`
+	AccountID  string         `db:"account_id" json:"account_id"`
+	Name       string         `db:"name" json:"name"`
+	Prefix     string         `db:"prefix" json:"prefix"`
+	KeyHash    string         `db:"key_hash" json:"-"`
+	Scopes     pq.StringArray `db:"scopes" json:"scopes"`
+	CreatedBy  string         `db:"created_by" json:"created_by"`
+	ExpiresAt  *time.Time     `db:"expires_at" json:"expires_at"`
+	LastUsedAt *time.Time     `db:"last_used_at" json:"last_used_at"`
+	RevokedAt  *time.Time     `db:"revoked_at" json:"revoked_at"`
+	CreatedAt  time.Time      `db:"created_at" json:"created_at"`
+}
+
+// NewKey contains information needed to create a new Key. Scopes are the
+// permissions the key grants. ExpiresAt is optional.
+type NewKey struct {
+	Name      string     `json:"name" validate:"required"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedKey is returned when a Key is created. It is the only time the
+// secret is available.
+type CreatedKey struct {
+	Key
+	Secret string `json:"key"`
+}
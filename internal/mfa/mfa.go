@@ -0,0 +1,408 @@
+// Package mfa manages the TOTP second factor of users and the challenges of
+// the two step login.
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/totp"
+	"go.opencensus.io/trace"
+)
+
+// These tune how codes and challenges are accepted.
+const (
+
+	// skew is the number of 30 second steps a code may be off by.
+	skew = 1
+
+	// recoveryCodes is the number of recovery codes handed out on enrollment.
+	recoveryCodes = 10
+
+	// maxAttempts is the number of wrong codes a challenge survives.
+	maxAttempts = 5
+)
+
+var (
+	// ErrNotEnrolled occurs when a user without a confirmed factor tries to
+	// use it.
+	ErrNotEnrolled = errors.New("Two-factor authentication is not enabled")
+
+	// ErrAlreadyEnrolled occurs when a user with a confirmed factor starts a
+	// new enrollment.
+	ErrAlreadyEnrolled = errors.New("Two-factor authentication is already enabled")
+
+	// ErrInvalidCode occurs when a TOTP or recovery code is wrong or was
+	// already used.
+	ErrInvalidCode = errors.New("Code is not valid")
+
+	// ErrInvalidChallenge occurs when a login challenge is unknown, expired,
+	// used or failed too many times.
+	ErrInvalidChallenge = errors.New("Challenge is not valid")
+)
+
+// Enroll starts the enrollment of a new factor for the user, replacing any
+// enrollment that was not confirmed. The factor protects logins once it is
+// confirmed with Confirm.
+func Enroll(ctx context.Context, db *sqlx.DB, userID, issuer, account string, now time.Time) (*Enrollment, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.mfa.Enroll")
+	defer span.End()
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `INSERT INTO user_mfa (user_id, secret, last_step, created_at)
+		VALUES ($1, $2, 0, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = EXCLUDED.secret,
+			last_step = 0,
+			created_at = EXCLUDED.created_at
+		WHERE user_mfa.confirmed_at IS NULL`
+	res, err := tx.ExecContext(ctx, q, userID, secret, now.UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting factor")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrAlreadyEnrolled
+	}
+
+	const d = `DELETE FROM mfa_recovery_codes WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, d, userID); err != nil {
+		return nil, errors.Wrap(err, "deleting recovery codes")
+	}
+
+	e := Enrollment{
+		Secret: secret,
+		URI:    totp.URI(issuer, account, secret),
+	}
+	for i := 0; i < recoveryCodes; i++ {
+		code, err := random(8)
+		if err != nil {
+			return nil, err
+		}
+		const c = `INSERT INTO mfa_recovery_codes (code_hash, user_id) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(ctx, c, hash(code), userID); err != nil {
+			return nil, errors.Wrap(err, "inserting recovery code")
+		}
+		e.RecoveryCodes = append(e.RecoveryCodes, code)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing transaction")
+	}
+
+	return &e, nil
+}
+
+// Confirm completes the enrollment of the user's factor with a first code
+// from their authenticator app.
+func Confirm(ctx context.Context, db *sqlx.DB, userID, code string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.mfa.Confirm")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	if err := confirm(ctx, tx, userID, code, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// Enabled reports whether the user has a confirmed factor.
+func Enabled(ctx context.Context, db *sqlx.DB, userID string) (bool, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.mfa.Enabled")
+	defer span.End()
+
+	var n int
+	const q = `SELECT count(*) FROM user_mfa WHERE user_id = $1 AND confirmed_at IS NOT NULL`
+	if err := db.GetContext(ctx, &n, q, userID); err != nil {
+		return false, errors.Wrap(err, "selecting factor")
+	}
+
+	return n > 0, nil
+}
+
+// Disable removes the factor and the recovery codes of the user. A code is
+// required so a stolen access token cannot turn the factor off.
+func Disable(ctx context.Context, db *sqlx.DB, userID, code string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.mfa.Disable")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	if err := verify(ctx, tx, userID, code, now); err != nil {
+		return err
+	}
+
+	const q = `DELETE FROM user_mfa WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, q, userID); err != nil {
+		return errors.Wrap(err, "deleting factor")
+	}
+	const c = `DELETE FROM mfa_recovery_codes WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, c, userID); err != nil {
+		return errors.Wrap(err, "deleting recovery codes")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// NewChallenge starts the second step of a login for the user and returns the
+// opaque challenge token to hand to the client.
+func NewChallenge(ctx context.Context, db *sqlx.DB, userID string, now time.Time, expires time.Duration) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.mfa.NewChallenge")
+	defer span.End()
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating challenge")
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	c := Challenge{
+		ChallengeHash: hash(token),
+		UserID:        userID,
+		ExpiresAt:     now.Add(expires).UTC(),
+		CreatedAt:     now.UTC(),
+	}
+
+	const q = `INSERT INTO mfa_challenges
+		(challenge_hash, user_id, attempts, expires_at, created_at)
+		VALUES ($1, $2, 0, $3, $4)`
+	if _, err := db.ExecContext(ctx, q, c.ChallengeHash, c.UserID, c.ExpiresAt, c.CreatedAt); err != nil {
+		return "", errors.Wrap(err, "inserting challenge")
+	}
+
+	return token, nil
+}
+
+// ChallengeEnroll consumes the challenge of a user who must enroll a factor
+// before answering and returns the ID of the user along with a new challenge
+// to answer with the first code. The new challenge expires with the old one
+// and carries over its attempts so it cannot be used to extend the login.
+func ChallengeEnroll(ctx context.Context, db *sqlx.DB, challenge string, now time.Time) (string, string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.mfa.ChallengeEnroll")
+	defer span.End()
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", errors.Wrap(err, "generating challenge")
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", "", errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	// Lock the row so the challenge is exchanged only once.
+	var c Challenge
+	const q = `SELECT * FROM mfa_challenges WHERE challenge_hash = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &c, q, hash(challenge)); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", ErrInvalidChallenge
+		}
+		return "", "", errors.Wrap(err, "selecting challenge")
+	}
+
+	if !usable(c, now) {
+		return "", "", ErrInvalidChallenge
+	}
+
+	const u = `UPDATE mfa_challenges SET used_at = $2 WHERE challenge_hash = $1`
+	if _, err := tx.ExecContext(ctx, u, c.ChallengeHash, now.UTC()); err != nil {
+		return "", "", errors.Wrap(err, "marking challenge used")
+	}
+
+	const i = `INSERT INTO mfa_challenges
+		(challenge_hash, user_id, attempts, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, i, hash(token), c.UserID, c.Attempts, c.ExpiresAt, now.UTC()); err != nil {
+		return "", "", errors.Wrap(err, "inserting challenge")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", errors.Wrap(err, "committing transaction")
+	}
+
+	return c.UserID, token, nil
+}
+
+// Answer consumes the challenge if the code is valid for its user and returns
+// the ID of the user. A pending enrollment is confirmed by its first code. A
+// challenge is refused after too many wrong codes.
+func Answer(ctx context.Context, db *sqlx.DB, challenge, code string, now time.Time) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.mfa.Answer")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	// Lock the row so concurrent answers cannot exceed the attempts.
+	var c Challenge
+	const q = `SELECT * FROM mfa_challenges WHERE challenge_hash = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &c, q, hash(challenge)); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrInvalidChallenge
+		}
+		return "", errors.Wrap(err, "selecting challenge")
+	}
+
+	if !usable(c, now) {
+		return "", ErrInvalidChallenge
+	}
+
+	err = verify(ctx, tx, c.UserID, code, now)
+	if err == ErrNotEnrolled {
+		err = confirm(ctx, tx, c.UserID, code, now)
+	}
+	switch err {
+	case nil:
+		const u = `UPDATE mfa_challenges SET used_at = $2 WHERE challenge_hash = $1`
+		if _, err := tx.ExecContext(ctx, u, c.ChallengeHash, now.UTC()); err != nil {
+			return "", errors.Wrap(err, "marking challenge used")
+		}
+	case ErrInvalidCode, ErrNotEnrolled:
+		const u = `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE challenge_hash = $1`
+		if _, err := tx.ExecContext(ctx, u, c.ChallengeHash); err != nil {
+			return "", errors.Wrap(err, "counting challenge attempt")
+		}
+	default:
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", errors.Wrap(err, "committing transaction")
+	}
+	if err != nil {
+		return "", ErrInvalidCode
+	}
+
+	return c.UserID, nil
+}
+
+// usable reports whether the challenge can still be answered.
+func usable(c Challenge, now time.Time) bool {
+	return c.UsedAt == nil && now.Before(c.ExpiresAt) && c.Attempts < maxAttempts
+}
+
+// confirm checks a code against the pending factor of the user and confirms
+// the factor.
+func confirm(ctx context.Context, tx *sqlx.Tx, userID, code string, now time.Time) error {
+	var f Factor
+	const q = `SELECT * FROM user_mfa WHERE user_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &f, q, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotEnrolled
+		}
+		return errors.Wrap(err, "selecting factor")
+	}
+	if f.ConfirmedAt != nil {
+		return ErrAlreadyEnrolled
+	}
+
+	step, ok := totp.Validate(f.Secret, code, now, skew)
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	const u = `UPDATE user_mfa SET confirmed_at = $2, last_step = $3 WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, u, userID, now.UTC(), step); err != nil {
+		return errors.Wrap(err, "confirming factor")
+	}
+
+	return nil
+}
+
+// verify checks a TOTP or recovery code against the confirmed factor of the
+// user. A TOTP code is accepted once and a recovery code is consumed.
+func verify(ctx context.Context, tx *sqlx.Tx, userID, code string, now time.Time) error {
+	var f Factor
+	const q = `SELECT * FROM user_mfa WHERE user_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &f, q, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotEnrolled
+		}
+		return errors.Wrap(err, "selecting factor")
+	}
+	if f.ConfirmedAt == nil {
+		return ErrNotEnrolled
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(f.Secret, code, now, skew); ok {
+		if step <= f.LastStep {
+			return ErrInvalidCode
+		}
+		const u = `UPDATE user_mfa SET last_step = $2 WHERE user_id = $1`
+		if _, err := tx.ExecContext(ctx, u, userID, step); err != nil {
+			return errors.Wrap(err, "recording code step")
+		}
+		return nil
+	}
+
+	const u = `UPDATE mfa_recovery_codes SET used_at = $3
+		WHERE code_hash = $1 AND user_id = $2 AND used_at IS NULL`
+	res, err := tx.ExecContext(ctx, u, hash(strings.ToLower(code)), userID, now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "using recovery code")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// random returns n random bytes encoded as hex.
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating recovery code")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hash returns the form of a challenge or recovery code stored in the
+// database. Both carry at least 64 bits of entropy and are rate limited so a
+// plain SHA-256 is sufficient.
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,206 @@
+package mfa_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sankarvj/seedgo/internal/account"
+	"github.com/sankarvj/seedgo/internal/mfa"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/platform/totp"
+	"github.com/sankarvj/seedgo/internal/tests"
+	"github.com/sankarvj/seedgo/internal/user"
+)
+
+// TestMFA validates factors can be enrolled, confirmed and used to answer
+// login challenges, and that codes and challenges are accepted only once.
+func TestMFA(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to work with second factors.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		a, err := account.Create(ctx, db, account.NewAccount{Name: "Wayplot", Domain: "Wayplot"}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
+		}
+
+		claims := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleAdmin}, now, time.Hour)
+		claims.AccountID = a.ID
+
+		newUser := func(email string) string {
+			nu := user.NewUser{
+				AccountID:       a.ID,
+				Name:            "Bill Kennedy",
+				Email:           email,
+				Roles:           []string{auth.RoleUser},
+				Password:        "gophers",
+				PasswordConfirm: "gophers",
+			}
+			u, err := user.Create(ctx, claims, db, nu, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+			}
+			return u.ID
+		}
+
+		// code returns the TOTP code of the secret at the time.
+		code := func(secret string, at time.Time) string {
+			c, err := totp.Code(secret, totp.Step(at))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to generate a code : %s.", tests.Failed, err)
+			}
+			return c
+		}
+
+		t.Log("\tWhen enrolling a factor.")
+		var e *mfa.Enrollment
+		userID := newUser("bill@ardanlabs.com")
+		{
+			e, err = mfa.Enroll(ctx, db, userID, "seedgo", "bill@ardanlabs.com", now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to enroll a factor : %s.", tests.Failed, err)
+			}
+			if e.Secret == "" || len(e.RecoveryCodes) != 10 {
+				t.Fatalf("\t%s\tShould get a secret and 10 recovery codes : %+v.", tests.Failed, e)
+			}
+			t.Logf("\t%s\tShould get a secret and 10 recovery codes.", tests.Success)
+
+			if enabled, err := mfa.Enabled(ctx, db, userID); err != nil || enabled {
+				t.Fatalf("\t%s\tShould NOT be enabled before it is confirmed : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be enabled before it is confirmed.", tests.Success)
+
+			if err := mfa.Confirm(ctx, db, userID, "bogus", now); err != mfa.ErrInvalidCode {
+				t.Fatalf("\t%s\tShould NOT be able to confirm with a wrong code : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to confirm with a wrong code.", tests.Success)
+
+			if err := mfa.Confirm(ctx, db, userID, code(e.Secret, now), now); err != nil {
+				t.Fatalf("\t%s\tShould be able to confirm with a code : %s.", tests.Failed, err)
+			}
+			if enabled, err := mfa.Enabled(ctx, db, userID); err != nil || !enabled {
+				t.Fatalf("\t%s\tShould be enabled once confirmed : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be enabled once confirmed.", tests.Success)
+
+			if _, err := mfa.Enroll(ctx, db, userID, "seedgo", "bill@ardanlabs.com", now); err != mfa.ErrAlreadyEnrolled {
+				t.Fatalf("\t%s\tShould NOT be able to enroll a second factor : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to enroll a second factor.", tests.Success)
+		}
+
+		t.Log("\tWhen answering a challenge.")
+		{
+			later := now.Add(totp.Period * time.Second)
+			challenge, err := mfa.NewChallenge(ctx, db, userID, now, 5*time.Minute)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create a challenge : %s.", tests.Failed, err)
+			}
+
+			if _, err := mfa.Answer(ctx, db, challenge, code(e.Secret, now), later); err != mfa.ErrInvalidCode {
+				t.Fatalf("\t%s\tShould NOT accept the code that confirmed the factor : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT accept the code that confirmed the factor.", tests.Success)
+
+			next := code(e.Secret, later)
+			got, err := mfa.Answer(ctx, db, challenge, next, later)
+			if err != nil || got != userID {
+				t.Fatalf("\t%s\tShould be able to answer with a new code : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to answer with a new code.", tests.Success)
+
+			if _, err := mfa.Answer(ctx, db, challenge, e.RecoveryCodes[0], later); err != mfa.ErrInvalidChallenge {
+				t.Fatalf("\t%s\tShould NOT be able to answer a challenge twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to answer a challenge twice.", tests.Success)
+
+			challenge, err = mfa.NewChallenge(ctx, db, userID, later, 5*time.Minute)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create a challenge : %s.", tests.Failed, err)
+			}
+			if _, err := mfa.Answer(ctx, db, challenge, next, later); err != mfa.ErrInvalidCode {
+				t.Fatalf("\t%s\tShould NOT accept a code of a step already used : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT accept a code of a step already used.", tests.Success)
+
+			recovery := strings.ToUpper(e.RecoveryCodes[0])
+			if got, err := mfa.Answer(ctx, db, challenge, recovery, later); err != nil || got != userID {
+				t.Fatalf("\t%s\tShould be able to answer with a recovery code : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to answer with a recovery code.", tests.Success)
+
+			challenge, err = mfa.NewChallenge(ctx, db, userID, later, 5*time.Minute)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create a challenge : %s.", tests.Failed, err)
+			}
+			if _, err := mfa.Answer(ctx, db, challenge, recovery, later); err != mfa.ErrInvalidCode {
+				t.Fatalf("\t%s\tShould NOT accept a recovery code twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT accept a recovery code twice.", tests.Success)
+
+			for i := 0; i < 4; i++ {
+				if _, err := mfa.Answer(ctx, db, challenge, "bogus", later); err != mfa.ErrInvalidCode {
+					t.Fatalf("\t%s\tShould NOT accept a wrong code : %v.", tests.Failed, err)
+				}
+			}
+			if _, err := mfa.Answer(ctx, db, challenge, e.RecoveryCodes[1], later); err != mfa.ErrInvalidChallenge {
+				t.Fatalf("\t%s\tShould refuse a challenge after 5 wrong codes : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse a challenge after 5 wrong codes.", tests.Success)
+
+			challenge, err = mfa.NewChallenge(ctx, db, userID, later, 5*time.Minute)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create a challenge : %s.", tests.Failed, err)
+			}
+			if _, err := mfa.Answer(ctx, db, challenge, e.RecoveryCodes[1], later.Add(5*time.Minute)); err != mfa.ErrInvalidChallenge {
+				t.Fatalf("\t%s\tShould refuse an expired challenge : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse an expired challenge.", tests.Success)
+		}
+
+		t.Log("\tWhen enrolling a factor during a login.")
+		{
+			userID := newUser("jill@ardanlabs.com")
+			challenge, err := mfa.NewChallenge(ctx, db, userID, now, 5*time.Minute)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create a challenge : %s.", tests.Failed, err)
+			}
+
+			got, next, err := mfa.ChallengeEnroll(ctx, db, challenge, now)
+			if err != nil || got != userID || next == "" || next == challenge {
+				t.Fatalf("\t%s\tShould exchange the challenge for a new one : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould exchange the challenge for a new one.", tests.Success)
+
+			if _, _, err := mfa.ChallengeEnroll(ctx, db, challenge, now); err != mfa.ErrInvalidChallenge {
+				t.Fatalf("\t%s\tShould NOT be able to exchange a challenge twice : %v.", tests.Failed, err)
+			}
+			if _, err := mfa.Answer(ctx, db, challenge, "bogus", now); err != mfa.ErrInvalidChallenge {
+				t.Fatalf("\t%s\tShould NOT be able to answer an exchanged challenge : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to reuse an exchanged challenge.", tests.Success)
+
+			e, err := mfa.Enroll(ctx, db, userID, "seedgo", "jill@ardanlabs.com", now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to enroll a factor : %s.", tests.Failed, err)
+			}
+			if got, err := mfa.Answer(ctx, db, next, code(e.Secret, now), now); err != nil || got != userID {
+				t.Fatalf("\t%s\tShould confirm the factor by answering the new challenge : %v.", tests.Failed, err)
+			}
+			if enabled, err := mfa.Enabled(ctx, db, userID); err != nil || !enabled {
+				t.Fatalf("\t%s\tShould be enabled once the challenge is answered : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould confirm the factor by answering the new challenge.", tests.Success)
+
+			if _, _, err := mfa.ChallengeEnroll(ctx, db, next, now); err != mfa.ErrInvalidChallenge {
+				t.Fatalf("\t%s\tShould NOT be able to exchange an answered challenge : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to exchange an answered challenge.", tests.Success)
+		}
+	}
+}
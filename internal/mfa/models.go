@@ -0,0 +1,48 @@
+package mfa
+
+import (
+	"time"
+)
+
+// Factor represents the TOTP factor of a user. The secret has to be kept to
+// check codes so it is stored as is. The factor protects logins once it is
+// confirmed with a first code.
+type Factor struct {
+	UserID      string     `db:"user_id" json:"user_id"`
+	Secret      string     `db:"secret" json:"-"`
+	LastStep    int64      `db:"last_step" json:"-"`
+	ConfirmedAt *time.Time `db:"confirmed_at" json:"confirmed_at"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+}
+
+// Challenge represents a pending second login step. Only the hash of the
+// opaque token handed to the client is stored.
+type Challenge struct {
+	ChallengeHash string     `db:"challenge_hash" json:"-"`
+	UserID        string     `db:"user_id" json:"user_id"`
+	Attempts      int        `db:"attempts" json:"attempts"`
+	ExpiresAt     time.Time  `db:"expires_at" json:"expires_at"`
+	UsedAt        *time.Time `db:"used_at" json:"used_at"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+}
+
+// Enrollment is returned when a user starts enrolling a factor. It is the only
+// time the secret and the recovery codes are available. A user enrolling
+// during a login gets the challenge to answer with the first code.
+type Enrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+	Challenge     string   `json:"challenge,omitempty"`
+}
+
+// CodeRequest is the body of a request carrying a TOTP or recovery code.
+type CodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// ChallengeRequest is the body of a request answering a login challenge.
+type ChallengeRequest struct {
+	Challenge string `json:"challenge" validate:"required"`
+	Code      string `json:"code"`
+}
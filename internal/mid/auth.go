@@ -5,21 +5,49 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/sankarvj/seedgo/internal/apikey"
 	"github.com/sankarvj/seedgo/internal/platform/auth"
 	"github.com/sankarvj/seedgo/internal/platform/web"
+	"github.com/sankarvj/seedgo/internal/policy"
+	"github.com/sankarvj/seedgo/internal/revocation"
 	"go.opencensus.io/trace"
 )
 
 // ErrForbidden is returned when an authenticated user does not have a
-// sufficient role for an action.
+// sufficient permission for an action.
 var ErrForbidden = web.NewRequestError(
 	errors.New("you are not authorized for that action"),
 	http.StatusForbidden,
 )
 
-// Authenticate validates a JWT from the `Authorization` header.
-func Authenticate(authenticator *auth.Authenticator) web.Middleware {
+// ErrImpersonated is returned when an action that cannot be taken on behalf
+// of someone else is attempted under impersonation.
+var ErrImpersonated = web.NewRequestError(
+	errors.New("that action is not allowed under impersonation"),
+	http.StatusForbidden,
+)
+
+// ErrInsufficientScope is returned when a token narrowed to scopes is used
+// for an action outside of them.
+var ErrInsufficientScope = web.NewRequestError(
+	errors.New("token scope does not cover that action"),
+	http.StatusForbidden,
+)
+
+// ErrRevoked is returned when a valid token has been revoked.
+var ErrRevoked = web.NewRequestError(
+	errors.New("token has been revoked"),
+	http.StatusUnauthorized,
+)
+
+// APIKeyFunc exchanges an API key for the claims it grants.
+type APIKeyFunc func(ctx context.Context, key string, now time.Time) (auth.Claims, error)
+
+// Authenticate validates a JWT or an API key from the `Authorization` header
+// and rejects tokens found in the revocation store.
+func Authenticate(authenticator *auth.Authenticator, revocations *revocation.Store, apiKeys APIKeyFunc) web.Middleware {
 
 	// This is the actual middleware function to be executed.
 	f := func(after web.Handler) web.Handler {
@@ -30,18 +58,56 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 			defer span.End()
 
 			// Parse the authorization header. Expected header is of
-			// the format `Bearer <token>`.
+			// the format `Bearer <token>` or `ApiKey <key>`.
 			parts := strings.Split(r.Header.Get("Authorization"), " ")
-			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-				err := errors.New("expected authorization header format: Bearer <token>")
+			if len(parts) != 2 {
+				err := errors.New("expected authorization header format: Bearer <token> or ApiKey <key>")
 				return web.NewRequestError(err, http.StatusUnauthorized)
 			}
 
-			claims, err := authenticator.ParseClaims(parts[1])
-			if err != nil {
+			var claims auth.Claims
+			var err error
+			switch strings.ToLower(parts[0]) {
+			case "bearer":
+				claims, err = authenticator.ParseClaims(parts[1])
+				if err != nil {
+					return web.NewRequestError(err, http.StatusUnauthorized)
+				}
+
+			case "apikey":
+				v, ok := ctx.Value(web.KeyValues).(*web.Values)
+				if !ok {
+					return web.NewShutdownError("web value missing from context")
+				}
+				claims, err = apiKeys(ctx, parts[1], v.Now)
+				if err != nil {
+					if err == apikey.ErrInvalidKey {
+						return web.NewRequestError(err, http.StatusUnauthorized)
+					}
+					return err
+				}
+
+			default:
+				err := errors.New("expected authorization header format: Bearer <token> or ApiKey <key>")
 				return web.NewRequestError(err, http.StatusUnauthorized)
 			}
 
+			revoked, err := revocations.IsRevoked(ctx, claims)
+			if err != nil {
+				return err
+			}
+			if revoked {
+				return ErrRevoked
+			}
+
+			// Record who is calling so the request logs show it.
+			if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+				v.Subject = claims.Subject
+				if claims.Impersonated() {
+					v.Actor = claims.Actor.Subject
+				}
+			}
+
 			// Add claims to the context so they can be retrieved later.
 			ctx = context.WithValue(ctx, auth.Key, claims)
 
@@ -54,23 +120,25 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 	return f
 }
 
-// HasRole validates that an authenticated user has at least one role from a
-// specified list. This method constructs the actual function that is used.
-func HasRole(roles ...string) web.Middleware {
+// HasPermission validates that an authenticated user has every permission
+// from a specified list. The permissions are resolved from the roles of the
+// user when the token is issued and carried in its claims. The decision is
+// left to policy.HasPermission so it matches the checks of business calls.
+func HasPermission(perms ...string) web.Middleware {
 
 	// This is the actual middleware function to be executed.
 	f := func(after web.Handler) web.Handler {
 
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
-			ctx, span := trace.StartSpan(ctx, "internal.mid.HasRole")
+			ctx, span := trace.StartSpan(ctx, "internal.mid.HasPermission")
 			defer span.End()
 
 			claims, ok := ctx.Value(auth.Key).(auth.Claims)
 			if !ok {
-				return errors.New("claims missing from context: HasRole called without/before Authenticate")
+				return errors.New("claims missing from context: HasPermission called without/before Authenticate")
 			}
 
-			if !claims.HasRole(roles...) {
+			if err := policy.HasPermission(claims, perms...); err != nil {
 				return ErrForbidden
 			}
 
@@ -82,3 +150,63 @@ func HasRole(roles ...string) web.Middleware {
 
 	return f
 }
+
+// NotImpersonated rejects tokens issued for impersonation. It guards the
+// sensitive actions only the user themselves may take, such as managing
+// credentials, sessions and access.
+func NotImpersonated() web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			ctx, span := trace.StartSpan(ctx, "internal.mid.NotImpersonated")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: NotImpersonated called without/before Authenticate")
+			}
+
+			if claims.Impersonated() {
+				return ErrImpersonated
+			}
+
+			return after(ctx, w, r, params)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// RequireScope validates that a token narrowed to scopes covers every scope
+// from a specified list. Tokens that are not narrowed pass so they keep the
+// access granted by their roles.
+func RequireScope(scopes ...string) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			ctx, span := trace.StartSpan(ctx, "internal.mid.RequireScope")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: RequireScope called without/before Authenticate")
+			}
+
+			if !claims.HasScope(scopes...) {
+				return ErrInsufficientScope
+			}
+
+			return after(ctx, w, r, params)
+		}
+
+		return h
+	}
+
+	return f
+}
@@ -0,0 +1,261 @@
+package mid_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sankarvj/seedgo/internal/account"
+	"github.com/sankarvj/seedgo/internal/mid"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/platform/web"
+	"github.com/sankarvj/seedgo/internal/revocation"
+	"github.com/sankarvj/seedgo/internal/session"
+	"github.com/sankarvj/seedgo/internal/tests"
+	"github.com/sankarvj/seedgo/internal/user"
+)
+
+// ok is the handler behind the middleware under test. It records the claims
+// it was called with.
+type next struct {
+	called bool
+	claims auth.Claims
+}
+
+func (o *next) handle(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	o.called = true
+	o.claims, _ = ctx.Value(auth.Key).(auth.Claims)
+	return nil
+}
+
+// TestAuthenticate validates the tokens of a terminated session are rejected.
+func TestAuthenticate(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	key, err := auth.GenerateKey(auth.AlgRS256)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	kr, err := auth.NewKeyring("kid-1", auth.AlgRS256, key)
+	if err != nil {
+		t.Fatalf("constructing keyring: %s", err)
+	}
+	a, err := auth.NewKeyringAuthenticator(kr, kr.Lookup)
+	if err != nil {
+		t.Fatalf("constructing authenticator: %s", err)
+	}
+	revocations := revocation.NewStore(db, time.Hour, time.Hour)
+	authenticate := mid.Authenticate(a, revocations, nil)
+
+	t.Log("Given the need to reject the tokens of terminated sessions.")
+	{
+		t.Log("\tWhen a session of a user is terminated.")
+		{
+			ctx := tests.Context()
+			now := time.Now()
+
+			acc, err := account.Create(ctx, db, account.NewAccount{Name: "Wayplot", Domain: "Wayplot"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
+			}
+			nu := user.NewUser{
+				AccountID:       acc.ID,
+				Name:            "Anna Walker",
+				Email:           "anna@ardanlabs.com",
+				Roles:           []string{auth.RoleUser},
+				Password:        "gophers",
+				PasswordConfirm: "gophers",
+			}
+			u, err := user.Create(ctx, auth.Claims{Roles: []string{auth.RoleSuperAdmin}}, db, nu, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+			}
+
+			// call sends a request bearing a token of the session through the
+			// middleware.
+			call := func(sessionID string) (*next, error) {
+				t.Helper()
+				claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour)
+				claims.AccountID = acc.ID
+				claims.SessionID = sessionID
+				tkn, err := a.GenerateToken(claims)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to generate a token : %s.", tests.Failed, err)
+				}
+
+				r := httptest.NewRequest("GET", "/v1/users", nil)
+				r.Header.Set("Authorization", "Bearer "+tkn)
+				h := &next{}
+				return h, authenticate(h.handle)(tests.Context(), httptest.NewRecorder(), r, nil)
+			}
+
+			terminated, err := session.Create(ctx, db, session.NewSession{UserID: u.ID}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create a session : %s.", tests.Failed, err)
+			}
+			other, err := session.Create(ctx, db, session.NewSession{UserID: u.ID}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create a session : %s.", tests.Failed, err)
+			}
+
+			h, err := call(terminated.ID)
+			if err != nil || !h.called || h.claims.SessionID != terminated.ID {
+				t.Fatalf("\t%s\tShould accept a token of a live session : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould accept a token of a live session.", tests.Success)
+
+			if err := session.Terminate(ctx, db, u.ID, terminated.ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to terminate the session : %s.", tests.Failed, err)
+			}
+			if err := revocations.RevokeSession(ctx, terminated.ID, now.Add(time.Hour), now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the session : %s.", tests.Failed, err)
+			}
+
+			h, err = call(terminated.ID)
+			if err != mid.ErrRevoked || h.called {
+				t.Fatalf("\t%s\tShould reject a token of the terminated session : %v.", tests.Failed, err)
+			}
+			if webErr, ok := err.(*web.Error); !ok || webErr.Status != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tShould respond with 401 to a token of the terminated session : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould reject a token of the terminated session.", tests.Success)
+
+			if h, err := call(other.ID); err != nil || !h.called {
+				t.Fatalf("\t%s\tShould accept a token of another session : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould accept a token of another session.", tests.Success)
+		}
+	}
+}
+
+// TestNotImpersonated validates impersonated tokens cannot be used for the
+// sensitive actions only the user themselves may take.
+func TestNotImpersonated(t *testing.T) {
+	t.Log("Given the need to keep impersonators out of sensitive actions.")
+	{
+		now := time.Now()
+		own := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleUser}, now, time.Hour)
+		impersonated := own
+		impersonated.Actor = &auth.Actor{Subject: "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"}
+
+		t.Log("\tWhen the user calls with their own token.")
+		{
+			h := &next{}
+			ctx := context.WithValue(tests.Context(), auth.Key, own)
+			if err := mid.NotImpersonated()(h.handle)(ctx, httptest.NewRecorder(), httptest.NewRequest("PUT", "/v1/users/mfa", nil), nil); err != nil || !h.called {
+				t.Fatalf("\t%s\tShould let the user through : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould let the user through.", tests.Success)
+		}
+
+		t.Log("\tWhen an admin calls with a token impersonating the user.")
+		{
+			h := &next{}
+			ctx := context.WithValue(tests.Context(), auth.Key, impersonated)
+			err := mid.NotImpersonated()(h.handle)(ctx, httptest.NewRecorder(), httptest.NewRequest("PUT", "/v1/users/mfa", nil), nil)
+			if err != mid.ErrImpersonated || h.called {
+				t.Fatalf("\t%s\tShould refuse the impersonator : %v.", tests.Failed, err)
+			}
+			if webErr, ok := err.(*web.Error); !ok || webErr.Status != http.StatusForbidden {
+				t.Fatalf("\t%s\tShould respond with 403 to the impersonator : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse the impersonator with 403.", tests.Success)
+		}
+	}
+}
+
+// TestRequireScope validates tokens narrowed to scopes only reach the routes
+// their scopes cover while other tokens keep the access of their roles.
+func TestRequireScope(t *testing.T) {
+	tt := []struct {
+		name  string
+		scope string
+		ok    bool
+	}{
+		{"a token that is not narrowed", "", true},
+		{"a token narrowed to every scope required", "users:read users:write accounts:read", true},
+		{"a token narrowed to some of the scopes required", "users:read accounts:read", false},
+		{"a token narrowed to other scopes", "roles:read", false},
+	}
+
+	t.Log("Given the need to keep narrowed tokens within their scopes.")
+	{
+		for _, tc := range tt {
+			t.Logf("\tWhen calling a route requiring users:read and users:write with %s.", tc.name)
+			{
+				claims := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleAdmin}, time.Now(), time.Hour)
+				claims.Scope = tc.scope
+
+				h := &next{}
+				ctx := context.WithValue(tests.Context(), auth.Key, claims)
+				require := mid.RequireScope(auth.PermUsersRead, auth.PermUsersWrite)
+				err := require(h.handle)(ctx, httptest.NewRecorder(), httptest.NewRequest("PUT", "/v1/users/x", nil), nil)
+
+				if claims.HasScope(auth.PermUsersRead, auth.PermUsersWrite) != tc.ok {
+					t.Fatalf("\t%s\tShould report whether the token covers the scopes : %q.", tests.Failed, tc.scope)
+				}
+				switch {
+				case tc.ok && (err != nil || !h.called):
+					t.Fatalf("\t%s\tShould let the token through : %v.", tests.Failed, err)
+				case !tc.ok && (err != mid.ErrInsufficientScope || h.called):
+					t.Fatalf("\t%s\tShould refuse the token : %v.", tests.Failed, err)
+				}
+				if tc.ok {
+					t.Logf("\t%s\tShould let the token through.", tests.Success)
+				} else {
+					t.Logf("\t%s\tShould refuse the token.", tests.Success)
+				}
+			}
+		}
+	}
+}
+
+// TestHasPermission validates routes can require permissions resolved from
+// the claims, within the scopes of narrowed tokens.
+func TestHasPermission(t *testing.T) {
+	tt := []struct {
+		name  string
+		roles []string
+		perms []string
+		scope string
+		ok    bool
+	}{
+		{"an admin", []string{auth.RoleAdmin}, nil, "", true},
+		{"a user", []string{auth.RoleUser}, nil, "", false},
+		{"a custom role granting the permissions", []string{"support"}, []string{auth.PermUsersRead, auth.PermUsersWrite}, "", true},
+		{"a custom role granting some of the permissions", []string{"support"}, []string{auth.PermUsersRead}, "", false},
+		{"an admin token narrowed to other scopes", []string{auth.RoleAdmin}, nil, "users:read", false},
+	}
+
+	t.Log("Given the need to require permissions on routes.")
+	{
+		for _, tc := range tt {
+			t.Logf("\tWhen calling a route requiring users:read and users:write as %s.", tc.name)
+			{
+				claims := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", tc.roles, time.Now(), time.Hour)
+				claims.Permissions = tc.perms
+				claims.Scope = tc.scope
+
+				h := &next{}
+				ctx := context.WithValue(tests.Context(), auth.Key, claims)
+				require := mid.HasPermission(auth.PermUsersRead, auth.PermUsersWrite)
+				err := require(h.handle)(ctx, httptest.NewRecorder(), httptest.NewRequest("PUT", "/v1/users/x", nil), nil)
+
+				switch {
+				case tc.ok && (err != nil || !h.called):
+					t.Fatalf("\t%s\tShould let the token through : %v.", tests.Failed, err)
+				case !tc.ok && (err != mid.ErrForbidden || h.called):
+					t.Fatalf("\t%s\tShould refuse the token : %v.", tests.Failed, err)
+				}
+				if tc.ok {
+					t.Logf("\t%s\tShould let the token through.", tests.Success)
+				} else {
+					t.Logf("\t%s\tShould refuse the token.", tests.Success)
+				}
+			}
+		}
+	}
+}
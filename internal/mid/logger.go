@@ -12,6 +12,9 @@ import (
 
 // Logger writes some information about the request to the logs in the
 // format: TraceID : (200) GET /foo -> IP ADDR (latency)
+//
+// Requests made under impersonation are followed by the admin acting and the
+// user they act as in the format: : actor ACTOR as SUBJECT
 func Logger(log *log.Logger) web.Middleware {
 
 	// This is the actual middleware function to be executed.
@@ -31,11 +34,20 @@ func Logger(log *log.Logger) web.Middleware {
 
 			err := before(ctx, w, r, params)
 
-			log.Printf("%s : (%d) : %s %s -> %s (%s)",
-				v.TraceID, v.StatusCode,
-				r.Method, r.URL.Path,
-				r.RemoteAddr, time.Since(v.Now),
-			)
+			if v.Actor != "" {
+				log.Printf("%s : (%d) : %s %s -> %s (%s) : actor %s as %s",
+					v.TraceID, v.StatusCode,
+					r.Method, r.URL.Path,
+					r.RemoteAddr, time.Since(v.Now),
+					v.Actor, v.Subject,
+				)
+			} else {
+				log.Printf("%s : (%d) : %s %s -> %s (%s)",
+					v.TraceID, v.StatusCode,
+					r.Method, r.URL.Path,
+					r.RemoteAddr, time.Since(v.Now),
+				)
+			}
 
 			// Return the error so it can be handled further up the chain.
 			return err
@@ -0,0 +1,57 @@
+package mid
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/throttle"
+	"github.com/sankarvj/seedgo/internal/platform/web"
+	"go.opencensus.io/trace"
+)
+
+// Throttle slows down and locks out IP addresses whose requests keep failing
+// to authenticate. Throttled requests are refused with 429 Too Many Requests
+// and a Retry-After header. Lockouts are logged with the trace ID.
+func Throttle(log *log.Logger, ips *throttle.Throttle) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			ctx, span := trace.StartSpan(ctx, "internal.mid.Throttle")
+			defer span.End()
+
+			v, ok := ctx.Value(web.KeyValues).(*web.Values)
+			if !ok {
+				return web.NewShutdownError("web value missing from context")
+			}
+
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
+
+			if wait := ips.Wait(ip, v.Now); wait > 0 {
+				return throttle.Reject(w, wait)
+			}
+
+			err = after(ctx, w, r, params)
+
+			// Only failures to authenticate count against the address.
+			if webErr, ok := errors.Cause(err).(*web.Error); ok && webErr.Status == http.StatusUnauthorized {
+				if wait, locked := ips.Fail(ip, v.Now); locked {
+					log.Printf("%s : LOCKOUT : ip %s locked out for %s", v.TraceID, ip, wait)
+				}
+			}
+
+			return err
+		}
+
+		return h
+	}
+
+	return f
+}
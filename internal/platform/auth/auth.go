@@ -1,15 +1,13 @@
 package auth
 
 import (
-	"crypto/rsa"
-	"fmt"
-
 	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 )
 
-// KeyLookupFunc is used to map a JWT key id (kid) to the corresponding public key.
-// It is a requirement for creating an Authenticator.
+// KeyLookupFunc is used to map a JWT key id (kid) to the corresponding
+// verification key and the algorithm it is bound to. It is a requirement for
+// creating an Authenticator.
 //
 // * Private keys should be rotated. During the transition period, tokens
 // signed with the old and new keys can coexist by looking up the correct
@@ -17,47 +15,41 @@ import (
 //
 // * Key-id-to-public-key resolution is usually accomplished via a public JWKS
 // endpoint. See https://auth0.com/docs/jwks for more details.
-type KeyLookupFunc func(kid string) (*rsa.PublicKey, error)
-
-// NewSimpleKeyLookupFunc is a simple implementation of KeyFunc that only ever
-// supports one key. This is easy for development but in production should be
-// replaced with a caching layer that calls a JWKS endpoint.
-func NewSimpleKeyLookupFunc(activeKID string, publicKey *rsa.PublicKey) KeyLookupFunc {
-	f := func(kid string) (*rsa.PublicKey, error) {
-		if activeKID != kid {
-			return nil, fmt.Errorf("unrecognized key id %q", kid)
-		}
-		return publicKey, nil
-	}
-
-	return f
-}
+type KeyLookupFunc func(kid string) (VerifyKey, error)
 
 // Authenticator is used to authenticate clients. It can generate a token for a
 // set of user claims and recreate the claims by parsing the token.
 type Authenticator struct {
-	privateKey       *rsa.PrivateKey
-	activeKID        string
-	algorithm        string
+	keyring          *Keyring
 	pubKeyLookupFunc KeyLookupFunc
 	parser           *jwt.Parser
-	GoogleKeyFile    string
 }
 
-// NewAuthenticator creates an *Authenticator for use. It will error if:
+// NewAuthenticator creates an *Authenticator for use. The private key is an
+// *rsa.PrivateKey, an *ecdsa.PrivateKey, an ed25519.PrivateKey or the []byte
+// secret of HMAC. A blank algorithm picks the default for the key. It will
+// error if:
 // - The private key is nil.
 // - The public key func is nil.
 // - The key ID is blank.
-// - The specified algorithm is unsupported.
-func NewAuthenticator(privateKey *rsa.PrivateKey, googleKeyFile string, activeKID, algorithm string, publicKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
-	if privateKey == nil {
-		return nil, errors.New("private key cannot be nil")
-	}
-	if activeKID == "" {
-		return nil, errors.New("active kid cannot be blank")
+// - The specified algorithm is unsupported or does not fit the key.
+func NewAuthenticator(privateKey interface{}, activeKID, algorithm string, publicKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
+	keyring, err := NewKeyring(activeKID, algorithm, privateKey)
+	if err != nil {
+		return nil, err
 	}
-	if jwt.GetSigningMethod(algorithm) == nil {
-		return nil, errors.Errorf("unknown algorithm %v", algorithm)
+
+	return NewKeyringAuthenticator(keyring, publicKeyLookupFunc)
+}
+
+// NewKeyringAuthenticator creates an *Authenticator that signs tokens with the
+// active key of the keyring, using the algorithm bound to that key. It will
+// error if:
+// - The keyring is nil.
+// - The public key func is nil.
+func NewKeyringAuthenticator(keyring *Keyring, publicKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
+	if keyring == nil {
+		return nil, errors.New("keyring cannot be nil")
 	}
 	if publicKeyLookupFunc == nil {
 		return nil, errors.New("public key function cannot be nil")
@@ -66,30 +58,31 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, googleKeyFile string, activeKI
 	// Create the token parser to use. The algorithm used to sign the JWT must be
 	// validated to avoid a critical vulnerability:
 	// https://auth0.com/blog/critical-vulnerabilities-in-json-web-token-libraries/
+	// Each key id is bound to one algorithm which the token must use on top of
+	// being one of the supported algorithms.
 	parser := jwt.Parser{
-		ValidMethods: []string{algorithm},
+		ValidMethods: Algorithms,
 	}
 
 	a := Authenticator{
-		privateKey:       privateKey,
-		activeKID:        activeKID,
-		algorithm:        algorithm,
+		keyring:          keyring,
 		pubKeyLookupFunc: publicKeyLookupFunc,
 		parser:           &parser,
-		GoogleKeyFile:    googleKeyFile,
 	}
 
 	return &a, nil
 }
 
-// GenerateToken generates a signed JWT token string representing the user Claims.
+// GenerateToken generates a signed JWT token string representing the user
+// Claims. It is signed with the active key of the keyring.
 func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
-	method := jwt.GetSigningMethod(a.algorithm)
+	kid, key := a.keyring.Active()
+	method := jwt.GetSigningMethod(key.Algorithm)
 
 	tkn := jwt.NewWithClaims(method, claims)
-	tkn.Header["kid"] = a.activeKID
+	tkn.Header["kid"] = kid
 
-	str, err := tkn.SignedString(a.privateKey)
+	str, err := tkn.SignedString(key.Key)
 	if err != nil {
 		return "", errors.Wrap(err, "signing token")
 	}
@@ -97,24 +90,21 @@ func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
 	return str, nil
 }
 
+// JWKS returns the set of public keys tokens issued by this Authenticator can
+// be verified with: the active key and every verify-only key. It is intended
+// to be published so other services can verify our tokens. HMAC secrets are
+// never published.
+func (a *Authenticator) JWKS() JWKSet {
+	return a.keyring.JWKS()
+}
+
 // ParseClaims recreates the Claims that were used to generate a token. It
 // verifies that the token was signed using our key.
 func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
-	// f is a function that returns the public key for validating a token. We use
-	// the parsed (but unverified) token to find the key id. That ID is passed to
-	// our KeyFunc to find the public key to use for verification.
-	keyFunc := func(t *jwt.Token) (interface{}, error) {
-		kid, ok := t.Header["kid"]
-		if !ok {
-			return nil, errors.New("missing key id (kid) in token header")
-		}
-		userKID, ok := kid.(string)
-		if !ok {
-			return nil, errors.New("user token key id (kid) must be string")
-		}
-
-		return a.pubKeyLookupFunc(userKID)
-	}
+	// keyFunc returns the public key for validating a token. We use the parsed
+	// (but unverified) token to find the key id. That ID is passed to our
+	// KeyFunc to find the public key to use for verification.
+	keyFunc := bindKey(a.pubKeyLookupFunc)
 
 	var claims Claims
 	token, err := a.parser.ParseWithClaims(tokenStr, &claims, keyFunc)
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// GoogleCertsURL is where Google publishes the x509 certificates Firebase ID
+// tokens are signed with.
+const GoogleCertsURL = "https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com"
+
+// FirebaseConfig holds the settings used to construct a Firebase identity
+// provider.
+type FirebaseConfig struct {
+
+	// ProjectID is the Firebase project the ID tokens must be issued for.
+	ProjectID string
+
+	// CertsURL is where the signing certificates are fetched from. The zero
+	// value uses GoogleCertsURL. Tests point it at a local stub.
+	CertsURL string
+
+	// CertsRefresh is how often the signing certificates are refreshed.
+	CertsRefresh time.Duration
+
+	// Log receives the errors of the background refreshes. Nil discards them.
+	Log *log.Logger
+}
+
+// Firebase is an IdentityProvider verifying Firebase ID tokens. Tokens are
+// verified in-process against a cached copy of Google's signing certificates
+// so verifying a token does not cost a network round trip.
+type Firebase struct {
+	projectID string
+	certs     *JWKS
+	parser    *jwt.Parser
+}
+
+// NewFirebase constructs a Firebase identity provider. Call Shutdown to stop
+// the background refresh of the signing certificates.
+func NewFirebase(cfg FirebaseConfig) (*Firebase, error) {
+	if cfg.ProjectID == "" {
+		return nil, errors.New("firebase project id cannot be blank")
+	}
+	if cfg.CertsURL == "" {
+		cfg.CertsURL = GoogleCertsURL
+	}
+
+	certs, err := newKeySet(JWKSConfig{URL: cfg.CertsURL, RefreshInterval: cfg.CertsRefresh, Log: cfg.Log}, decodeX509Certs)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching firebase certificates")
+	}
+
+	f := Firebase{
+		projectID: cfg.ProjectID,
+		certs:     certs,
+		parser: &jwt.Parser{
+			ValidMethods: []string{"RS256"},
+		},
+	}
+
+	return &f, nil
+}
+
+// FirebaseProjectID reads the project id from a Google service account
+// credentials file.
+func FirebaseProjectID(credentialsFile string) (string, error) {
+	data, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return "", errors.Wrap(err, "reading credentials file")
+	}
+
+	var cred struct {
+		ProjectID string `json:"project_id"`
+	}
+	if err := json.Unmarshal(data, &cred); err != nil {
+		return "", errors.Wrap(err, "decoding credentials file")
+	}
+
+	return cred.ProjectID, nil
+}
+
+// Shutdown stops the background refresh of the signing certificates.
+func (f *Firebase) Shutdown() {
+	f.certs.Shutdown()
+}
+
+// Name implements IdentityProvider.
+func (f *Firebase) Name() string {
+	return "firebase"
+}
+
+// Verify implements IdentityProvider. The credential is a Firebase ID token.
+// It is checked as described in
+// https://firebase.google.com/docs/auth/admin/verify-id-tokens#verify_id_tokens_using_a_third-party_jwt_library
+func (f *Firebase) Verify(ctx context.Context, credential string) (Identity, error) {
+	keyFunc := bindKey(f.certs.Lookup)
+
+	// Parsing validates the signature and the exp, iat and nbf claims.
+	var claims jwt.MapClaims
+	if _, err := f.parser.ParseWithClaims(credential, &claims, keyFunc); err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidCredential, err.Error())
+	}
+
+	now := time.Now().Unix()
+	switch {
+	case !claims.VerifyIssuer("https://securetoken.google.com/"+f.projectID, true):
+		return Identity{}, errors.Wrap(ErrInvalidCredential, "unexpected issuer")
+	case !hasAudience(claims["aud"], f.projectID):
+		return Identity{}, errors.Wrap(ErrInvalidCredential, "unexpected audience")
+	case !claims.VerifyExpiresAt(now, true):
+		return Identity{}, errors.Wrap(ErrInvalidCredential, "missing expiry")
+	case !claims.VerifyIssuedAt(now, true):
+		return Identity{}, errors.Wrap(ErrInvalidCredential, "missing issued at")
+	}
+	if authTime, ok := claims["auth_time"].(float64); !ok || int64(authTime) > now {
+		return Identity{}, errors.Wrap(ErrInvalidCredential, "invalid auth time")
+	}
+
+	id := identityFromClaims(f.Name(), claims)
+	if id.Subject == "" || len(id.Subject) > 128 {
+		return Identity{}, errors.Wrap(ErrInvalidCredential, "invalid subject")
+	}
+
+	return id, nil
+}
+
+// decodeX509Certs decodes Google's certificate document, a JSON object mapping
+// key ids to PEM encoded x509 certificates. Firebase signs with RS256 only.
+func decodeX509Certs(r io.Reader) (map[string]VerifyKey, error) {
+	var doc map[string]string
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "decoding certificates")
+	}
+
+	keys := make(map[string]VerifyKey, len(doc))
+	for kid, data := range doc {
+		block, _ := pem.Decode([]byte(data))
+		if block == nil {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		key, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		keys[kid] = VerifyKey{Algorithm: AlgRS256, Key: key}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,112 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// TestFirebase validates Firebase ID tokens are verified locally against the
+// published certificates.
+func TestFirebase(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "securetoken.system.gserviceaccount.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := map[string]string{
+		"kid-1": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(certs)
+	}))
+	defer srv.Close()
+
+	const project = "seed-project"
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            "https://securetoken.google.com/" + project,
+			"aud":            project,
+			"sub":            "uid-1",
+			"iat":            now.Add(-time.Minute).Unix(),
+			"exp":            now.Add(time.Hour).Unix(),
+			"auth_time":      now.Add(-time.Minute).Unix(),
+			"email":          "anna@example.com",
+			"email_verified": true,
+		}
+	}
+	sign := func(claims jwt.MapClaims) string {
+		tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		tkn.Header["kid"] = "kid-1"
+		str, err := tkn.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return str
+	}
+
+	t.Log("Given the need to verify Firebase ID tokens.")
+	{
+		f, err := auth.NewFirebase(auth.FirebaseConfig{ProjectID: project, CertsURL: srv.URL})
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to construct the provider : %s.", tests.Failed, err)
+		}
+		defer f.Shutdown()
+
+		t.Log("\tWhen the ID token is valid.")
+		{
+			id, err := f.Verify(context.Background(), sign(valid()))
+			if err != nil {
+				t.Fatalf("\t%s\tShould accept the token : %s.", tests.Failed, err)
+			}
+			if id.Subject != "uid-1" || id.Email != "anna@example.com" || !id.EmailVerified {
+				t.Fatalf("\t%s\tShould take the identity from the token claims : %+v.", tests.Failed, id)
+			}
+			t.Logf("\t%s\tShould take the identity from the token claims.", tests.Success)
+		}
+
+		t.Log("\tWhen the ID token is not valid for the project.")
+		{
+			for name, mod := range map[string]func(jwt.MapClaims){
+				"issuer":    func(c jwt.MapClaims) { c["iss"] = "https://securetoken.google.com/other" },
+				"audience":  func(c jwt.MapClaims) { c["aud"] = "other" },
+				"expiry":    func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() },
+				"subject":   func(c jwt.MapClaims) { delete(c, "sub") },
+				"auth time": func(c jwt.MapClaims) { c["auth_time"] = now.Add(time.Hour).Unix() },
+			} {
+				c := valid()
+				mod(c)
+				if _, err := f.Verify(context.Background(), sign(c)); errors.Cause(err) != auth.ErrInvalidCredential {
+					t.Fatalf("\t%s\tShould reject a token with a bad %s : %v.", tests.Failed, name, err)
+				}
+				t.Logf("\t%s\tShould reject a token with a bad %s.", tests.Success, name)
+			}
+		}
+	}
+}
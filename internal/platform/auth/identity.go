@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidCredential is returned by an IdentityProvider when the credential
+// it was asked to verify is malformed, expired or not signed by the provider.
+var ErrInvalidCredential = errors.New("identity credential is not valid")
+
+// Identity is a user identity verified by an external identity provider. The
+// fields besides Provider and Subject are optional and only set when the
+// provider shares them.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Phone         string
+	Avatar        string
+	IssuedAt      time.Time
+}
+
+// IdentityProvider verifies a credential issued by an external identity
+// provider, such as an ID token, and returns the identity it asserts.
+type IdentityProvider interface {
+
+	// Name is the value stored as the provider of users linked to this
+	// identity provider.
+	Name() string
+
+	// Verify checks the credential and returns the identity it asserts. It
+	// returns an error with cause ErrInvalidCredential when the credential is
+	// rejected.
+	Verify(ctx context.Context, credential string) (Identity, error)
+}
+
+// identityFromClaims builds an Identity from the standard OpenID Connect
+// claims of a verified ID token.
+func identityFromClaims(provider string, claims map[string]interface{}) Identity {
+	str := func(name string) string {
+		s, _ := claims[name].(string)
+		return s
+	}
+
+	id := Identity{
+		Provider: provider,
+		Subject:  str("sub"),
+		Email:    str("email"),
+		Name:     str("name"),
+		Phone:    str("phone_number"),
+		Avatar:   str("picture"),
+	}
+	id.EmailVerified, _ = claims["email_verified"].(bool)
+	if iat, ok := claims["iat"].(float64); ok {
+		id.IssuedAt = time.Unix(int64(iat), 0).UTC()
+	}
+
+	return id
+}
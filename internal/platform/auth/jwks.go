@@ -0,0 +1,420 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// JWK represents a single JSON Web Key as described by RFC 7517. Only the
+// members needed to describe RSA, EC and Ed25519 (OKP) public keys are
+// supported.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKSet represents a JSON Web Key Set as described by RFC 7517.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewRSAJWK constructs the JWK representation of an RSA public key.
+func NewRSAJWK(kid, algorithm string, key *rsa.PublicKey) JWK {
+	return JWK{
+		KeyType:   "RSA",
+		KeyID:     kid,
+		Use:       "sig",
+		Algorithm: algorithm,
+		N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// NewJWK constructs the JWK representation of a verification key. HMAC keys
+// are secret and cannot be represented.
+func NewJWK(kid string, key VerifyKey) (JWK, error) {
+	switch k := key.Key.(type) {
+	case *rsa.PublicKey:
+		return NewRSAJWK(kid, key.Algorithm, k), nil
+
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk := JWK{
+			KeyType:   "EC",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: key.Algorithm,
+			Curve:     k.Curve.Params().Name,
+			X:         base64.RawURLEncoding.EncodeToString(padded(k.X.Bytes(), size)),
+			Y:         base64.RawURLEncoding.EncodeToString(padded(k.Y.Bytes(), size)),
+		}
+		return jwk, nil
+
+	case ed25519.PublicKey:
+		jwk := JWK{
+			KeyType:   "OKP",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: key.Algorithm,
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(k),
+		}
+		return jwk, nil
+	}
+
+	return JWK{}, errors.Errorf("key of type %T cannot be published", key.Key)
+}
+
+// VerifyKey decodes the public key held by the JWK and binds it to its
+// algorithm. RSA keys without an algorithm are bound to RS256, EC and OKP keys
+// to the algorithm of their curve.
+func (k JWK) VerifyKey() (VerifyKey, error) {
+	var key VerifyKey
+	switch k.KeyType {
+	case "RSA":
+		pub, err := k.RSAPublicKey()
+		if err != nil {
+			return VerifyKey{}, err
+		}
+		key = VerifyKey{Algorithm: AlgRS256, Key: pub}
+		if k.Algorithm != "" {
+			key.Algorithm = k.Algorithm
+		}
+		if key.Algorithm != AlgRS256 && key.Algorithm != AlgRS384 && key.Algorithm != AlgRS512 {
+			return VerifyKey{}, errors.Errorf("algorithm %q does not fit an RSA key", key.Algorithm)
+		}
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve, key.Algorithm = elliptic.P256(), AlgES256
+		case "P-384":
+			curve, key.Algorithm = elliptic.P384(), AlgES384
+		default:
+			return VerifyKey{}, errors.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return VerifyKey{}, errors.Wrap(err, "decoding x")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return VerifyKey{}, errors.Wrap(err, "decoding y")
+		}
+		pub := ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return VerifyKey{}, errors.New("point is not on the curve")
+		}
+		key.Key = &pub
+
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return VerifyKey{}, errors.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return VerifyKey{}, errors.Wrap(err, "decoding x")
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return VerifyKey{}, errors.New("invalid ed25519 key size")
+		}
+		key = VerifyKey{Algorithm: AlgEdDSA, Key: ed25519.PublicKey(x)}
+
+	default:
+		return VerifyKey{}, errors.Errorf("unsupported key type %q", k.KeyType)
+	}
+
+	if k.Algorithm != "" && k.Algorithm != key.Algorithm {
+		return VerifyKey{}, errors.Errorf("algorithm %q does not fit the key", k.Algorithm)
+	}
+
+	return key, nil
+}
+
+// padded left pads b with zeros to size bytes.
+func padded(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	p := make([]byte, size)
+	copy(p[size-len(b):], b)
+	return p
+}
+
+// RSAPublicKey decodes the RSA public key held by the JWK.
+func (k JWK) RSAPublicKey() (*rsa.PublicKey, error) {
+	if k.KeyType != "RSA" {
+		return nil, errors.Errorf("unsupported key type %q", k.KeyType)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding exponent")
+	}
+
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() > int64(^uint32(0)>>1) || exp.Int64() < 2 {
+		return nil, errors.New("invalid exponent")
+	}
+
+	key := rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exp.Int64()),
+	}
+
+	return &key, nil
+}
+
+// JWKSConfig holds the settings used to construct a JWKS.
+type JWKSConfig struct {
+
+	// URL is the location of the JSON Web Key Set document.
+	URL string
+
+	// RefreshInterval is how often the key set is fetched in the background.
+	RefreshInterval time.Duration
+
+	// MinRefetchInterval is the minimum time between two fetches triggered by
+	// an unrecognized key id. It protects the JWKS endpoint from clients that
+	// send tokens with random key ids.
+	MinRefetchInterval time.Duration
+
+	// Client is the HTTP client used to fetch the key set. The zero value uses
+	// a client with a 10 second timeout.
+	Client *http.Client
+
+	// Log receives the errors of the background refreshes so an endpoint
+	// that stays down is noticed before the cached keys go stale. Nil
+	// discards them.
+	Log *log.Logger
+}
+
+// decodeFunc decodes a document listing public keys into a map of key id to
+// verification key.
+type decodeFunc func(r io.Reader) (map[string]VerifyKey, error)
+
+// JWKS is a caching layer in front of a JWKS endpoint. Its Lookup method
+// satisfies KeyLookupFunc so it can be handed to NewAuthenticator.
+type JWKS struct {
+	cfg    JWKSConfig
+	decode decodeFunc
+
+	mu        sync.RWMutex
+	keys      map[string]VerifyKey
+	lastFetch time.Time
+
+	// fetchMu serializes fetches so concurrent lookups for an unknown kid
+	// result in a single request to the endpoint.
+	fetchMu sync.Mutex
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewJWKS constructs a JWKS and performs the initial fetch of the key set. A
+// goroutine refreshing the keys every RefreshInterval is started and runs
+// until Shutdown is called.
+func NewJWKS(cfg JWKSConfig) (*JWKS, error) {
+	return newKeySet(cfg, decodeJWKS)
+}
+
+// newKeySet constructs a JWKS fetching a key set document understood by the
+// decode function.
+func newKeySet(cfg JWKSConfig, decode decodeFunc) (*JWKS, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("key set url cannot be blank")
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = time.Hour
+	}
+	if cfg.MinRefetchInterval <= 0 {
+		cfg.MinRefetchInterval = time.Minute
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	j := JWKS{
+		cfg:      cfg,
+		decode:   decode,
+		keys:     make(map[string]VerifyKey),
+		shutdown: make(chan struct{}),
+	}
+
+	if err := j.fetch(context.Background()); err != nil {
+		return nil, errors.Wrap(err, "initial key set fetch")
+	}
+
+	j.wg.Add(1)
+	go j.refresh()
+
+	return &j, nil
+}
+
+// Lookup returns the verification key for the specified key id. When the key
+// id is not in the cache the key set is fetched again, at most once every
+// MinRefetchInterval, before giving up.
+func (j *JWKS) Lookup(kid string) (VerifyKey, error) {
+	if key, ok := j.key(kid); ok {
+		return key, nil
+	}
+
+	j.fetchMu.Lock()
+	defer j.fetchMu.Unlock()
+
+	// Another lookup may have fetched the key set while we were waiting.
+	if key, ok := j.key(kid); ok {
+		return key, nil
+	}
+
+	j.mu.RLock()
+	since := time.Since(j.lastFetch)
+	j.mu.RUnlock()
+
+	if since < j.cfg.MinRefetchInterval {
+		return VerifyKey{}, fmt.Errorf("unrecognized key id %q", kid)
+	}
+
+	if err := j.fetchLocked(context.Background()); err != nil {
+		return VerifyKey{}, errors.Wrapf(err, "refetching jwks for key id %q", kid)
+	}
+
+	if key, ok := j.key(kid); ok {
+		return key, nil
+	}
+
+	return VerifyKey{}, fmt.Errorf("unrecognized key id %q", kid)
+}
+
+// Shutdown stops the background refresh goroutine and waits for it to exit.
+func (j *JWKS) Shutdown() {
+	close(j.shutdown)
+	j.wg.Wait()
+}
+
+// key returns the cached verification key for the key id.
+func (j *JWKS) key(kid string) (VerifyKey, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// refresh fetches the key set every RefreshInterval until shutdown. A failed
+// fetch keeps the previously cached keys and is logged.
+func (j *JWKS) refresh() {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(j.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := j.fetch(context.Background()); err != nil && j.cfg.Log != nil {
+				j.cfg.Log.Printf("jwks : Refreshing %s : %v", j.cfg.URL, err)
+			}
+		case <-j.shutdown:
+			return
+		}
+	}
+}
+
+// fetch retrieves the key set and replaces the cached keys.
+func (j *JWKS) fetch(ctx context.Context) error {
+	j.fetchMu.Lock()
+	defer j.fetchMu.Unlock()
+
+	return j.fetchLocked(ctx)
+}
+
+// fetchLocked performs the work of fetch. The caller must hold fetchMu.
+func (j *JWKS) fetchLocked(ctx context.Context) error {
+
+	// Record the attempt even if it fails so a broken endpoint is not hit on
+	// every lookup of an unknown key id.
+	j.mu.Lock()
+	j.lastFetch = time.Now()
+	j.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, j.cfg.URL, nil)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := j.cfg.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "fetching key set")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("fetching key set: unexpected status %d", resp.StatusCode)
+	}
+
+	keys, err := j.decode(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+
+	return nil
+}
+
+// decodeJWKS decodes a JSON Web Key Set. Keys that are not supported signing
+// keys are skipped.
+func decodeJWKS(r io.Reader) (map[string]VerifyKey, error) {
+	var set JWKSet
+	if err := json.NewDecoder(r).Decode(&set); err != nil {
+		return nil, errors.Wrap(err, "decoding jwks")
+	}
+
+	keys := make(map[string]VerifyKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.VerifyKey()
+		if err != nil {
+			continue
+		}
+		keys[k.KeyID] = key
+	}
+
+	return keys, nil
+}
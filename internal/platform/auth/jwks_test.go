@@ -0,0 +1,214 @@
+package auth_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// jwksServer serves a mutable key set and counts the requests it receives.
+// It fails every request while down.
+type jwksServer struct {
+	mu    sync.Mutex
+	set   auth.JWKSet
+	down  bool
+	calls int64
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&s.calls, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(s.set)
+}
+
+func (s *jwksServer) add(kid string, key *rsa.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set.Keys = append(s.set.Keys, auth.NewRSAJWK(kid, "RS256", key))
+}
+
+// TestJWKS validates fetching, caching and refetching keys from a JWKS
+// endpoint.
+func TestJWKS(t *testing.T) {
+	key1, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	js := jwksServer{}
+	js.add("kid-1", &key1.PublicKey)
+
+	srv := httptest.NewServer(&js)
+	defer srv.Close()
+
+	t.Log("Given the need to look up public keys from a JWKS endpoint.")
+	{
+		jwks, err := auth.NewJWKS(auth.JWKSConfig{
+			URL:                srv.URL,
+			RefreshInterval:    time.Hour,
+			MinRefetchInterval: 50 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to construct the JWKS : %s.", tests.Failed, err)
+		}
+		defer jwks.Shutdown()
+		t.Logf("\t%s\tShould be able to construct the JWKS.", tests.Success)
+
+		t.Log("\tWhen looking up a known key id.")
+		{
+			key, err := jwks.Lookup("kid-1")
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to find the key : %s.", tests.Failed, err)
+			}
+			pub, ok := key.Key.(*rsa.PublicKey)
+			if !ok || key.Algorithm != auth.AlgRS256 || pub.N.Cmp(key1.N) != 0 || pub.E != key1.E {
+				t.Fatalf("\t%s\tShould get back the published key.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould get back the published key.", tests.Success)
+
+			if calls := atomic.LoadInt64(&js.calls); calls != 1 {
+				t.Fatalf("\t%s\tShould serve the key from the cache : calls %d.", tests.Failed, calls)
+			}
+			t.Logf("\t%s\tShould serve the key from the cache.", tests.Success)
+		}
+
+		t.Log("\tWhen looking up a key id published after the last fetch.")
+		{
+			js.add("kid-2", &key2.PublicKey)
+
+			if _, err := jwks.Lookup("kid-2"); err == nil {
+				t.Fatalf("\t%s\tShould not refetch inside the rate limit window.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould not refetch inside the rate limit window.", tests.Success)
+
+			time.Sleep(100 * time.Millisecond)
+
+			key, err := jwks.Lookup("kid-2")
+			if err != nil {
+				t.Fatalf("\t%s\tShould refetch and find the new key : %s.", tests.Failed, err)
+			}
+			if pub, ok := key.Key.(*rsa.PublicKey); !ok || pub.N.Cmp(key2.N) != 0 {
+				t.Fatalf("\t%s\tShould get back the new key.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould refetch and find the new key.", tests.Success)
+		}
+
+		t.Log("\tWhen looking up an unknown key id.")
+		{
+			time.Sleep(100 * time.Millisecond)
+			before := atomic.LoadInt64(&js.calls)
+
+			for i := 0; i < 5; i++ {
+				if _, err := jwks.Lookup("unknown"); err == nil {
+					t.Fatalf("\t%s\tShould fail to find the key.", tests.Failed)
+				}
+			}
+			t.Logf("\t%s\tShould fail to find the key.", tests.Success)
+
+			if calls := atomic.LoadInt64(&js.calls) - before; calls != 1 {
+				t.Fatalf("\t%s\tShould refetch at most once : calls %d.", tests.Failed, calls)
+			}
+			t.Logf("\t%s\tShould refetch at most once.", tests.Success)
+		}
+	}
+}
+
+// TestJWKSRefresh validates the key set is refreshed in the background.
+func TestJWKSRefresh(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	js := jwksServer{}
+	srv := httptest.NewServer(&js)
+	defer srv.Close()
+
+	t.Log("Given the need to refresh keys in the background.")
+	{
+		jwks, err := auth.NewJWKS(auth.JWKSConfig{
+			URL:                srv.URL,
+			RefreshInterval:    20 * time.Millisecond,
+			MinRefetchInterval: time.Hour,
+		})
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to construct the JWKS : %s.", tests.Failed, err)
+		}
+		defer jwks.Shutdown()
+
+		js.add("kid-1", &key.PublicKey)
+		time.Sleep(100 * time.Millisecond)
+
+		if _, err := jwks.Lookup("kid-1"); err != nil {
+			t.Fatalf("\t%s\tShould pick up the new key without a lookup refetch : %s.", tests.Failed, err)
+		}
+		t.Logf("\t%s\tShould pick up the new key without a lookup refetch.", tests.Success)
+	}
+}
+
+// TestJWKSRefreshFailure validates failed background refreshes keep the cached
+// keys and are logged.
+func TestJWKSRefreshFailure(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	js := jwksServer{}
+	js.add("kid-1", &key.PublicKey)
+	srv := httptest.NewServer(&js)
+	defer srv.Close()
+
+	t.Log("Given the need to notice a key endpoint that stays down.")
+	{
+		var buf bytes.Buffer
+		jwks, err := auth.NewJWKS(auth.JWKSConfig{
+			URL:                srv.URL,
+			RefreshInterval:    20 * time.Millisecond,
+			MinRefetchInterval: time.Hour,
+			Log:                log.New(&buf, "", 0),
+		})
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to construct the JWKS : %s.", tests.Failed, err)
+		}
+
+		js.mu.Lock()
+		js.down = true
+		js.mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+
+		if _, err := jwks.Lookup("kid-1"); err != nil {
+			t.Fatalf("\t%s\tShould keep the cached keys : %s.", tests.Failed, err)
+		}
+		t.Logf("\t%s\tShould keep the cached keys.", tests.Success)
+
+		// Stop the refreshes before reading what they logged.
+		jwks.Shutdown()
+		if !strings.Contains(buf.String(), "unexpected status 503") {
+			t.Fatalf("\t%s\tShould log the failed refreshes : %q.", tests.Failed, buf.String())
+		}
+		t.Logf("\t%s\tShould log the failed refreshes.", tests.Success)
+	}
+}
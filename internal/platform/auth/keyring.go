@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeyringManifestFile is the name of the file inside a keyring directory that
+// records the state of every key.
+const KeyringManifestFile = "keyring.json"
+
+// These are the states a key in a keyring can be in.
+//
+// * An active key signs new tokens. A keyring has exactly one active key.
+//
+// * A verify-only key no longer signs tokens but tokens it signed are still
+// accepted. Keys move to this state when a new key is rotated in so tokens
+// issued before the rotation stay valid until they expire.
+//
+// * A retired key is no longer trusted at all.
+const (
+	KeyActive     = "active"
+	KeyVerifyOnly = "verify-only"
+	KeyRetired    = "retired"
+)
+
+// KeyringEntry describes a single key in a keyring directory. The key only
+// signs and verifies tokens with its algorithm. A blank algorithm picks the
+// default for the type of the key.
+type KeyringEntry struct {
+	KeyID     string    `json:"kid"`
+	File      string    `json:"file"`
+	Algorithm string    `json:"alg,omitempty"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KeyringManifest is the document stored in KeyringManifestFile.
+type KeyringManifest struct {
+	Keys []KeyringEntry `json:"keys"`
+}
+
+// ReadKeyringManifest reads the manifest of the keyring in dir. A directory
+// without a manifest yields an empty manifest.
+func ReadKeyringManifest(dir string) (KeyringManifest, error) {
+	var m KeyringManifest
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, KeyringManifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return m, errors.Wrap(err, "reading keyring manifest")
+	}
+
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, errors.Wrap(err, "decoding keyring manifest")
+	}
+
+	return m, nil
+}
+
+// WriteKeyringManifest replaces the manifest of the keyring in dir. The file is
+// replaced atomically so a running service never reads a partial manifest.
+func WriteKeyringManifest(dir string, m KeyringManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding keyring manifest")
+	}
+
+	tmp := filepath.Join(dir, KeyringManifestFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "writing keyring manifest")
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, KeyringManifestFile)); err != nil {
+		return errors.Wrap(err, "replacing keyring manifest")
+	}
+
+	return nil
+}
+
+// ringKey is a key loaded into a Keyring.
+type ringKey struct {
+	state string
+	key   SigningKey
+}
+
+// Keyring holds the set of keys used to sign and verify tokens.
+type Keyring struct {
+	dir string
+
+	mu        sync.RWMutex
+	activeKID string
+	keys      map[string]ringKey
+}
+
+// NewKeyring constructs a Keyring holding a single active key used with the
+// algorithm. A blank algorithm picks the default for the type of the key. It
+// is not backed by a directory so Reload is a no-op.
+func NewKeyring(activeKID, algorithm string, privateKey interface{}) (*Keyring, error) {
+	if privateKey == nil {
+		return nil, errors.New("private key cannot be nil")
+	}
+	if activeKID == "" {
+		return nil, errors.New("active kid cannot be blank")
+	}
+
+	key, err := NewSigningKey(algorithm, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	kr := Keyring{
+		activeKID: activeKID,
+		keys: map[string]ringKey{
+			activeKID: {state: KeyActive, key: key},
+		},
+	}
+
+	return &kr, nil
+}
+
+// LoadKeyring constructs a Keyring from the manifest and private keys found
+// in dir.
+func LoadKeyring(dir string) (*Keyring, error) {
+	kr := Keyring{
+		dir: dir,
+	}
+
+	if err := kr.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &kr, nil
+}
+
+// Reload reads the keyring directory again and replaces the loaded keys. On
+// error the previously loaded keys are kept.
+func (kr *Keyring) Reload() error {
+	if kr.dir == "" {
+		return nil
+	}
+
+	m, err := ReadKeyringManifest(kr.dir)
+	if err != nil {
+		return err
+	}
+
+	var activeKID string
+	keys := make(map[string]ringKey, len(m.Keys))
+	for _, e := range m.Keys {
+		switch e.State {
+		case KeyActive:
+			if activeKID != "" {
+				return errors.Errorf("keyring has more than one active key: %q and %q", activeKID, e.KeyID)
+			}
+			activeKID = e.KeyID
+		case KeyVerifyOnly:
+		case KeyRetired:
+
+			// Retired keys are never used so there is no reason to load them.
+			continue
+		default:
+			return errors.Errorf("key %q has invalid state %q", e.KeyID, e.State)
+		}
+
+		contents, err := ioutil.ReadFile(filepath.Join(kr.dir, e.File))
+		if err != nil {
+			return errors.Wrapf(err, "reading key %q", e.KeyID)
+		}
+
+		privateKey, err := ParsePrivateKeyPEM(contents)
+		if err != nil {
+			return errors.Wrapf(err, "parsing key %q", e.KeyID)
+		}
+
+		key, err := NewSigningKey(e.Algorithm, privateKey)
+		if err != nil {
+			return errors.Wrapf(err, "binding key %q", e.KeyID)
+		}
+
+		keys[e.KeyID] = ringKey{state: e.State, key: key}
+	}
+
+	if activeKID == "" {
+		return errors.New("keyring has no active key")
+	}
+
+	kr.mu.Lock()
+	kr.activeKID = activeKID
+	kr.keys = keys
+	kr.mu.Unlock()
+
+	return nil
+}
+
+// Active returns the key id and signing key used to sign new tokens.
+func (kr *Keyring) Active() (string, SigningKey) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.activeKID, kr.keys[kr.activeKID].key
+}
+
+// Lookup returns the verification key for the specified key id as long as
+// the key is not retired. It satisfies KeyLookupFunc.
+func (kr *Keyring) Lookup(kid string) (VerifyKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	k, ok := kr.keys[kid]
+	if !ok {
+		return VerifyKey{}, fmt.Errorf("unrecognized key id %q", kid)
+	}
+
+	return k.key.VerifyKey(), nil
+}
+
+// JWKS returns the public keys of every key that is not retired, ordered by
+// key id. HMAC keys have no public part and are left out.
+func (kr *Keyring) JWKS() JWKSet {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	kids := make([]string, 0, len(kr.keys))
+	for kid := range kr.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKSet{
+		Keys: make([]JWK, 0, len(kids)),
+	}
+	for _, kid := range kids {
+		jwk, err := NewJWK(kid, kr.keys[kid].key.VerifyKey())
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
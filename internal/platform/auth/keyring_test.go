@@ -0,0 +1,111 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// writeKey generates a private key and stores it in dir as kid.pem.
+func writeKey(t *testing.T, dir, kid string) auth.KeyringEntry {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(&block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return auth.KeyringEntry{KeyID: kid, File: kid + ".pem"}
+}
+
+// TestKeyring validates tokens signed by rotated keys stay valid until the key
+// is retired.
+func TestKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := writeKey(t, dir, "old")
+	old.State = auth.KeyActive
+	if err := auth.WriteKeyringManifest(dir, auth.KeyringManifest{Keys: []auth.KeyringEntry{old}}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Given the need to rotate signing keys.")
+	{
+		kr, err := auth.LoadKeyring(dir)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to load the keyring : %s.", tests.Failed, err)
+		}
+		a, err := auth.NewKeyringAuthenticator(kr, kr.Lookup)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to construct an authenticator : %s.", tests.Failed, err)
+		}
+
+		claims := auth.NewClaims("subject", []string{auth.RoleUser}, time.Now(), time.Hour)
+		oldTkn, err := a.GenerateToken(claims)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to sign with the old key : %s.", tests.Failed, err)
+		}
+
+		t.Log("\tWhen a new key is rotated in.")
+		{
+			old.State = auth.KeyVerifyOnly
+			cur := writeKey(t, dir, "new")
+			cur.State = auth.KeyActive
+			if err := auth.WriteKeyringManifest(dir, auth.KeyringManifest{Keys: []auth.KeyringEntry{old, cur}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := kr.Reload(); err != nil {
+				t.Fatalf("\t%s\tShould be able to reload the keyring : %s.", tests.Failed, err)
+			}
+
+			if kid, _ := kr.Active(); kid != "new" {
+				t.Fatalf("\t%s\tShould sign with the new key : got %q.", tests.Failed, kid)
+			}
+			t.Logf("\t%s\tShould sign with the new key.", tests.Success)
+
+			if _, err := a.ParseClaims(oldTkn); err != nil {
+				t.Fatalf("\t%s\tShould accept tokens signed by the verify-only key : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould accept tokens signed by the verify-only key.", tests.Success)
+
+			if n := len(a.JWKS().Keys); n != 2 {
+				t.Fatalf("\t%s\tShould publish both keys : got %d.", tests.Failed, n)
+			}
+			t.Logf("\t%s\tShould publish both keys.", tests.Success)
+
+			old.State = auth.KeyRetired
+			if err := auth.WriteKeyringManifest(dir, auth.KeyringManifest{Keys: []auth.KeyringEntry{old, cur}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := kr.Reload(); err != nil {
+				t.Fatalf("\t%s\tShould be able to reload the keyring : %s.", tests.Failed, err)
+			}
+
+			if _, err := a.ParseClaims(oldTkn); err == nil {
+				t.Fatalf("\t%s\tShould reject tokens signed by a retired key.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould reject tokens signed by a retired key.", tests.Success)
+		}
+	}
+}
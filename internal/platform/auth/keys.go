@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// These are the algorithms tokens can be signed with.
+const (
+	AlgRS256 = "RS256"
+	AlgRS384 = "RS384"
+	AlgRS512 = "RS512"
+	AlgES256 = "ES256"
+	AlgES384 = "ES384"
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256"
+)
+
+// Algorithms lists every supported signing algorithm.
+var Algorithms = []string{AlgRS256, AlgRS384, AlgRS512, AlgES256, AlgES384, AlgEdDSA, AlgHS256}
+
+// asymmetricAlgorithms lists the algorithms whose verification keys can be
+// published. Tokens from other issuers are only accepted with these.
+var asymmetricAlgorithms = []string{AlgRS256, AlgRS384, AlgRS512, AlgES256, AlgES384, AlgEdDSA}
+
+// hmacPEMType is the PEM block type HMAC secrets are stored under.
+const hmacPEMType = "HMAC SECRET"
+
+// minHMACSecret is the minimum size of an HMAC secret in bytes.
+const minHMACSecret = 32
+
+// SigningKey is a private key bound to the one algorithm it signs tokens
+// with. Key is an *rsa.PrivateKey, an *ecdsa.PrivateKey, an
+// ed25519.PrivateKey or the []byte secret of HMAC.
+type SigningKey struct {
+	Algorithm string
+	Key       interface{}
+}
+
+// VerifyKey is a key bound to the one algorithm tokens it verifies may be
+// signed with. Binding the algorithm to the key id rather than trusting the
+// alg header of a token makes algorithm confusion impossible. Key is an
+// *rsa.PublicKey, an *ecdsa.PublicKey, an ed25519.PublicKey or the []byte
+// secret of HMAC.
+type VerifyKey struct {
+	Algorithm string
+	Key       interface{}
+}
+
+// NewSigningKey binds the private key to the algorithm. A blank algorithm
+// picks the default for the type of the key. It will error if the key cannot
+// be used with the algorithm.
+func NewSigningKey(algorithm string, key interface{}) (SigningKey, error) {
+	if algorithm == "" {
+		algorithm = DefaultAlgorithm(key)
+	}
+
+	var ok bool
+	switch algorithm {
+	case AlgRS256, AlgRS384, AlgRS512:
+		_, ok = key.(*rsa.PrivateKey)
+	case AlgES256:
+		k, isEC := key.(*ecdsa.PrivateKey)
+		ok = isEC && k.Curve == elliptic.P256()
+	case AlgES384:
+		k, isEC := key.(*ecdsa.PrivateKey)
+		ok = isEC && k.Curve == elliptic.P384()
+	case AlgEdDSA:
+		_, ok = key.(ed25519.PrivateKey)
+	case AlgHS256:
+		k, isSecret := key.([]byte)
+		if isSecret && len(k) < minHMACSecret {
+			return SigningKey{}, errors.Errorf("hmac secret must be at least %d bytes", minHMACSecret)
+		}
+		ok = isSecret
+	default:
+		return SigningKey{}, errors.Errorf("unknown algorithm %v", algorithm)
+	}
+	if !ok {
+		return SigningKey{}, errors.Errorf("key of type %T cannot be used with %s", key, algorithm)
+	}
+
+	return SigningKey{Algorithm: algorithm, Key: key}, nil
+}
+
+// DefaultAlgorithm returns the algorithm used with a private key when none is
+// configured.
+func DefaultAlgorithm(key interface{}) string {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return AlgRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve == elliptic.P384() {
+			return AlgES384
+		}
+		return AlgES256
+	case ed25519.PrivateKey:
+		return AlgEdDSA
+	case []byte:
+		return AlgHS256
+	}
+	return ""
+}
+
+// VerifyKey returns the key verifying the tokens signed with k. For HMAC it
+// is the secret itself, which must never be published.
+func (k SigningKey) VerifyKey() VerifyKey {
+	var pub interface{}
+	switch key := k.Key.(type) {
+	case *rsa.PrivateKey:
+		pub = &key.PublicKey
+	case *ecdsa.PrivateKey:
+		pub = &key.PublicKey
+	case ed25519.PrivateKey:
+		pub = key.Public()
+	case []byte:
+		pub = key
+	}
+
+	return VerifyKey{Algorithm: k.Algorithm, Key: pub}
+}
+
+// GenerateKey creates a new private key for the algorithm.
+func GenerateKey(algorithm string) (interface{}, error) {
+	switch algorithm {
+	case AlgRS256, AlgRS384, AlgRS512:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgES384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case AlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case AlgHS256:
+		key := make([]byte, minHMACSecret)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	return nil, errors.Errorf("unknown algorithm %v", algorithm)
+}
+
+// EncodePrivateKeyPEM encodes a private key as PEM. RSA and ECDSA keys use
+// their traditional encodings, Ed25519 keys PKCS #8 and HMAC secrets are
+// stored as is.
+func EncodePrivateKeyPEM(key interface{}) ([]byte, error) {
+	var block pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, errors.Wrap(err, "encoding ecdsa key")
+		}
+		block = pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, errors.Wrap(err, "encoding ed25519 key")
+		}
+		block = pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	case []byte:
+		block = pem.Block{Type: hmacPEMType, Bytes: k}
+	default:
+		return nil, errors.Errorf("unsupported key type %T", key)
+	}
+
+	return pem.EncodeToMemory(&block), nil
+}
+
+// ParsePrivateKeyPEM decodes a private key encoded by EncodePrivateKeyPEM.
+// PKCS #8 encoded RSA and ECDSA keys are accepted too.
+func ParsePrivateKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case hmacPEMType:
+		return block.Bytes, nil
+	}
+
+	return nil, errors.Errorf("unsupported PEM block type %q", block.Type)
+}
+
+// NewSimpleKeyLookupFunc is a simple implementation of KeyFunc that only ever
+// supports one key. This is easy for development but in production should be
+// replaced with a caching layer that calls a JWKS endpoint.
+func NewSimpleKeyLookupFunc(activeKID string, key VerifyKey) KeyLookupFunc {
+	f := func(kid string) (VerifyKey, error) {
+		if activeKID != kid {
+			return VerifyKey{}, fmt.Errorf("unrecognized key id %q", kid)
+		}
+		return key, nil
+	}
+
+	return f
+}
+
+// bindKey returns a jwt.Keyfunc looking up the key of a token by its key id.
+// The token is rejected unless it is signed with the algorithm the key is
+// bound to.
+func bindKey(lookup KeyLookupFunc) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		kid, ok := t.Header["kid"]
+		if !ok {
+			return nil, errors.New("missing key id (kid) in token header")
+		}
+		userKID, ok := kid.(string)
+		if !ok {
+			return nil, errors.New("user token key id (kid) must be string")
+		}
+
+		key, err := lookup(userKID)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, errors.Errorf("key %q cannot verify %s tokens", userKID, t.Method.Alg())
+		}
+
+		return key.Key, nil
+	}
+}
@@ -0,0 +1,141 @@
+package auth_test
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// TestAlgorithms validates tokens can be signed and verified with every
+// supported algorithm and only with the algorithm bound to their key.
+func TestAlgorithms(t *testing.T) {
+	t.Log("Given the need to sign tokens with different algorithms.")
+	{
+		for _, alg := range auth.Algorithms {
+			t.Logf("\tWhen signing with %s.", alg)
+			{
+				key, err := auth.GenerateKey(alg)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to generate a key : %s.", tests.Failed, err)
+				}
+
+				data, err := auth.EncodePrivateKeyPEM(key)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to encode the key : %s.", tests.Failed, err)
+				}
+				key, err = auth.ParsePrivateKeyPEM(data)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to decode the key : %s.", tests.Failed, err)
+				}
+				t.Logf("\t%s\tShould be able to store the key as PEM.", tests.Success)
+
+				kr, err := auth.NewKeyring("kid-1", alg, key)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to construct a keyring : %s.", tests.Failed, err)
+				}
+
+				a, err := auth.NewKeyringAuthenticator(kr, kr.Lookup)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to construct an authenticator : %s.", tests.Failed, err)
+				}
+
+				claims := auth.NewClaims("subject", []string{auth.RoleUser}, time.Now(), time.Hour)
+				tkn, err := a.GenerateToken(claims)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to sign a token : %s.", tests.Failed, err)
+				}
+				if _, err := a.ParseClaims(tkn); err != nil {
+					t.Fatalf("\t%s\tShould be able to verify the token : %s.", tests.Failed, err)
+				}
+				t.Logf("\t%s\tShould be able to sign and verify a token.", tests.Success)
+
+				published := len(a.JWKS().Keys) == 1
+				if published == (alg == auth.AlgHS256) {
+					t.Fatalf("\t%s\tShould publish the key unless it is a secret.", tests.Failed)
+				}
+				if published {
+					vk, err := a.JWKS().Keys[0].VerifyKey()
+					if err != nil || vk.Algorithm != alg {
+						t.Fatalf("\t%s\tShould publish a key bound to %s : %v.", tests.Failed, alg, err)
+					}
+				}
+				t.Logf("\t%s\tShould publish the key unless it is a secret.", tests.Success)
+			}
+		}
+
+		t.Log("\tWhen a key does not fit the algorithm.")
+		{
+			key, err := auth.GenerateKey(auth.AlgES256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, alg := range []string{auth.AlgRS256, auth.AlgES384, auth.AlgEdDSA, auth.AlgHS256} {
+				if _, err := auth.NewSigningKey(alg, key); err == nil {
+					t.Fatalf("\t%s\tShould refuse to bind a P-256 key to %s.", tests.Failed, alg)
+				}
+			}
+			t.Logf("\t%s\tShould refuse to bind the key.", tests.Success)
+		}
+	}
+}
+
+// TestAlgorithmConfusion validates a token cannot choose an algorithm other
+// than the one bound to its key id.
+func TestAlgorithmConfusion(t *testing.T) {
+	key, err := auth.GenerateKey(auth.AlgRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr, err := auth.NewKeyring("kid-1", auth.AlgRS256, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := auth.NewKeyringAuthenticator(kr, kr.Lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := auth.NewClaims("subject", []string{auth.RoleSuperAdmin}, time.Now(), time.Hour)
+
+	t.Log("Given the need to bind algorithms to keys.")
+	{
+		t.Log("\tWhen a token is signed with HS256 using the public key as secret.")
+		{
+			der, err := x509.MarshalPKIXPublicKey(&key.(*rsa.PrivateKey).PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tkn := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+			tkn.Header["kid"] = "kid-1"
+			str, err := tkn.SignedString(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := a.ParseClaims(str); err == nil {
+				t.Fatalf("\t%s\tShould reject the token.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould reject the token.", tests.Success)
+		}
+
+		t.Log("\tWhen a token claims another RSA algorithm.")
+		{
+			tkn := jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
+			tkn.Header["kid"] = "kid-1"
+			str, err := tkn.SignedString(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := a.ParseClaims(str); err == nil {
+				t.Fatalf("\t%s\tShould reject the token.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould reject the token.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// OIDCConfig holds the settings used to construct an OIDC identity provider.
+type OIDCConfig struct {
+
+	// Name is stored as the provider of users linked to this provider.
+	Name string
+
+	// Issuer must match the iss claim of every ID token.
+	Issuer string
+
+	// Audience must be one of the aud claims of every ID token. It is usually
+	// the client id registered with the issuer.
+	Audience string
+
+	// JWKSURL is where the issuer publishes its signing keys. When blank it is
+	// discovered from the issuer's openid-configuration document.
+	JWKSURL string
+
+	// JWKSRefresh is how often the signing keys are refreshed.
+	JWKSRefresh time.Duration
+
+	// Log receives the errors of the background refreshes. Nil discards them.
+	Log *log.Logger
+}
+
+// OIDC is an IdentityProvider verifying ID tokens issued by any OpenID Connect
+// compliant issuer.
+type OIDC struct {
+	cfg    OIDCConfig
+	jwks   *JWKS
+	parser *jwt.Parser
+}
+
+// NewOIDC constructs an OIDC identity provider. Call Shutdown to stop the
+// background refresh of the issuer's keys.
+func NewOIDC(ctx context.Context, cfg OIDCConfig) (*OIDC, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("oidc provider name cannot be blank")
+	}
+	if cfg.Issuer == "" {
+		return nil, errors.New("oidc issuer cannot be blank")
+	}
+	if cfg.Audience == "" {
+		return nil, errors.New("oidc audience cannot be blank")
+	}
+
+	if cfg.JWKSURL == "" {
+		u, err := discoverJWKSURL(ctx, cfg.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		cfg.JWKSURL = u
+	}
+
+	jwks, err := NewJWKS(JWKSConfig{
+		URL:             cfg.JWKSURL,
+		RefreshInterval: cfg.JWKSRefresh,
+		Log:             cfg.Log,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing oidc jwks")
+	}
+
+	o := OIDC{
+		cfg:  cfg,
+		jwks: jwks,
+		parser: &jwt.Parser{
+			ValidMethods: asymmetricAlgorithms,
+		},
+	}
+
+	return &o, nil
+}
+
+// Shutdown stops the background refresh of the issuer's keys.
+func (o *OIDC) Shutdown() {
+	o.jwks.Shutdown()
+}
+
+// Name implements IdentityProvider.
+func (o *OIDC) Name() string {
+	return o.cfg.Name
+}
+
+// Verify implements IdentityProvider. The credential is an ID token whose
+// signature, issuer, audience and expiry are checked.
+func (o *OIDC) Verify(ctx context.Context, credential string) (Identity, error) {
+	keyFunc := bindKey(o.jwks.Lookup)
+
+	var claims jwt.MapClaims
+	if _, err := o.parser.ParseWithClaims(credential, &claims, keyFunc); err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidCredential, err.Error())
+	}
+
+	if !claims.VerifyIssuer(o.cfg.Issuer, true) {
+		return Identity{}, errors.Wrap(ErrInvalidCredential, "unexpected issuer")
+	}
+	if !hasAudience(claims["aud"], o.cfg.Audience) {
+		return Identity{}, errors.Wrap(ErrInvalidCredential, "unexpected audience")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return Identity{}, errors.Wrap(ErrInvalidCredential, "missing expiry")
+	}
+
+	id := identityFromClaims(o.cfg.Name, claims)
+	if id.Subject == "" {
+		return Identity{}, errors.Wrap(ErrInvalidCredential, "missing subject")
+	}
+
+	return id, nil
+}
+
+// hasAudience reports whether the aud claim, a string or a list of strings,
+// contains the audience.
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// discoverJWKSURL reads the jwks_uri from the issuer's openid-configuration.
+func discoverJWKSURL(ctx context.Context, issuer string) (string, error) {
+	u := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "creating discovery request")
+	}
+	req = req.WithContext(ctx)
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "fetching openid configuration")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("fetching openid configuration: unexpected status %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", errors.Wrap(err, "decoding openid configuration")
+	}
+	if doc.JWKSURI == "" {
+		return "", errors.New("openid configuration has no jwks_uri")
+	}
+
+	return doc.JWKSURI, nil
+}
@@ -0,0 +1,89 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// TestOIDC validates ID tokens are verified against the issuer's keys,
+// issuer and audience.
+func TestOIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	js := jwksServer{}
+	js.add("kid-1", &key.PublicKey)
+	srv := httptest.NewServer(&js)
+	defer srv.Close()
+
+	const issuer = "https://issuer.example.com"
+	sign := func(claims jwt.MapClaims) string {
+		tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		tkn.Header["kid"] = "kid-1"
+		str, err := tkn.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return str
+	}
+
+	t.Log("Given the need to verify OIDC ID tokens.")
+	{
+		p, err := auth.NewOIDC(context.Background(), auth.OIDCConfig{
+			Name:     "oidc",
+			Issuer:   issuer,
+			Audience: "client-1",
+			JWKSURL:  srv.URL,
+		})
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to construct the provider : %s.", tests.Failed, err)
+		}
+		defer p.Shutdown()
+
+		exp := time.Now().Add(time.Hour).Unix()
+
+		t.Log("\tWhen the ID token is valid.")
+		{
+			id, err := p.Verify(context.Background(), sign(jwt.MapClaims{
+				"iss":   issuer,
+				"aud":   []string{"client-1"},
+				"sub":   "subject-1",
+				"email": "anna@example.com",
+				"exp":   exp,
+			}))
+			if err != nil {
+				t.Fatalf("\t%s\tShould accept the token : %s.", tests.Failed, err)
+			}
+			if id.Subject != "subject-1" || id.Email != "anna@example.com" || id.Provider != "oidc" {
+				t.Fatalf("\t%s\tShould get back the asserted identity : %+v.", tests.Failed, id)
+			}
+			t.Logf("\t%s\tShould get back the asserted identity.", tests.Success)
+		}
+
+		t.Log("\tWhen the ID token is not meant for us.")
+		{
+			bad := []jwt.MapClaims{
+				{"iss": "https://other.example.com", "aud": "client-1", "sub": "s", "exp": exp},
+				{"iss": issuer, "aud": "client-2", "sub": "s", "exp": exp},
+				{"iss": issuer, "aud": "client-1", "sub": "s", "exp": time.Now().Add(-time.Hour).Unix()},
+			}
+			for i, c := range bad {
+				if _, err := p.Verify(context.Background(), sign(c)); errors.Cause(err) != auth.ErrInvalidCredential {
+					t.Fatalf("\t%s\tShould reject token %d : %v.", tests.Failed, i, err)
+				}
+			}
+			t.Logf("\t%s\tShould reject tokens with the wrong issuer, audience or expiry.", tests.Success)
+		}
+	}
+}
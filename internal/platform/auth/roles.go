@@ -2,18 +2,54 @@ package auth
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
-// These are the expected values for Claims.Roles.
+// These are the expected values for Claims.Roles. RoleSuperAdmin is a
+// platform level role allowed to work across every account.
 const (
-	RoleAdmin = "ADMIN"
-	RoleUser  = "USER"
+	RoleSuperAdmin = "SUPERADMIN"
+	RoleAdmin      = "ADMIN"
+	RoleUser       = "USER"
 )
 
+// These are the permissions a role can grant.
+const (
+	PermUsersRead     = "users:read"
+	PermUsersWrite    = "users:write"
+	PermAccountsRead  = "accounts:read"
+	PermAccountsWrite = "accounts:write"
+	PermRolesRead     = "roles:read"
+	PermRolesWrite    = "roles:write"
+	PermAPIKeysRead   = "apikeys:read"
+	PermAPIKeysWrite  = "apikeys:write"
+)
+
+// Permissions lists every permission a role can grant.
+var Permissions = []string{
+	PermUsersRead,
+	PermUsersWrite,
+	PermAccountsRead,
+	PermAccountsWrite,
+	PermRolesRead,
+	PermRolesWrite,
+	PermAPIKeysRead,
+	PermAPIKeysWrite,
+}
+
+// builtinPermissions are the permissions of the system roles. They are used
+// for tokens issued before permissions were part of the claims.
+var builtinPermissions = map[string][]string{
+	RoleSuperAdmin: Permissions,
+	RoleAdmin:      Permissions,
+	RoleUser:       {PermAccountsRead},
+}
+
 // ctxKey represents the type of value for the context key.
 type ctxKey int
 
@@ -22,17 +58,41 @@ const Key ctxKey = 1
 
 // Claims represents the authorization claims transmitted via a JWT.
 type Claims struct {
-	Roles []string `json:"roles"`
+	AccountID   string   `json:"account_id,omitempty"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions,omitempty"`
+	SessionID   string   `json:"sid,omitempty"`
+	Actor       *Actor   `json:"act,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+
+	// IssuedAtMicros refines iat to the microsecond so a token issued in the
+	// same second as the revocation of every token of its user is told apart
+	// from those revoked.
+	IssuedAtMicros int64 `json:"iat_us,omitempty"`
+
+	// APIKey is true for the claims an API key was exchanged for. It is never
+	// part of a token.
+	APIKey bool `json:"-"`
+
 	jwt.StandardClaims
 }
 
+// Actor identifies who is acting on behalf of the subject of a token issued
+// for impersonation, as the act claim of RFC 8693.
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
 // NewClaims constructs a Claims value for the identified user. The Claims
-// expire within a specified duration of the provided time. Additional fields
-// of the Claims can be set after calling NewClaims is desired.
+// expire within a specified duration of the provided time and carry a unique
+// token id (jti) so the token can be revoked. Additional fields of the Claims
+// can be set after calling NewClaims is desired.
 func NewClaims(subject string, roles []string, now time.Time, expires time.Duration) Claims {
 	c := Claims{
-		Roles: roles,
+		Roles:          roles,
+		IssuedAtMicros: now.UnixNano() / int64(time.Microsecond),
 		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
 			Subject:   subject,
 			IssuedAt:  now.Unix(),
 			ExpiresAt: now.Add(expires).Unix(),
@@ -45,9 +105,7 @@ func NewClaims(subject string, roles []string, now time.Time, expires time.Durat
 // Valid is called during the parsing of a token.
 func (c Claims) Valid() error {
 	for _, r := range c.Roles {
-		switch r {
-		case RoleAdmin, RoleUser: // Role is valid.
-		default:
+		if r == "" {
 			return fmt.Errorf("invalid role %q", r)
 		}
 	}
@@ -57,6 +115,51 @@ func (c Claims) Valid() error {
 	return nil
 }
 
+// Issued returns when the claims were issued. Tokens issued before the time
+// was refined to the microsecond fall back on the second of iat.
+func (c Claims) Issued() time.Time {
+	if c.IssuedAtMicros != 0 {
+		return time.Unix(0, c.IssuedAtMicros*int64(time.Microsecond))
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// Impersonated returns true if the claims were issued to someone acting as
+// their subject.
+func (c Claims) Impersonated() bool {
+	return c.Actor != nil
+}
+
+// Scopes returns the scopes the claims are narrowed to. Scopes are named after
+// permissions and carried in the scope claim as a space separated list, as in
+// RFC 8693. Claims without scopes are not narrowed.
+func (c Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope returns true if the claims are not narrowed or are narrowed to
+// every one of the provided scopes.
+func (c Claims) HasScope(scopes ...string) bool {
+	granted := c.Scopes()
+	if len(granted) == 0 {
+		return true
+	}
+
+	for _, want := range scopes {
+		var ok bool
+		for _, has := range granted {
+			if has == want {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // HasRole returns true if the claims has at least one of the provided roles.
 func (c Claims) HasRole(roles ...string) bool {
 	for _, has := range c.Roles {
@@ -68,3 +171,38 @@ func (c Claims) HasRole(roles ...string) bool {
 	}
 	return false
 }
+
+// InAccount returns true if the claims give access to the data of the
+// account. Superadmins have access to every account.
+func (c Claims) InAccount(accountID string) bool {
+	if c.HasRole(RoleSuperAdmin) {
+		return true
+	}
+	return c.AccountID != "" && c.AccountID == accountID
+}
+
+// HasPermission returns true if the claims grant every one of the provided
+// permissions. Claims without permissions fall back on the permissions of the
+// system roles they hold.
+func (c Claims) HasPermission(perms ...string) bool {
+	granted := c.Permissions
+	if granted == nil {
+		for _, r := range c.Roles {
+			granted = append(granted, builtinPermissions[r]...)
+		}
+	}
+
+	for _, want := range perms {
+		var ok bool
+		for _, has := range granted {
+			if has == want {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
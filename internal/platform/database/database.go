@@ -6,6 +6,7 @@ import (
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // The database driver in use.
+	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
 )
 
@@ -57,4 +58,31 @@ func StatusCheck(ctx context.Context, db *sqlx.DB) error {
 	const q = `SELECT true`
 	var tmp bool
 	return db.QueryRowContext(ctx, q).Scan(&tmp)
-}
\ No newline at end of file
+}
+
+// WithAdvisoryLock runs f while holding the Postgres advisory lock identified
+// by the key, such as to keep a job from running on several instances at
+// once. If another session holds the lock f is not run and false is returned.
+func WithAdvisoryLock(ctx context.Context, db *sqlx.DB, key int64, f func() error) (bool, error) {
+	ctx, span := trace.StartSpan(ctx, "platform.DB.WithAdvisoryLock")
+	defer span.End()
+
+	// Advisory locks belong to the session so the lock is taken and released
+	// on the same connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "getting connection")
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked); err != nil {
+		return false, errors.Wrap(err, "taking advisory lock")
+	}
+	if !locked {
+		return false, nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+
+	return true, f()
+}
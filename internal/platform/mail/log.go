@@ -0,0 +1,33 @@
+package mail
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Log is a Mailer writing every message to a writer instead of delivering it,
+// such as a file or the service log. It is meant for development and tests.
+type Log struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLog constructs a Log mailer writing to w.
+func NewLog(w io.Writer) *Log {
+	return &Log{w: w}
+}
+
+// Send implements Mailer.
+func (l *Log) Send(ctx context.Context, m Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := write(l.w, "", m); err != nil {
+		return errors.Wrapf(err, "writing mail to %s", m.To)
+	}
+
+	return nil
+}
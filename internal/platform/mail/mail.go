@@ -0,0 +1,37 @@
+// Package mail sends email through a pluggable Mailer. SMTP delivers the
+// messages, Log writes them out for development and tests.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Message is an email with a plain text body.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// write formats the message as an RFC 5322 document.
+func write(w io.Writer, from string, m Message) error {
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(&b, "\r\n%s\r\n", m.Body)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,67 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/smtp"
+
+	"github.com/pkg/errors"
+)
+
+// SMTPConfig holds the settings used to construct an SMTP mailer.
+type SMTPConfig struct {
+
+	// Addr is the host:port of the SMTP server.
+	Addr string
+
+	// From is the sender of every message.
+	From string
+
+	// User and Password authenticate with the server using PLAIN auth. A
+	// blank user disables authentication.
+	User     string
+	Password string
+}
+
+// SMTP is a Mailer delivering messages to an SMTP server.
+type SMTP struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTP constructs an SMTP mailer.
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing smtp address")
+	}
+	if cfg.From == "" {
+		return nil, errors.New("smtp sender cannot be blank")
+	}
+
+	s := SMTP{
+		addr: cfg.Addr,
+		from: cfg.From,
+	}
+	if cfg.User != "" {
+		s.auth = smtp.PlainAuth("", cfg.User, cfg.Password, host)
+	}
+
+	return &s, nil
+}
+
+// Send implements Mailer.
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	var b bytes.Buffer
+	if err := write(&b, s.from, m); err != nil {
+		return err
+	}
+
+	if err := smtp.SendMail(s.addr, s.auth, s.from, []string{m.To}, b.Bytes()); err != nil {
+		return errors.Wrapf(err, "sending mail to %s", m.To)
+	}
+
+	return nil
+}
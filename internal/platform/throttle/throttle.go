@@ -0,0 +1,143 @@
+// Package throttle slows down and locks out clients repeatedly failing to
+// authenticate. Failures are tracked in memory so every instance of the
+// service keeps its own count.
+package throttle
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/web"
+)
+
+// ErrTooManyAttempts is returned to clients that have to wait before trying
+// again.
+var ErrTooManyAttempts = errors.New("Too many failed attempts, try again later")
+
+// Config holds the settings of a Throttle.
+type Config struct {
+
+	// BackoffAfter is the number of failures after which every further
+	// attempt has to wait, twice as long after each failure.
+	BackoffAfter int
+
+	// LockoutAfter is the number of failures after which attempts are refused
+	// for the whole Lockout.
+	LockoutAfter int
+
+	// BaseDelay is the wait after the first failure past BackoffAfter.
+	BaseDelay time.Duration
+
+	// Lockout is how long attempts are refused once locked out. Failures are
+	// forgotten once there has been none for this long.
+	Lockout time.Duration
+}
+
+// entry tracks the failures of a key.
+type entry struct {
+	failures int
+	last     time.Time
+	until    time.Time
+}
+
+// Throttle tracks failed attempts by key, such as an email or an IP address.
+type Throttle struct {
+	cfg Config
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+// New constructs a Throttle.
+func New(cfg Config) *Throttle {
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = time.Second
+	}
+	if cfg.Lockout <= 0 {
+		cfg.Lockout = 15 * time.Minute
+	}
+
+	return &Throttle{
+		cfg:     cfg,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Wait returns how long the key has to wait before its next attempt. Zero
+// means the attempt may proceed.
+func (t *Throttle) Wait(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok || !now.Before(e.until) {
+		return 0
+	}
+	return e.until.Sub(now)
+}
+
+// Fail records a failed attempt for the key. It returns how long the key has
+// to wait before its next attempt and whether it is now locked out.
+func (t *Throttle) Fail(key string, now time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	e, ok := t.entries[key]
+	if !ok || now.Sub(e.last) > t.cfg.Lockout {
+		e = &entry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.last = now
+
+	var wait time.Duration
+	locked := t.cfg.LockoutAfter > 0 && e.failures >= t.cfg.LockoutAfter
+	switch {
+	case locked:
+		wait = t.cfg.Lockout
+	case t.cfg.BackoffAfter > 0 && e.failures >= t.cfg.BackoffAfter:
+		exp := float64(e.failures - t.cfg.BackoffAfter)
+		wait = time.Duration(math.Min(float64(t.cfg.BaseDelay)*math.Pow(2, exp), float64(t.cfg.Lockout)))
+	}
+	e.until = now.Add(wait)
+
+	return wait, locked
+}
+
+// Reset forgets the failures of the key, such as after a successful attempt.
+func (t *Throttle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, key)
+}
+
+// sweep drops the entries whose failures are forgotten. It runs at most once
+// per Lockout. The caller must hold mu.
+func (t *Throttle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.cfg.Lockout {
+		return
+	}
+	t.lastSweep = now
+
+	for key, e := range t.entries {
+		if now.Sub(e.last) > t.cfg.Lockout && !now.Before(e.until) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// Reject sets the Retry-After header and returns the error responding to a
+// throttled request with 429 Too Many Requests.
+func Reject(w http.ResponseWriter, wait time.Duration) error {
+	secs := int64(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	return web.NewRequestError(ErrTooManyAttempts, http.StatusTooManyRequests)
+}
@@ -0,0 +1,71 @@
+package throttle_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sankarvj/seedgo/internal/platform/throttle"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// TestThrottle validates failures back off exponentially, lock the key out
+// and are forgotten.
+func TestThrottle(t *testing.T) {
+	th := throttle.New(throttle.Config{
+		BackoffAfter: 2,
+		LockoutAfter: 5,
+		BaseDelay:    time.Second,
+		Lockout:      time.Minute,
+	})
+	now := time.Date(2019, time.November, 20, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Given the need to throttle failed attempts.")
+	{
+		t.Log("\tWhen a key keeps failing.")
+		{
+			want := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, time.Minute}
+			for i, w := range want {
+				wait, locked := th.Fail("anna", now)
+				if wait != w || locked != (i == 4) {
+					t.Fatalf("\t%s\tShould wait %s after failure %d : got %s locked %v.", tests.Failed, w, i+1, wait, locked)
+				}
+				t.Logf("\t%s\tShould wait %s after failure %d.", tests.Success, w, i+1)
+			}
+
+			if wait := th.Wait("anna", now.Add(30*time.Second)); wait != 30*time.Second {
+				t.Fatalf("\t%s\tShould still be locked out : %s.", tests.Failed, wait)
+			}
+			t.Logf("\t%s\tShould still be locked out.", tests.Success)
+
+			if wait := th.Wait("bill", now); wait != 0 {
+				t.Fatalf("\t%s\tShould not throttle other keys : %s.", tests.Failed, wait)
+			}
+			t.Logf("\t%s\tShould not throttle other keys.", tests.Success)
+		}
+
+		t.Log("\tWhen the failures are old or the key succeeds.")
+		{
+			if wait, _ := th.Fail("anna", now.Add(3*time.Minute)); wait != 0 {
+				t.Fatalf("\t%s\tShould forget old failures : %s.", tests.Failed, wait)
+			}
+			t.Logf("\t%s\tShould forget old failures.", tests.Success)
+
+			th.Fail("anna", now.Add(3*time.Minute))
+			th.Reset("anna")
+			if wait := th.Wait("anna", now.Add(3*time.Minute)); wait != 0 {
+				t.Fatalf("\t%s\tShould forget failures on reset : %s.", tests.Failed, wait)
+			}
+			t.Logf("\t%s\tShould forget failures on reset.", tests.Success)
+		}
+
+		t.Log("\tWhen a request is rejected.")
+		{
+			w := httptest.NewRecorder()
+			if err := throttle.Reject(w, 1500*time.Millisecond); err == nil || w.Header().Get("Retry-After") != "2" {
+				t.Fatalf("\t%s\tShould set Retry-After in whole seconds : %q.", tests.Failed, w.Header().Get("Retry-After"))
+			}
+			t.Logf("\t%s\tShould set Retry-After in whole seconds.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sankarvj/seedgo/internal/platform/totp"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// TestCode validates codes against the SHA1 test vectors of RFC 6238
+// appendix B, truncated to six digits.
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	t.Log("Given the need to generate TOTP codes.")
+	{
+		for _, v := range vectors {
+			t.Logf("\tWhen the time is %d.", v.unix)
+			{
+				code, err := totp.Code(secret, totp.Step(time.Unix(v.unix, 0)))
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to generate the code : %s.", tests.Failed, err)
+				}
+				if code != v.code {
+					t.Fatalf("\t%s\tShould generate %s : got %s.", tests.Failed, v.code, code)
+				}
+				t.Logf("\t%s\tShould generate %s.", tests.Success, v.code)
+			}
+		}
+	}
+}
+
+// TestValidate validates codes are accepted within the allowed drift only.
+func TestValidate(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	t.Log("Given the need to validate TOTP codes.")
+	{
+		prev, _ := totp.Code(secret, totp.Step(now)-1)
+		if step, ok := totp.Validate(secret, prev, now, 1); !ok || step != totp.Step(now)-1 {
+			t.Fatalf("\t%s\tShould accept the code of the previous step.", tests.Failed)
+		}
+		t.Logf("\t%s\tShould accept the code of the previous step.", tests.Success)
+
+		old, _ := totp.Code(secret, totp.Step(now)-3)
+		if _, ok := totp.Validate(secret, old, now, 1); ok {
+			t.Fatalf("\t%s\tShould reject a code outside the allowed drift.", tests.Failed)
+		}
+		t.Logf("\t%s\tShould reject a code outside the allowed drift.", tests.Success)
+
+		uri := totp.URI("Seed", "anna@example.com", secret)
+		if !strings.HasPrefix(uri, "otpauth://totp/Seed:anna@example.com?") || !strings.Contains(uri, "secret="+secret) {
+			t.Fatalf("\t%s\tShould build the provisioning uri : %s.", tests.Failed, uri)
+		}
+		t.Logf("\t%s\tShould build the provisioning uri.", tests.Success)
+	}
+}
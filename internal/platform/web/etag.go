@@ -0,0 +1,34 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ETag formats the version of a resource as a strong entity tag.
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// IfMatch returns the version the If-Match header of the request requires
+// the resource to be at. It returns 0 when the header is missing or is "*".
+// Weak tags and lists of tags are rejected as they cannot name one version.
+func IfMatch(r *http.Request) (int64, error) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" || h == "*" {
+		return 0, nil
+	}
+
+	if len(h) < 3 || h[0] != '"' || h[len(h)-1] != '"' {
+		return 0, NewRequestError(errors.New("If-Match must hold a single strong entity tag"), http.StatusBadRequest)
+	}
+	version, err := strconv.ParseInt(h[1:len(h)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, NewRequestError(errors.New("If-Match must hold a single strong entity tag"), http.StatusBadRequest)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,56 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sankarvj/seedgo/internal/platform/web"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// TestIfMatch validates the If-Match header is read as the version a resource
+// must be at.
+func TestIfMatch(t *testing.T) {
+	tt := []struct {
+		name    string
+		header  string
+		version int64
+		status  int
+	}{
+		{"a missing header", "", 0, 0},
+		{"any version", "*", 0, 0},
+		{"a strong tag", web.ETag(7), 7, 0},
+		{"a weak tag", `W/"7"`, 0, http.StatusBadRequest},
+		{"a list of tags", `"7", "8"`, 0, http.StatusBadRequest},
+		{"an unquoted version", "7", 0, http.StatusBadRequest},
+		{"a version that is not a number", `"seven"`, 0, http.StatusBadRequest},
+		{"a version that is not positive", `"0"`, 0, http.StatusBadRequest},
+	}
+
+	t.Log("Given the need to read the version required by If-Match.")
+	{
+		for _, tc := range tt {
+			t.Logf("\tWhen the request carries %s.", tc.name)
+			{
+				r := httptest.NewRequest("PUT", "/v1/users/x", nil)
+				if tc.header != "" {
+					r.Header.Set("If-Match", tc.header)
+				}
+
+				version, err := web.IfMatch(r)
+				if tc.status != 0 {
+					if status(err) != tc.status {
+						t.Fatalf("\t%s\tShould receive a status code of %d : %v.", tests.Failed, tc.status, err)
+					}
+					t.Logf("\t%s\tShould receive a status code of %d.", tests.Success, tc.status)
+					continue
+				}
+				if err != nil || version != tc.version {
+					t.Fatalf("\t%s\tShould require version %d : got %d, %v.", tests.Failed, tc.version, version, err)
+				}
+				t.Logf("\t%s\tShould require version %d.", tests.Success, tc.version)
+			}
+		}
+	}
+}
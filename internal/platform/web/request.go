@@ -1,9 +1,12 @@
 package web
 
 import (
+	"bytes"
 	"encoding/json"
+	"mime"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
 
 	en "github.com/go-playground/locales/en"
@@ -32,9 +35,13 @@ func init() {
 	lang, _ := translator.GetTranslator("en")
 	en_translations.RegisterDefaultTranslations(validate, lang)
 
-	// Use JSON tag names for errors instead of Go struct names.
+	// Use JSON tag names for errors instead of Go struct names. Values bound
+	// from the query string use their query tag names.
 	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
 		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		if name == "" {
+			name = fld.Tag.Get("query")
+		}
 		if name == "-" {
 			return ""
 		}
@@ -53,6 +60,165 @@ func Decode(r *http.Request, val interface{}) error {
 		return NewRequestError(err, http.StatusBadRequest)
 	}
 
+	return check(val)
+}
+
+// MergePatchType is the media type of JSON merge patches.
+const MergePatchType = "application/merge-patch+json"
+
+// DecodeMergePatch reads a JSON merge patch (RFC 7396) from the body of an
+// HTTP request and applies it to the provided value, which holds the document
+// being patched. Members patched to null are removed from the document and
+// end up as zero values. Members unknown to the document are rejected.
+//
+// The patched value is then checked for validation tags.
+func DecodeMergePatch(r *http.Request, val interface{}) error {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != MergePatchType {
+		return NewRequestError(errors.Errorf("Content-Type must be %s", MergePatchType), http.StatusUnsupportedMediaType)
+	}
+
+	var patch interface{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return NewRequestError(err, http.StatusBadRequest)
+	}
+
+	data, err := json.Marshal(val)
+	if err != nil {
+		return errors.Wrap(err, "encoding document")
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return errors.Wrap(err, "decoding document")
+	}
+
+	data, err = json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return errors.Wrap(err, "encoding patched document")
+	}
+
+	// Start from the zero value so removed members are cleared.
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Ptr {
+		return errors.Errorf("patch must be applied to a pointer, not %T", val)
+	}
+	rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(val); err != nil {
+		return NewRequestError(err, http.StatusBadRequest)
+	}
+
+	return check(val)
+}
+
+// mergePatch applies the patch to the target as defined by RFC 7396. A patch
+// that is not an object replaces the target.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{}, len(p))
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = mergePatch(t[name], value)
+	}
+
+	return t
+}
+
+// DecodeQuery reads the query parameters of an HTTP request into the provided
+// struct. Fields are bound to the parameter named by their `query` tag and may
+// be strings, bools, integers, pointers to those or string slices. Slices
+// accept repeated parameters and comma separated values. Unknown parameters
+// are rejected.
+//
+// The struct is then checked for validation tags.
+func DecodeQuery(r *http.Request, val interface{}) error {
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("query must be decoded into a pointer to a struct, not %T", val)
+	}
+	sv := rv.Elem()
+
+	query := r.URL.Query()
+	known := make(map[string]bool, sv.NumField())
+	for i := 0; i < sv.NumField(); i++ {
+		name := sv.Type().Field(i).Tag.Get("query")
+		if name == "" || name == "-" {
+			continue
+		}
+		known[name] = true
+
+		values := query[name]
+		if len(values) == 0 {
+			continue
+		}
+		if err := setQueryField(sv.Field(i), values); err != nil {
+			return NewRequestError(errors.Errorf("Invalid value for query parameter %q", name), http.StatusBadRequest)
+		}
+	}
+
+	for name := range query {
+		if !known[name] {
+			return NewRequestError(errors.Errorf("Unknown query parameter %q", name), http.StatusBadRequest)
+		}
+	}
+
+	return check(val)
+}
+
+// setQueryField parses the values of a query parameter into the field. Only
+// the first value is used unless the field is a slice.
+func setQueryField(f reflect.Value, values []string) error {
+	switch f.Kind() {
+	case reflect.Ptr:
+		p := reflect.New(f.Type().Elem())
+		if err := setQueryField(p.Elem(), values); err != nil {
+			return err
+		}
+		f.Set(p)
+	case reflect.String:
+		f.SetString(values[0])
+	case reflect.Bool:
+		b, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(values[0], 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return errors.Errorf("unsupported query field type %s", f.Type())
+		}
+		var all []string
+		for _, v := range values {
+			all = append(all, strings.Split(v, ",")...)
+		}
+		f.Set(reflect.ValueOf(all).Convert(f.Type()))
+	default:
+		return errors.Errorf("unsupported query field type %s", f.Type())
+	}
+
+	return nil
+}
+
+// check validates the struct against its validation tags. The errors are
+// translated into field errors of the response.
+func check(val interface{}) error {
 	if err := validate.Struct(val); err != nil {
 
 		// Use a type assertion to get the real error value.
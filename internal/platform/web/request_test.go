@@ -0,0 +1,155 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/web"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// document is the resource merge patches are applied to in the tests.
+type document struct {
+	Name string                 `json:"name,omitempty"`
+	Tags []string               `json:"tags,omitempty"`
+	Meta map[string]interface{} `json:"meta,omitempty"`
+}
+
+// status returns the HTTP status of a request error or 0 for any other error.
+func status(err error) int {
+	if werr, ok := errors.Cause(err).(*web.Error); ok {
+		return werr.Status
+	}
+	return 0
+}
+
+// TestDecodeMergePatch validates patches are merged into documents as defined
+// by RFC 7396.
+func TestDecodeMergePatch(t *testing.T) {
+	original := func() document {
+		return document{
+			Name: "gopher",
+			Tags: []string{"a", "b"},
+			Meta: map[string]interface{}{
+				"color": "blue",
+				"size":  map[string]interface{}{"w": 1.0, "h": 2.0},
+			},
+		}
+	}
+
+	tt := []struct {
+		name   string
+		patch  string
+		want   func(d *document)
+		status int
+	}{
+		{"an empty patch", `{}`, func(d *document) {}, 0},
+		{"a member patched to null", `{"name":null}`, func(d *document) { d.Name = "" }, 0},
+		{"a nested object", `{"meta":{"size":{"h":3}}}`, func(d *document) { d.Meta["size"] = map[string]interface{}{"w": 1.0, "h": 3.0} }, 0},
+		{"a nested member patched to null", `{"meta":{"color":null}}`, func(d *document) { delete(d.Meta, "color") }, 0},
+		{"an array", `{"tags":["c"]}`, func(d *document) { d.Tags = []string{"c"} }, 0},
+		{"a patch that is not an object", `["c"]`, nil, http.StatusBadRequest},
+		{"an unknown member", `{"bogus":1}`, nil, http.StatusBadRequest},
+	}
+
+	t.Log("Given the need to apply merge patches to documents.")
+	{
+		for _, tc := range tt {
+			t.Logf("\tWhen applying %s.", tc.name)
+			{
+				r := httptest.NewRequest("PATCH", "/v1/users/x", strings.NewReader(tc.patch))
+				r.Header.Set("Content-Type", web.MergePatchType)
+
+				got := original()
+				err := web.DecodeMergePatch(r, &got)
+				if tc.status != 0 {
+					if status(err) != tc.status {
+						t.Fatalf("\t%s\tShould receive a status code of %d : %v.", tests.Failed, tc.status, err)
+					}
+					t.Logf("\t%s\tShould receive a status code of %d.", tests.Success, tc.status)
+					continue
+				}
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to apply the patch : %s.", tests.Failed, err)
+				}
+
+				want := original()
+				tc.want(&want)
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Fatalf("\t%s\tShould get the patched document. Diff:\n%s", tests.Failed, diff)
+				}
+				t.Logf("\t%s\tShould get the patched document.", tests.Success)
+			}
+		}
+
+		t.Log("\tWhen sending a patch that is not a merge patch.")
+		{
+			r := httptest.NewRequest("PATCH", "/v1/users/x", strings.NewReader(`{}`))
+			r.Header.Set("Content-Type", "application/json")
+
+			d := original()
+			if err := web.DecodeMergePatch(r, &d); status(err) != http.StatusUnsupportedMediaType {
+				t.Fatalf("\t%s\tShould receive a status code of 415 : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould receive a status code of 415.", tests.Success)
+		}
+	}
+}
+
+// query mirrors the sorting and paging parameters of the list endpoints.
+type query struct {
+	Name  string `query:"name"`
+	Sort  string `query:"sort" validate:"omitempty,oneof=name -name"`
+	Limit int    `query:"limit" validate:"omitempty,min=1,max=100"`
+}
+
+// TestDecodeQuery validates query parameters are bound to their fields and
+// unknown parameters, unknown sort orders and out of range limits are
+// rejected.
+func TestDecodeQuery(t *testing.T) {
+	tt := []struct {
+		name   string
+		query  string
+		want   query
+		status int
+	}{
+		{"no parameters", "", query{}, 0},
+		{"every parameter", "name=bill&sort=-name&limit=100", query{Name: "bill", Sort: "-name", Limit: 100}, 0},
+		{"an unknown sort order", "sort=email", query{}, http.StatusBadRequest},
+		{"a limit below the range", "limit=-1", query{}, http.StatusBadRequest},
+		{"a limit above the range", "limit=101", query{}, http.StatusBadRequest},
+		{"a limit that is not a number", "limit=ten", query{}, http.StatusBadRequest},
+		{"an unknown parameter", "page=2", query{}, http.StatusBadRequest},
+	}
+
+	t.Log("Given the need to read query parameters.")
+	{
+		for _, tc := range tt {
+			t.Logf("\tWhen the request carries %s.", tc.name)
+			{
+				r := httptest.NewRequest("GET", "/v1/users?"+tc.query, nil)
+
+				var got query
+				err := web.DecodeQuery(r, &got)
+				if tc.status != 0 {
+					if status(err) != tc.status {
+						t.Fatalf("\t%s\tShould receive a status code of %d : %v.", tests.Failed, tc.status, err)
+					}
+					t.Logf("\t%s\tShould receive a status code of %d.", tests.Success, tc.status)
+					continue
+				}
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to decode the query : %s.", tests.Failed, err)
+				}
+				if diff := cmp.Diff(tc.want, got); diff != "" {
+					t.Fatalf("\t%s\tShould bind the parameters. Diff:\n%s", tests.Failed, diff)
+				}
+				t.Logf("\t%s\tShould bind the parameters.", tests.Success)
+			}
+		}
+	}
+}
@@ -25,6 +25,11 @@ type Values struct {
 	TraceID    string
 	Now        time.Time
 	StatusCode int
+
+	// Subject and Actor identify the caller once the request is
+	// authenticated. Actor is only set under impersonation.
+	Subject string
+	Actor   string
 }
 
 // A Handler is a type that handles an http request within our own little mini
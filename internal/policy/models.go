@@ -0,0 +1,46 @@
+package policy
+
+// Action names an operation on a kind of resource, such as "user.read".
+type Action string
+
+// These are the actions evaluated by Authorize.
+const (
+	UserList        Action = "user.list"
+	UserRead        Action = "user.read"
+	UserCreate      Action = "user.create"
+	UserUpdate      Action = "user.update"
+	UserDelete      Action = "user.delete"
+	UserRestore     Action = "user.restore"
+	UserLogout      Action = "user.logout"
+	UserImpersonate Action = "user.impersonate"
+	UserMFA         Action = "user.mfa"
+	TokenRevoke     Action = "token.revoke"
+	SessionList     Action = "session.list"
+	AccountList     Action = "account.list"
+	AccountUpdate   Action = "account.update"
+	AccountDelete   Action = "account.delete"
+	AccountRestore  Action = "account.restore"
+	RoleList        Action = "role.list"
+	RoleRead        Action = "role.read"
+	RoleCreate      Action = "role.create"
+	RoleUpdate      Action = "role.update"
+	RoleDelete      Action = "role.delete"
+	APIKeyList      Action = "apikey.list"
+	APIKeyCreate    Action = "apikey.create"
+	APIKeyRevoke    Action = "apikey.revoke"
+)
+
+// Resource describes what an action is performed on. The zero value stands
+// for a resource without a tenant or an owner, such as the collection of the
+// caller's own account.
+type Resource struct {
+
+	// AccountID is the tenant owning the resource.
+	AccountID string
+
+	// OwnerID is the user owning the resource. For a user it is the user.
+	OwnerID string
+
+	// Roles are the roles held by the resource when it is a user.
+	Roles []string
+}
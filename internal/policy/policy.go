@@ -0,0 +1,145 @@
+// Package policy decides whether the holder of some claims may perform an
+// action on a resource. Business packages call it before touching data so
+// every authorization rule lives in one place.
+package policy
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+)
+
+var (
+	// ErrForbidden occurs when the claims do not allow the action.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrOutsideTenant occurs when the resource belongs to an account the
+	// claims have no access to. Callers report the resource as missing so its
+	// existence is not leaked across tenants.
+	ErrOutsideTenant = errors.New("Resource belongs to another account")
+)
+
+// rule describes who may perform an action.
+type rule struct {
+
+	// perm is the permission granting the action. It is also the scope a
+	// narrowed token needs unless scope is set.
+	perm string
+
+	// scope is the scope a narrowed token needs for actions without perm.
+	scope string
+
+	// owner lets the user owning the resource perform the action without
+	// perm. With a blank perm only the owner may perform it.
+	owner bool
+
+	// roles, when set, are required on top of perm.
+	roles []string
+
+	// notOwner forbids the action on the caller's own resource.
+	notOwner bool
+
+	// privileged forbids the action on a resource holding the superadmin
+	// role unless the caller is a superadmin.
+	privileged bool
+}
+
+// rules holds the rule of every action. Actions missing from it are denied.
+var rules = map[Action]rule{
+	UserList:        {perm: auth.PermUsersRead},
+	UserRead:        {perm: auth.PermUsersRead, owner: true},
+	UserCreate:      {perm: auth.PermUsersWrite},
+	UserUpdate:      {perm: auth.PermUsersWrite, privileged: true},
+	UserDelete:      {perm: auth.PermUsersWrite, privileged: true},
+	UserRestore:     {perm: auth.PermUsersWrite, privileged: true},
+	UserLogout:      {perm: auth.PermUsersWrite, owner: true, privileged: true},
+	UserImpersonate: {perm: auth.PermUsersWrite, roles: []string{auth.RoleAdmin, auth.RoleSuperAdmin}, notOwner: true, privileged: true},
+	UserMFA:         {scope: auth.PermUsersWrite, owner: true},
+	TokenRevoke:     {perm: auth.PermUsersWrite, owner: true, privileged: true},
+	SessionList:     {perm: auth.PermUsersRead, owner: true},
+	AccountList:     {perm: auth.PermAccountsRead},
+	AccountUpdate:   {perm: auth.PermAccountsWrite},
+	AccountDelete:   {perm: auth.PermAccountsWrite, roles: []string{auth.RoleSuperAdmin}},
+	AccountRestore:  {perm: auth.PermAccountsWrite, roles: []string{auth.RoleSuperAdmin}},
+	RoleList:        {perm: auth.PermRolesRead},
+	RoleRead:        {perm: auth.PermRolesRead},
+	RoleCreate:      {perm: auth.PermRolesWrite},
+	RoleUpdate:      {perm: auth.PermRolesWrite},
+	RoleDelete:      {perm: auth.PermRolesWrite},
+	APIKeyList:      {perm: auth.PermAPIKeysRead},
+	APIKeyCreate:    {perm: auth.PermAPIKeysWrite},
+	APIKeyRevoke:    {perm: auth.PermAPIKeysWrite},
+}
+
+// Authorize returns nil if the claims allow the action on the resource. It
+// returns ErrOutsideTenant if the resource belongs to an account the claims
+// cannot see and ErrForbidden for any other refusal.
+func Authorize(claims auth.Claims, action Action, res Resource) error {
+	r, ok := rules[action]
+	if !ok {
+		return ErrForbidden
+	}
+
+	if res.AccountID != "" && !claims.InAccount(res.AccountID) {
+		return ErrOutsideTenant
+	}
+
+	isOwner := res.OwnerID != "" && res.OwnerID == claims.Subject
+	if r.notOwner && isOwner {
+		return ErrForbidden
+	}
+	if r.privileged && hasRole(res.Roles, auth.RoleSuperAdmin) && !claims.HasRole(auth.RoleSuperAdmin) {
+		return ErrForbidden
+	}
+	if len(r.roles) > 0 && !claims.HasRole(r.roles...) {
+		return ErrForbidden
+	}
+
+	scope := r.scope
+	if scope == "" {
+		scope = r.perm
+	}
+
+	switch {
+	case scope != "" && !claims.HasScope(scope):
+		return ErrForbidden
+	case r.owner && isOwner:
+		return nil
+	case r.perm != "" && claims.HasPermission(r.perm):
+		return nil
+	case r.perm == "" && !r.owner:
+		return nil
+	}
+
+	return ErrForbidden
+}
+
+// GrantRoles returns ErrForbidden if the claims may not give the roles, which
+// grant perms, to a user. Only superadmins may grant the superadmin role so
+// tenants cannot grant platform wide access, and no caller may grant a
+// permission it does not hold or that its token is not scoped to.
+func GrantRoles(claims auth.Claims, roles, perms []string) error {
+	if hasRole(roles, auth.RoleSuperAdmin) && !claims.HasRole(auth.RoleSuperAdmin) {
+		return ErrForbidden
+	}
+	return HasPermission(claims, perms...)
+}
+
+// HasPermission returns ErrForbidden unless the claims hold every permission
+// and their token is scoped to all of them. It suits checks that do not act on
+// a resource of a tenant, which Authorize decides.
+func HasPermission(claims auth.Claims, perms ...string) error {
+	if !claims.HasPermission(perms...) || !claims.HasScope(perms...) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// hasRole reports whether roles contains the role.
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+package policy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/policy"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// These are the accounts the callers and resources belong to.
+const (
+	accountA = "a"
+	accountB = "b"
+)
+
+// callers holds claims of every kind of caller, in the order of the want
+// columns of the route table.
+var callers = []struct {
+	name   string
+	claims auth.Claims
+}{
+	{"superadmin", claims("super", "platform", "", auth.RoleSuperAdmin)},
+	{"admin", claims("admin", accountA, "", auth.RoleAdmin)},
+	{"member", claims("member", accountA, "", auth.RoleUser)},
+	{"outsider", claims("outsider", accountB, "", auth.RoleAdmin)},
+	{"narrowed", claims("admin", accountA, auth.PermUsersRead, auth.RoleAdmin)},
+}
+
+// These are the resources the routes act on.
+var (
+	none   = policy.Resource{}
+	tenant = policy.Resource{AccountID: accountA}
+	peer   = policy.Resource{AccountID: accountA, OwnerID: "peer", Roles: []string{auth.RoleUser}}
+	root   = policy.Resource{AccountID: accountA, OwnerID: "root", Roles: []string{auth.RoleSuperAdmin}}
+)
+
+// Short names for the outcomes.
+var (
+	ok      error
+	denied  = policy.ErrForbidden
+	missing = policy.ErrOutsideTenant
+)
+
+// TestRoutes validates the decision for every authenticated route and every
+// kind of caller. Issuing a scoped token is not listed: it only narrows the
+// caller's own token to permissions they already hold.
+func TestRoutes(t *testing.T) {
+	routes := []struct {
+		route  string
+		action policy.Action
+		res    policy.Resource
+		want   [5]error
+	}{
+		{"POST /v1/users/token/revoke", policy.TokenRevoke, peer, [5]error{ok, ok, denied, missing, denied}},
+		{"GET /v1/users", policy.UserList, none, [5]error{ok, ok, denied, ok, ok}},
+		{"POST /v1/users", policy.UserCreate, tenant, [5]error{ok, ok, denied, missing, denied}},
+		{"GET /v1/users/:id", policy.UserRead, peer, [5]error{ok, ok, denied, missing, ok}},
+		{"PUT /v1/users/:id", policy.UserUpdate, peer, [5]error{ok, ok, denied, missing, denied}},
+		{"PATCH /v1/users/:id", policy.UserUpdate, peer, [5]error{ok, ok, denied, missing, denied}},
+		{"DELETE /v1/users/:id", policy.UserDelete, peer, [5]error{ok, ok, denied, missing, denied}},
+		{"POST /v1/users/:id/restore", policy.UserRestore, peer, [5]error{ok, ok, denied, missing, denied}},
+		{"POST /v1/users/:id/revoke", policy.UserLogout, peer, [5]error{ok, ok, denied, missing, denied}},
+		{"GET /v1/users/:id/sessions", policy.SessionList, peer, [5]error{ok, ok, denied, missing, ok}},
+		{"DELETE /v1/users/:id/sessions", policy.UserLogout, peer, [5]error{ok, ok, denied, missing, denied}},
+		{"DELETE /v1/users/:id/sessions/:sid", policy.UserLogout, peer, [5]error{ok, ok, denied, missing, denied}},
+		{"POST /v1/users/:id/impersonate", policy.UserImpersonate, peer, [5]error{ok, ok, denied, missing, denied}},
+		{"POST /v1/users/mfa/enroll", policy.UserMFA, peer, [5]error{denied, denied, denied, missing, denied}},
+		{"POST /v1/users/mfa/confirm", policy.UserMFA, peer, [5]error{denied, denied, denied, missing, denied}},
+		{"DELETE /v1/users/mfa", policy.UserMFA, peer, [5]error{denied, denied, denied, missing, denied}},
+		{"GET /v1/accounts", policy.AccountList, none, [5]error{ok, ok, ok, ok, denied}},
+		{"PUT /v1/accounts/:id", policy.AccountUpdate, tenant, [5]error{ok, ok, denied, missing, denied}},
+		{"DELETE /v1/accounts/:id", policy.AccountDelete, tenant, [5]error{ok, denied, denied, missing, denied}},
+		{"POST /v1/accounts/:id/restore", policy.AccountRestore, tenant, [5]error{ok, denied, denied, missing, denied}},
+		{"GET /v1/roles", policy.RoleList, none, [5]error{ok, ok, denied, ok, denied}},
+		{"POST /v1/roles", policy.RoleCreate, tenant, [5]error{ok, ok, denied, missing, denied}},
+		{"GET /v1/roles/:id", policy.RoleRead, tenant, [5]error{ok, ok, denied, missing, denied}},
+		{"PUT /v1/roles/:id", policy.RoleUpdate, tenant, [5]error{ok, ok, denied, missing, denied}},
+		{"DELETE /v1/roles/:id", policy.RoleDelete, tenant, [5]error{ok, ok, denied, missing, denied}},
+		{"GET /v1/apikeys", policy.APIKeyList, none, [5]error{ok, ok, denied, ok, denied}},
+		{"POST /v1/apikeys", policy.APIKeyCreate, tenant, [5]error{ok, ok, denied, missing, denied}},
+		{"DELETE /v1/apikeys/:id", policy.APIKeyRevoke, tenant, [5]error{ok, ok, denied, missing, denied}},
+	}
+
+	t.Log("Given the need to authorize every route.")
+	{
+		for _, rt := range routes {
+			t.Logf("\tWhen calling %s.", rt.route)
+			{
+				for i, c := range callers {
+					if err := policy.Authorize(c.claims, rt.action, rt.res); err != rt.want[i] {
+						t.Fatalf("\t%s\tShould get %v as %s : got %v.", tests.Failed, rt.want[i], c.name, err)
+					}
+				}
+				t.Logf("\t%s\tShould get the expected decision for every caller.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestOwnership validates the decisions that depend on who the target user is.
+func TestOwnership(t *testing.T) {
+	admin := callers[1].claims
+	member := callers[2].claims
+	narrowed := callers[4].claims
+	self := func(c auth.Claims) policy.Resource {
+		return policy.Resource{AccountID: c.AccountID, OwnerID: c.Subject, Roles: c.Roles}
+	}
+
+	cases := []struct {
+		name   string
+		claims auth.Claims
+		action policy.Action
+		res    policy.Resource
+		want   error
+	}{
+		{"member reads themselves", member, policy.UserRead, self(member), ok},
+		{"member lists their sessions", member, policy.SessionList, self(member), ok},
+		{"member logs themselves out", member, policy.UserLogout, self(member), ok},
+		{"member updates themselves", member, policy.UserUpdate, self(member), denied},
+		{"member enrolls a second factor", member, policy.UserMFA, self(member), ok},
+		{"narrowed token enrolls a second factor", narrowed, policy.UserMFA, self(narrowed), denied},
+		{"narrowed token logs itself out", narrowed, policy.UserLogout, self(narrowed), denied},
+		{"admin impersonates themselves", admin, policy.UserImpersonate, self(admin), denied},
+		{"admin impersonates a superadmin", admin, policy.UserImpersonate, root, denied},
+		{"admin updates a superadmin", admin, policy.UserUpdate, root, denied},
+		{"admin deletes a superadmin", admin, policy.UserDelete, root, denied},
+		{"admin restores a superadmin", admin, policy.UserRestore, root, denied},
+		{"admin logs a superadmin out", admin, policy.UserLogout, root, denied},
+		{"admin revokes a token of a superadmin", admin, policy.TokenRevoke, root, denied},
+		{"admin reads a superadmin", admin, policy.UserRead, root, ok},
+		{"superadmin impersonates a superadmin", callers[0].claims, policy.UserImpersonate, root, ok},
+		{"unknown action", callers[0].claims, policy.Action("user.unknown"), none, denied},
+	}
+
+	t.Log("Given the need to authorize actions on users.")
+	{
+		for _, tc := range cases {
+			t.Logf("\tWhen the %s.", tc.name)
+			{
+				if err := policy.Authorize(tc.claims, tc.action, tc.res); err != tc.want {
+					t.Fatalf("\t%s\tShould get %v : got %v.", tests.Failed, tc.want, err)
+				}
+				t.Logf("\t%s\tShould get %v.", tests.Success, tc.want)
+			}
+		}
+	}
+}
+
+// TestGrantRoles validates only superadmins may grant the superadmin role and
+// no caller may grant a role carrying permissions it does not hold.
+func TestGrantRoles(t *testing.T) {
+	all := auth.Permissions
+	user := []string{auth.PermAccountsRead}
+
+	// support is a custom role that may manage users but nothing else.
+	support := claims("support", accountA, "", "support")
+	support.Permissions = []string{auth.PermUsersRead, auth.PermUsersWrite}
+
+	// key is an API key scoped to managing users.
+	key := support
+	key.Roles = nil
+	key.APIKey = true
+
+	cases := []struct {
+		name   string
+		claims auth.Claims
+		roles  []string
+		perms  []string
+		want   error
+	}{
+		{"superadmin", callers[0].claims, []string{auth.RoleSuperAdmin}, all, ok},
+		{"admin", callers[1].claims, []string{auth.RoleAdmin, auth.RoleUser}, all, ok},
+		{"admin", callers[1].claims, []string{auth.RoleSuperAdmin}, all, denied},
+		{"member", callers[2].claims, []string{auth.RoleUser}, user, ok},
+		{"member", callers[2].claims, []string{auth.RoleAdmin}, all, denied},
+		{"narrowed admin", callers[4].claims, []string{auth.RoleUser}, user, denied},
+		{"support user", support, []string{"helpdesk"}, []string{auth.PermUsersRead}, ok},
+		{"support user", support, []string{auth.RoleAdmin}, all, denied},
+		{"support user", support, []string{auth.RoleUser}, user, denied},
+		{"scoped API key", key, []string{"helpdesk"}, []string{auth.PermUsersRead}, ok},
+		{"scoped API key", key, []string{auth.RoleAdmin}, all, denied},
+	}
+
+	t.Log("Given the need to control which roles can be granted.")
+	{
+		for _, tc := range cases {
+			t.Logf("\tWhen a %s grants %v.", tc.name, tc.roles)
+			{
+				if err := policy.GrantRoles(tc.claims, tc.roles, tc.perms); err != tc.want {
+					t.Fatalf("\t%s\tShould get %v : got %v.", tests.Failed, tc.want, err)
+				}
+				t.Logf("\t%s\tShould get %v.", tests.Success, tc.want)
+			}
+		}
+	}
+}
+
+// claims returns the claims of a caller. A non blank scope narrows them.
+func claims(subject, accountID, scope string, roles ...string) auth.Claims {
+	c := auth.NewClaims(subject, roles, time.Now(), time.Hour)
+	c.AccountID = accountID
+	c.Scope = scope
+	return c
+}
@@ -0,0 +1,23 @@
+package refresh
+
+import (
+	"time"
+)
+
+// Token represents a stored refresh token. Only the hash of the opaque token
+// handed to the client is stored.
+type Token struct {
+	ID        string     `db:"token_id" json:"id"`
+	FamilyID  string     `db:"family_id" json:"family_id"`
+	UserID    string     `db:"user_id" json:"user_id"`
+	TokenHash string     `db:"token_hash" json:"-"`
+	ExpiresAt time.Time  `db:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time `db:"used_at" json:"used_at"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revoked_at"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+// RefreshRequest is the body of a request to exchange a refresh token.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
@@ -0,0 +1,183 @@
+package refresh
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+var (
+	// ErrInvalidToken occurs when a refresh token is unknown, expired or revoked.
+	ErrInvalidToken = errors.New("Refresh token is not valid")
+
+	// ErrTokenReused occurs when a refresh token that was already exchanged is
+	// presented again. The whole token family is revoked when this happens.
+	ErrTokenReused = errors.New("Refresh token has already been used")
+)
+
+// Issue starts a new token family for the user and returns the opaque refresh
+// token to hand to the client. The family ID is the ID of the session the
+// login started.
+func Issue(ctx context.Context, db *sqlx.DB, familyID, userID string, now time.Time, expires time.Duration) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.refresh.Issue")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	token, err := insert(ctx, tx, familyID, userID, now, expires)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", errors.Wrap(err, "committing transaction")
+	}
+
+	return token, nil
+}
+
+// Rotate exchanges a refresh token for a new one in the same family. The
+// presented token can never be used again. If it already was, the token was
+// most likely stolen so every token in its family is revoked and
+// ErrTokenReused is returned. On success the exchanged token is returned along
+// with the new refresh token.
+func Rotate(ctx context.Context, db *sqlx.DB, token string, now time.Time, expires time.Duration) (*Token, string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.refresh.Rotate")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	// Lock the row so two concurrent exchanges of the same token cannot both
+	// succeed.
+	var t Token
+	const q = `SELECT * FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &t, q, hash(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", ErrInvalidToken
+		}
+		return nil, "", errors.Wrap(err, "selecting refresh token")
+	}
+
+	if t.RevokedAt != nil || !now.Before(t.ExpiresAt) {
+		return nil, "", ErrInvalidToken
+	}
+
+	if t.UsedAt != nil {
+		if err := revokeFamily(ctx, tx, t.FamilyID, now); err != nil {
+			return nil, "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, "", errors.Wrap(err, "committing transaction")
+		}
+		return nil, "", ErrTokenReused
+	}
+
+	const u = `UPDATE refresh_tokens SET used_at = $2 WHERE token_id = $1`
+	if _, err := tx.ExecContext(ctx, u, t.ID, now.UTC()); err != nil {
+		return nil, "", errors.Wrap(err, "marking refresh token used")
+	}
+
+	next, err := insert(ctx, tx, t.FamilyID, t.UserID, now, expires)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", errors.Wrap(err, "committing transaction")
+	}
+
+	return &t, next, nil
+}
+
+// RevokeUser revokes every refresh token issued to the user.
+func RevokeUser(ctx context.Context, db *sqlx.DB, userID string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.refresh.RevokeUser")
+	defer span.End()
+
+	const q = `UPDATE refresh_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err := db.ExecContext(ctx, q, userID, now.UTC()); err != nil {
+		return errors.Wrapf(err, "revoking refresh tokens of user %s", userID)
+	}
+
+	return nil
+}
+
+// RevokeFamily revokes every refresh token descending from the same login.
+func RevokeFamily(ctx context.Context, db *sqlx.DB, familyID string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.refresh.RevokeFamily")
+	defer span.End()
+
+	const q = `UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL`
+	if _, err := db.ExecContext(ctx, q, familyID, now.UTC()); err != nil {
+		return errors.Wrapf(err, "revoking refresh token family %s", familyID)
+	}
+
+	return nil
+}
+
+// insert stores a new refresh token in the family and returns the opaque
+// token.
+func insert(ctx context.Context, tx *sqlx.Tx, familyID, userID string, now time.Time, expires time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating refresh token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	t := Token{
+		ID:        uuid.New().String(),
+		FamilyID:  familyID,
+		UserID:    userID,
+		TokenHash: hash(token),
+		ExpiresAt: now.Add(expires).UTC(),
+		CreatedAt: now.UTC(),
+	}
+
+	const q = `INSERT INTO refresh_tokens
+		(token_id, family_id, user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := tx.ExecContext(
+		ctx, q,
+		t.ID, t.FamilyID, t.UserID,
+		t.TokenHash, t.ExpiresAt, t.CreatedAt,
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "inserting refresh token")
+	}
+
+	return token, nil
+}
+
+// revokeFamily revokes every token in the family that is not revoked yet.
+func revokeFamily(ctx context.Context, tx *sqlx.Tx, familyID string, now time.Time) error {
+	const q = `UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, q, familyID, now.UTC()); err != nil {
+		return errors.Wrapf(err, "revoking refresh token family %s", familyID)
+	}
+
+	return nil
+}
+
+// hash returns the form of a refresh token stored in the database. The tokens
+// carry 256 bits of entropy so a plain SHA-256 is sufficient.
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
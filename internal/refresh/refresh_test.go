@@ -0,0 +1,121 @@
+package refresh_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sankarvj/seedgo/internal/account"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/refresh"
+	"github.com/sankarvj/seedgo/internal/tests"
+	"github.com/sankarvj/seedgo/internal/user"
+)
+
+// TestRotate validates refresh tokens are exchanged once and that presenting
+// one twice revokes every token of the family.
+func TestRotate(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to rotate refresh tokens.")
+	{
+		t.Log("\tWhen handling the tokens of a single login.")
+		{
+			ctx := tests.Context()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			a, err := account.Create(ctx, db, account.NewAccount{Name: "Wayplot", Domain: "Wayplot"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
+			}
+			nu := user.NewUser{
+				AccountID:       a.ID,
+				Name:            "Anna Walker",
+				Email:           "anna@ardanlabs.com",
+				Roles:           []string{auth.RoleUser},
+				Password:        "gophers",
+				PasswordConfirm: "gophers",
+			}
+			u, err := user.Create(ctx, auth.Claims{Roles: []string{auth.RoleSuperAdmin}}, db, nu, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+			}
+
+			family := uuid.New().String()
+			first, err := refresh.Issue(ctx, db, family, u.ID, now, time.Hour)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to issue a refresh token : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to issue a refresh token.", tests.Success)
+
+			tkn, second, err := refresh.Rotate(ctx, db, first, now.Add(time.Minute), time.Hour)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to rotate the refresh token : %s.", tests.Failed, err)
+			}
+			if tkn.UserID != u.ID || tkn.FamilyID != family || second == first {
+				t.Fatalf("\t%s\tShould get a new token of the same family : %+v.", tests.Failed, tkn)
+			}
+			t.Logf("\t%s\tShould be able to rotate the refresh token.", tests.Success)
+
+			if _, _, err := refresh.Rotate(ctx, db, first, now.Add(2*time.Minute), time.Hour); err != refresh.ErrTokenReused {
+				t.Fatalf("\t%s\tShould detect the reuse of a rotated token : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould detect the reuse of a rotated token.", tests.Success)
+
+			if _, _, err := refresh.Rotate(ctx, db, second, now.Add(3*time.Minute), time.Hour); err != refresh.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould revoke the family once a token is reused : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould revoke the family once a token is reused.", tests.Success)
+		}
+
+		t.Log("\tWhen handling unknown, expired and revoked tokens.")
+		{
+			ctx := tests.Context()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			a, err := account.Create(ctx, db, account.NewAccount{Name: "Ardan", Domain: "Ardan"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
+			}
+			nu := user.NewUser{
+				AccountID:       a.ID,
+				Name:            "Bill Kennedy",
+				Email:           "bill@ardanlabs.com",
+				Roles:           []string{auth.RoleUser},
+				Password:        "gophers",
+				PasswordConfirm: "gophers",
+			}
+			u, err := user.Create(ctx, auth.Claims{Roles: []string{auth.RoleSuperAdmin}}, db, nu, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+			}
+
+			if _, _, err := refresh.Rotate(ctx, db, "unknown", now, time.Hour); err != refresh.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould reject an unknown token : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould reject an unknown token.", tests.Success)
+
+			expired, err := refresh.Issue(ctx, db, uuid.New().String(), u.ID, now, time.Hour)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to issue a refresh token : %s.", tests.Failed, err)
+			}
+			if _, _, err := refresh.Rotate(ctx, db, expired, now.Add(time.Hour), time.Hour); err != refresh.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould reject an expired token : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould reject an expired token.", tests.Success)
+
+			revoked, err := refresh.Issue(ctx, db, uuid.New().String(), u.ID, now, time.Hour)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to issue a refresh token : %s.", tests.Failed, err)
+			}
+			if err := refresh.RevokeUser(ctx, db, u.ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the tokens of the user : %s.", tests.Failed, err)
+			}
+			if _, _, err := refresh.Rotate(ctx, db, revoked, now.Add(time.Minute), time.Hour); err != refresh.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould reject a revoked token : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould reject a revoked token.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package reset
+
+import (
+	"time"
+)
+
+// Token represents a stored password reset token. Only the hash of the opaque
+// token mailed to the user is stored.
+type Token struct {
+	ID        string     `db:"token_id" json:"id"`
+	UserID    string     `db:"user_id" json:"user_id"`
+	TokenHash string     `db:"token_hash" json:"-"`
+	ExpiresAt time.Time  `db:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time `db:"used_at" json:"used_at"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+// ForgotRequest is the body of a request to mail a password reset link.
+type ForgotRequest struct {
+	Email string `json:"email" validate:"required"`
+}
+
+// ResetRequest is the body of a request to choose a new password.
+type ResetRequest struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
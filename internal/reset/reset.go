@@ -0,0 +1,163 @@
+// Package reset issues and redeems the single use tokens letting users choose
+// a new password.
+package reset
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/mail"
+	"go.opencensus.io/trace"
+)
+
+// ErrInvalidToken occurs when a reset token is unknown, expired or was
+// already used.
+var ErrInvalidToken = errors.New("Reset token is not valid")
+
+// Config holds the settings used to construct a Resetter.
+type Config struct {
+
+	// TTL is how long a token stays valid.
+	TTL time.Duration
+
+	// URL is the page users open to choose a new password. The token is added
+	// to it as the token query parameter. Blank sends the bare token.
+	URL string
+}
+
+// Resetter issues password reset tokens, mails them to users and redeems
+// them.
+type Resetter struct {
+	db     *sqlx.DB
+	cfg    Config
+	mailer mail.Mailer
+}
+
+// New constructs a Resetter sending tokens through the mailer.
+func New(db *sqlx.DB, cfg Config, mailer mail.Mailer) (*Resetter, error) {
+	if cfg.TTL <= 0 {
+		return nil, errors.New("reset ttl must be positive")
+	}
+	if cfg.URL != "" {
+		if _, err := url.Parse(cfg.URL); err != nil {
+			return nil, errors.Wrap(err, "parsing reset url")
+		}
+	}
+
+	r := Resetter{
+		db:     db,
+		cfg:    cfg,
+		mailer: mailer,
+	}
+
+	return &r, nil
+}
+
+// Send issues a reset token for the user and mails it to them.
+func (r *Resetter) Send(ctx context.Context, userID, email string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.reset.Send")
+	defer span.End()
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return errors.Wrap(err, "generating reset token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	t := Token{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		TokenHash: hash(token),
+		ExpiresAt: now.Add(r.cfg.TTL).UTC(),
+		CreatedAt: now.UTC(),
+	}
+
+	const q = `INSERT INTO password_resets
+		(token_id, user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+	if _, err := r.db.ExecContext(ctx, q, t.ID, t.UserID, t.TokenHash, t.ExpiresAt, t.CreatedAt); err != nil {
+		return errors.Wrap(err, "inserting reset token")
+	}
+
+	link := token
+	if r.cfg.URL != "" {
+		u, _ := url.Parse(r.cfg.URL)
+		q := u.Query()
+		q.Set("token", token)
+		u.RawQuery = q.Encode()
+		link = u.String()
+	}
+
+	m := mail.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Choose a new password by opening the link below. It expires in %s and can be used once. If you did not ask to reset your password you can ignore this email.\n\n%s", r.cfg.TTL, link),
+	}
+	if err := r.mailer.Send(ctx, m); err != nil {
+		return errors.Wrap(err, "sending reset email")
+	}
+
+	return nil
+}
+
+// Redeem calls apply with the ID of the user the token was issued for and
+// consumes the token once apply succeeds. If apply fails the token can be
+// redeemed again. A token can only be redeemed once. Every other token of the
+// user is consumed along with it.
+func (r *Resetter) Redeem(ctx context.Context, token string, now time.Time, apply func(ctx context.Context, userID string) error) error {
+	ctx, span := trace.StartSpan(ctx, "internal.reset.Redeem")
+	defer span.End()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	// Lock the row until the token is consumed so two concurrent redemptions
+	// of the same token cannot both succeed.
+	var t Token
+	const q = `SELECT * FROM password_resets WHERE token_hash = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &t, q, hash(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidToken
+		}
+		return errors.Wrap(err, "selecting reset token")
+	}
+
+	if t.UsedAt != nil || !now.Before(t.ExpiresAt) {
+		return ErrInvalidToken
+	}
+
+	if err := apply(ctx, t.UserID); err != nil {
+		return err
+	}
+
+	const u = `UPDATE password_resets SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL`
+	if _, err := tx.ExecContext(ctx, u, t.UserID, now.UTC()); err != nil {
+		return errors.Wrap(err, "marking reset tokens used")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// hash returns the form of a reset token stored in the database. The tokens
+// carry 256 bits of entropy so a plain SHA-256 is sufficient.
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,109 @@
+package reset_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/account"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/platform/mail"
+	"github.com/sankarvj/seedgo/internal/reset"
+	"github.com/sankarvj/seedgo/internal/tests"
+	"github.com/sankarvj/seedgo/internal/user"
+)
+
+// TestRedeem validates reset tokens are redeemed once, only before they
+// expire and only once the change they allow succeeds.
+func TestRedeem(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to redeem password reset tokens.")
+	{
+		t.Log("\tWhen handling the tokens of a single user.")
+		{
+			ctx := tests.Context()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			a, err := account.Create(ctx, db, account.NewAccount{Name: "Wayplot", Domain: "Wayplot"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
+			}
+			nu := user.NewUser{
+				AccountID:       a.ID,
+				Name:            "Anna Walker",
+				Email:           "anna@ardanlabs.com",
+				Roles:           []string{auth.RoleUser},
+				Password:        "gophers",
+				PasswordConfirm: "gophers",
+			}
+			u, err := user.Create(ctx, auth.Claims{Roles: []string{auth.RoleSuperAdmin}}, db, nu, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+			}
+
+			var buf bytes.Buffer
+			r, err := reset.New(db, reset.Config{TTL: time.Hour}, mail.NewLog(&buf))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to construct a resetter : %s.", tests.Failed, err)
+			}
+
+			// send mails a new token and returns it. The token ends the mail
+			// when no URL is configured.
+			send := func(at time.Time) string {
+				t.Helper()
+				buf.Reset()
+				if err := r.Send(ctx, u.ID, u.Email, at); err != nil {
+					t.Fatalf("\t%s\tShould be able to send a token : %s.", tests.Failed, err)
+				}
+				fields := strings.Fields(buf.String())
+				return fields[len(fields)-1]
+			}
+
+			var redeemed string
+			apply := func(ctx context.Context, userID string) error {
+				redeemed = userID
+				return nil
+			}
+
+			token := send(now)
+			if err := r.Redeem(ctx, token, now.Add(2*time.Hour), apply); err != reset.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould NOT be able to redeem an expired token : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to redeem an expired token.", tests.Success)
+
+			token = send(now)
+			failed := errors.New("failed")
+			if err := r.Redeem(ctx, token, now.Add(time.Minute), func(context.Context, string) error { return failed }); err != failed {
+				t.Fatalf("\t%s\tShould get the error of a failed change : %v.", tests.Failed, err)
+			}
+			if err := r.Redeem(ctx, token, now.Add(time.Minute), apply); err != nil || redeemed != u.ID {
+				t.Fatalf("\t%s\tShould be able to redeem the token after a failed change : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to redeem the token after a failed change.", tests.Success)
+
+			if err := r.Redeem(ctx, token, now.Add(time.Minute), apply); err != reset.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould NOT be able to redeem a token twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to redeem a token twice.", tests.Success)
+
+			first, second := send(now), send(now)
+			if err := r.Redeem(ctx, second, now.Add(time.Minute), apply); err != nil {
+				t.Fatalf("\t%s\tShould be able to redeem the token : %s.", tests.Failed, err)
+			}
+			if err := r.Redeem(ctx, first, now.Add(time.Minute), apply); err != reset.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould NOT be able to redeem the other tokens of the user : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to redeem the other tokens of the user.", tests.Success)
+
+			if err := r.Redeem(ctx, "unknown", now, apply); err != reset.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould NOT be able to redeem an unknown token : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to redeem an unknown token.", tests.Success)
+		}
+	}
+}
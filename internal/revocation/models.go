@@ -0,0 +1,9 @@
+package revocation
+
+// RevokeToken is the body of a request to revoke a single token. A blank JTI
+// revokes the token used to make the request. Revoking another token needs the
+// ID of the user it was issued to.
+type RevokeToken struct {
+	JTI    string `json:"jti"`
+	UserID string `json:"user_id"`
+}
@@ -0,0 +1,280 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"go.opencensus.io/trace"
+)
+
+// Store records revoked access tokens. Tokens are revoked either one at a time
+// by their jti, by the session they were issued for or all at once for a user. The revocations are kept in Postgres
+// so every instance of the service sees them and are cached in memory so
+// checking a token does not cost a query.
+type Store struct {
+	db       *sqlx.DB
+	maxAge   time.Duration
+	tokenTTL time.Duration
+
+	// syncMu lets a single request refresh the cache at a time.
+	syncMu sync.Mutex
+
+	mu       sync.RWMutex
+	synced   time.Time
+	tokens   map[token]time.Time  // revoked token -> its expiry
+	sessions map[string]time.Time // session id -> expiry of its last token
+	users    map[string]time.Time // user id -> tokens issued before are revoked
+}
+
+// token identifies a revoked token. A blank userID revokes the jti whoever
+// the token was issued to.
+type token struct {
+	jti    string
+	userID string
+}
+
+// NewStore constructs a Store. The in-memory cache is refreshed from the
+// database when it is older than maxAge, which bounds how long a revocation
+// made by another instance takes to be enforced here. The tokens of a user
+// are revoked for tokenTTL, the longest lifetime an access token can be
+// issued with, after which every token they covered has expired.
+func NewStore(db *sqlx.DB, maxAge, tokenTTL time.Duration) *Store {
+	s := Store{
+		db:       db,
+		maxAge:   maxAge,
+		tokenTTL: tokenTTL,
+		tokens:   make(map[token]time.Time),
+		sessions: make(map[string]time.Time),
+		users:    make(map[string]time.Time),
+	}
+
+	return &s
+}
+
+// IsRevoked reports whether the token the claims were parsed from has been
+// revoked.
+func (s *Store) IsRevoked(ctx context.Context, claims auth.Claims) (bool, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.revocation.IsRevoked")
+	defer span.End()
+
+	if s.stale() {
+		if err := s.sync(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if claims.Id != "" {
+		if _, ok := s.tokens[token{jti: claims.Id}]; ok {
+			return true, nil
+		}
+		if _, ok := s.tokens[token{jti: claims.Id, userID: claims.Subject}]; ok {
+			return true, nil
+		}
+	}
+	if _, ok := s.sessions[claims.SessionID]; ok && claims.SessionID != "" {
+		return true, nil
+	}
+	if before, ok := s.users[claims.Subject]; ok && claims.Issued().Before(before) {
+		return true, nil
+	}
+
+	// Revoking the tokens of an admin also ends their impersonations.
+	if claims.Actor != nil {
+		if before, ok := s.users[claims.Actor.Subject]; ok && claims.Issued().Before(before) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RevokeToken revokes a single token by its jti. With a user ID only a token
+// issued to that user is revoked, and revoking the same jti for another user
+// revokes it for them too. The revocation is kept until the token would have
+// expired anyway.
+func (s *Store) RevokeToken(ctx context.Context, jti, userID string, expiresAt time.Time, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.revocation.RevokeToken")
+	defer span.End()
+
+	const q = `INSERT INTO revoked_tokens
+		(jti, user_id, expires_at, revoked_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (jti, user_id) DO UPDATE SET expires_at = GREATEST(revoked_tokens.expires_at, EXCLUDED.expires_at)`
+	if _, err := s.db.ExecContext(ctx, q, jti, userID, expiresAt.UTC(), now.UTC()); err != nil {
+		return errors.Wrapf(err, "revoking token %s", jti)
+	}
+
+	t := token{jti: jti, userID: userID}
+	s.mu.Lock()
+	if expiresAt.After(s.tokens[t]) {
+		s.tokens[t] = expiresAt
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// RevokeSession revokes every token issued for the session. The revocation is
+// kept until the last token of the session would have expired anyway.
+func (s *Store) RevokeSession(ctx context.Context, sessionID string, expiresAt time.Time, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.revocation.RevokeSession")
+	defer span.End()
+
+	const q = `INSERT INTO revoked_sessions
+		(session_id, expires_at, revoked_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+	if _, err := s.db.ExecContext(ctx, q, sessionID, expiresAt.UTC(), now.UTC()); err != nil {
+		return errors.Wrapf(err, "revoking session %s", sessionID)
+	}
+
+	s.mu.Lock()
+	s.sessions[sessionID] = expiresAt
+	s.mu.Unlock()
+
+	return nil
+}
+
+// RevokeUser revokes every token issued to the user before now. Tokens are
+// told apart to the microsecond so the user can log in again right away.
+func (s *Store) RevokeUser(ctx context.Context, userID string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.revocation.RevokeUser")
+	defer span.End()
+
+	// Postgres keeps timestamps to the microsecond.
+	now = now.Truncate(time.Microsecond)
+
+	const q = `INSERT INTO revoked_users
+		(user_id, revoked_before, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			revoked_before = EXCLUDED.revoked_before,
+			expires_at = EXCLUDED.expires_at`
+	if _, err := s.db.ExecContext(ctx, q, userID, now.UTC(), now.Add(s.tokenTTL).UTC()); err != nil {
+		return errors.Wrapf(err, "revoking tokens of user %s", userID)
+	}
+
+	s.mu.Lock()
+	s.users[userID] = now
+	s.mu.Unlock()
+
+	return nil
+}
+
+// stale reports whether the cache is older than maxAge.
+func (s *Store) stale() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return time.Since(s.synced) > s.maxAge
+}
+
+// sync merges the revocations stored in the database into the cache. Requests
+// finding the cache stale while another one refreshes it wait for it rather
+// than querying too. Revocations past their expiry are dropped as the tokens
+// they cover are invalid anyway.
+func (s *Store) sync(ctx context.Context) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if !s.stale() {
+		return nil
+	}
+
+	now := time.Now()
+
+	var tokens []struct {
+		JTI       string    `db:"jti"`
+		UserID    string    `db:"user_id"`
+		ExpiresAt time.Time `db:"expires_at"`
+	}
+	const qt = `SELECT jti, user_id, expires_at FROM revoked_tokens WHERE expires_at > $1`
+	if err := s.db.SelectContext(ctx, &tokens, qt, now.UTC()); err != nil {
+		return errors.Wrap(err, "selecting revoked tokens")
+	}
+
+	var sessions []struct {
+		SessionID string    `db:"session_id"`
+		ExpiresAt time.Time `db:"expires_at"`
+	}
+	const qs = `SELECT session_id, expires_at FROM revoked_sessions WHERE expires_at > $1`
+	if err := s.db.SelectContext(ctx, &sessions, qs, now.UTC()); err != nil {
+		return errors.Wrap(err, "selecting revoked sessions")
+	}
+
+	var users []struct {
+		UserID        string    `db:"user_id"`
+		RevokedBefore time.Time `db:"revoked_before"`
+	}
+	const qu = `SELECT user_id, revoked_before FROM revoked_users WHERE expires_at > $1`
+	if err := s.db.SelectContext(ctx, &users, qu, now.UTC()); err != nil {
+		return errors.Wrap(err, "selecting revoked users")
+	}
+
+	// Revocations made by this instance while the queries ran may be missing
+	// from their results so the cache is merged into rather than replaced.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range tokens {
+		k := token{jti: t.JTI, userID: t.UserID}
+		if t.ExpiresAt.After(s.tokens[k]) {
+			s.tokens[k] = t.ExpiresAt
+		}
+	}
+	for t, expiresAt := range s.tokens {
+		if !expiresAt.After(now) {
+			delete(s.tokens, t)
+		}
+	}
+	for _, ss := range sessions {
+		s.sessions[ss.SessionID] = ss.ExpiresAt
+	}
+	for id, expiresAt := range s.sessions {
+		if !expiresAt.After(now) {
+			delete(s.sessions, id)
+		}
+	}
+	for _, u := range users {
+		if u.RevokedBefore.After(s.users[u.UserID]) {
+			s.users[u.UserID] = u.RevokedBefore
+		}
+	}
+	for id, before := range s.users {
+		if !before.Add(s.tokenTTL).After(now) {
+			delete(s.users, id)
+		}
+	}
+	s.synced = now
+
+	return nil
+}
+
+// Purge removes the revocations that expired before the time. The tokens they
+// cover are invalid anyway.
+func Purge(ctx context.Context, db *sqlx.DB, before time.Time) (int64, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.revocation.Purge")
+	defer span.End()
+
+	var purged int64
+	for _, table := range []string{"revoked_tokens", "revoked_sessions", "revoked_users"} {
+		res, err := db.ExecContext(ctx, `DELETE FROM `+table+` WHERE expires_at < $1`, before.UTC())
+		if err != nil {
+			return purged, errors.Wrapf(err, "purging %s", table)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return purged, errors.Wrapf(err, "purging %s", table)
+		}
+		purged += n
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,163 @@
+package revocation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/revocation"
+	"github.com/sankarvj/seedgo/internal/tests"
+)
+
+// TestRevocation validates tokens can be revoked by their jti, by their
+// session and all at once for a user, and that every instance sees it.
+func TestRevocation(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to revoke access tokens.")
+	{
+		ctx := tests.Context()
+		now := time.Now()
+		store := revocation.NewStore(db, time.Hour, time.Hour)
+
+		// revoked reports whether the store rejects the claims.
+		revoked := func(s *revocation.Store, c auth.Claims) bool {
+			t.Helper()
+			r, err := s.IsRevoked(ctx, c)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to check the token : %s.", tests.Failed, err)
+			}
+			return r
+		}
+
+		t.Log("\tWhen revoking a single token.")
+		{
+			userID := uuid.New().String()
+			c := auth.NewClaims(userID, []string{auth.RoleUser}, now, time.Hour)
+			other := auth.NewClaims(userID, []string{auth.RoleUser}, now, time.Hour)
+
+			if revoked(store, c) {
+				t.Fatalf("\t%s\tShould accept a token that is not revoked.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould accept a token that is not revoked.", tests.Success)
+
+			if err := store.RevokeToken(ctx, c.Id, userID, time.Unix(c.ExpiresAt, 0), now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the token : %s.", tests.Failed, err)
+			}
+			if !revoked(store, c) || revoked(store, other) {
+				t.Fatalf("\t%s\tShould reject only the revoked token.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould reject only the revoked token.", tests.Success)
+
+			stolen := c
+			stolen.Subject = uuid.New().String()
+			stolen.Id = uuid.New().String()
+			if err := store.RevokeToken(ctx, stolen.Id, userID, time.Unix(c.ExpiresAt, 0), now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the token : %s.", tests.Failed, err)
+			}
+			if revoked(store, stolen) {
+				t.Fatalf("\t%s\tShould NOT reject a token issued to another user.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould NOT reject a token issued to another user.", tests.Success)
+
+			if err := store.RevokeToken(ctx, stolen.Id, stolen.Subject, time.Unix(c.ExpiresAt, 0), now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the token : %s.", tests.Failed, err)
+			}
+			if !revoked(store, stolen) || !revoked(revocation.NewStore(db, time.Hour, time.Hour), stolen) {
+				t.Fatalf("\t%s\tShould reject the token once revoked for its own user everywhere.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould reject the token once revoked for its own user everywhere.", tests.Success)
+		}
+
+		t.Log("\tWhen revoking a session.")
+		{
+			c := auth.NewClaims(uuid.New().String(), []string{auth.RoleUser}, now, time.Hour)
+			c.SessionID = uuid.New().String()
+			refreshed := auth.NewClaims(c.Subject, c.Roles, now.Add(time.Minute), time.Hour)
+			refreshed.SessionID = c.SessionID
+
+			if err := store.RevokeSession(ctx, c.SessionID, now.Add(time.Hour), now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the session : %s.", tests.Failed, err)
+			}
+			if !revoked(store, c) || !revoked(store, refreshed) {
+				t.Fatalf("\t%s\tShould reject every token of the session.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould reject every token of the session.", tests.Success)
+		}
+
+		t.Log("\tWhen revoking every token of a user.")
+		{
+			userID := uuid.New().String()
+			at := now.Truncate(time.Second).Add(500 * time.Millisecond)
+
+			before := auth.NewClaims(userID, []string{auth.RoleUser}, at.Add(-100*time.Millisecond), time.Hour)
+			impersonated := auth.NewClaims(uuid.New().String(), []string{auth.RoleUser}, at.Add(-time.Minute), time.Hour)
+			impersonated.Actor = &auth.Actor{Subject: userID}
+
+			if err := store.RevokeUser(ctx, userID, at); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the tokens of the user : %s.", tests.Failed, err)
+			}
+			if !revoked(store, before) {
+				t.Fatalf("\t%s\tShould reject a token issued earlier in the same second.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould reject a token issued earlier in the same second.", tests.Success)
+
+			if !revoked(store, impersonated) {
+				t.Fatalf("\t%s\tShould reject the impersonations of the user.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould reject the impersonations of the user.", tests.Success)
+
+			after := auth.NewClaims(userID, []string{auth.RoleUser}, at.Add(100*time.Millisecond), time.Hour)
+			if after.IssuedAt != before.IssuedAt {
+				t.Fatalf("\t%s\tShould issue both tokens in the same second.", tests.Failed)
+			}
+			if revoked(store, after) {
+				t.Fatalf("\t%s\tShould accept a token issued later in the same second.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould accept a token issued later in the same second.", tests.Success)
+
+			other := revocation.NewStore(db, time.Hour, time.Hour)
+			if !revoked(other, before) || revoked(other, after) {
+				t.Fatalf("\t%s\tShould see the revocation from another instance to the microsecond.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould see the revocation from another instance to the microsecond.", tests.Success)
+		}
+
+		t.Log("\tWhen the cache is refreshed from the database.")
+		{
+			c := auth.NewClaims(uuid.New().String(), []string{auth.RoleUser}, now, time.Hour)
+			synced := revocation.NewStore(db, 0, time.Hour)
+
+			if err := synced.RevokeToken(ctx, c.Id, c.Subject, time.Unix(c.ExpiresAt, 0), now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the token : %s.", tests.Failed, err)
+			}
+			if !revoked(synced, c) || !revoked(synced, c) {
+				t.Fatalf("\t%s\tShould keep rejecting the token across refreshes.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould keep rejecting the token across refreshes.", tests.Success)
+		}
+
+		t.Log("\tWhen purging the revocations that expired.")
+		{
+			live := auth.NewClaims(uuid.New().String(), []string{auth.RoleUser}, now, time.Hour)
+			if err := store.RevokeToken(ctx, live.Id, live.Subject, time.Unix(live.ExpiresAt, 0), now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the token : %s.", tests.Failed, err)
+			}
+			expired := auth.NewClaims(uuid.New().String(), []string{auth.RoleUser}, now.Add(-2*time.Hour), time.Hour)
+			if err := store.RevokeToken(ctx, expired.Id, expired.Subject, time.Unix(expired.ExpiresAt, 0), now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the token : %s.", tests.Failed, err)
+			}
+
+			n, err := revocation.Purge(ctx, db, now)
+			if err != nil || n != 1 {
+				t.Fatalf("\t%s\tShould purge the expired revocation only : purged %d : %v.", tests.Failed, n, err)
+			}
+			if !revoked(revocation.NewStore(db, time.Hour, time.Hour), live) {
+				t.Fatalf("\t%s\tShould keep rejecting the token of a live revocation.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould purge the expired revocation only.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package role
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Role is a named set of permissions. System roles have no account and are
+// available to every account. Every other role belongs to a single account.
+type Role struct {
+	ID          string         `db:"role_id" json:"id"`
+	AccountID   *string        `db:"account_id" json:"account_id"`
+	Name        string         `db:"name" json:"name"`
+	Permissions pq.StringArray `db:"permissions" json:"permissions"`
+	CreatedAt   time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt   int64          `db:"updated_at" json:"updated_at"`
+}
+
+// NewRole contains information needed to create a new Role.
+type NewRole struct {
+	Name        string   `json:"name" validate:"required"`
+	Permissions []string `json:"permissions" validate:"required"`
+}
+
+// UpdateRole defines what information may be provided to modify an existing
+// Role. All fields are optional so clients can send just the fields they want
+// changed.
+type UpdateRole struct {
+	Name        *string  `json:"name"`
+	Permissions []string `json:"permissions"`
+}
@@ -0,0 +1,307 @@
+package role
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/policy"
+	"go.opencensus.io/trace"
+)
+
+var (
+	// ErrNotFound is used when a specific Role is requested but does not exist.
+	ErrNotFound = errors.New("Role not found")
+
+	// ErrInvalidID occurs when an ID is not in a valid form.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrForbidden occurs when a system role is modified.
+	ErrForbidden = errors.New("System roles cannot be modified")
+
+	// ErrNameTaken occurs when a role is given the name of another role
+	// available to the account.
+	ErrNameTaken = errors.New("Role name is already in use")
+
+	// ErrInvalidPermission occurs when a role is given an unknown permission.
+	ErrInvalidPermission = errors.New("Unknown permission")
+
+	// ErrInUse occurs when a role held by users is renamed or removed. Users
+	// refer to their roles by name so the role would be lost to them.
+	ErrInUse = errors.New("Role is held by users")
+
+	// ErrPermissionNotHeld occurs when a role is given a permission its
+	// creator does not hold.
+	ErrPermissionNotHeld = errors.New("Permission is not held by the caller")
+
+	// ErrOwnRole occurs when a caller other than a superadmin modifies a role
+	// they hold, which would change their own access.
+	ErrOwnRole = errors.New("Roles held by the caller cannot be modified")
+)
+
+// List retrieves the system roles and the roles of the caller's account.
+func List(ctx context.Context, claims auth.Claims, db *sqlx.DB) ([]Role, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.role.List")
+	defer span.End()
+
+	if err := policy.Authorize(claims, policy.RoleList, policy.Resource{}); err != nil {
+		return nil, err
+	}
+
+	roles := []Role{}
+	const q = `SELECT * FROM roles WHERE account_id IS NULL OR account_id = $1 ORDER BY name`
+
+	if err := db.SelectContext(ctx, &roles, q, claims.AccountID); err != nil {
+		return nil, errors.Wrap(err, "selecting roles")
+	}
+
+	return roles, nil
+}
+
+// Retrieve gets the specified role if it is available to the caller's
+// account.
+func Retrieve(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) (*Role, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.role.Retrieve")
+	defer span.End()
+
+	return retrieveFor(ctx, claims, db, id, policy.RoleRead)
+}
+
+// retrieveFor gets the specified role if it is available to the caller's
+// account and the policy allows the action on it.
+func retrieveFor(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, action policy.Action) (*Role, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var r Role
+	const q = `SELECT * FROM roles WHERE role_id = $1`
+	if err := db.GetContext(ctx, &r, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+
+		return nil, errors.Wrapf(err, "selecting role %q", id)
+	}
+
+	var res policy.Resource
+	if r.AccountID != nil {
+		res.AccountID = *r.AccountID
+	}
+	switch err := policy.Authorize(claims, action, res); err {
+	case nil:
+		return &r, nil
+	case policy.ErrOutsideTenant:
+		return nil, ErrNotFound
+	default:
+		return nil, err
+	}
+}
+
+// Create inserts a new role for the caller's account. A role cannot be given
+// a permission the caller does not hold.
+func Create(ctx context.Context, claims auth.Claims, db *sqlx.DB, n NewRole, now time.Time) (*Role, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.role.Create")
+	defer span.End()
+
+	accountID := claims.AccountID
+	if err := policy.Authorize(claims, policy.RoleCreate, policy.Resource{AccountID: accountID}); err != nil {
+		return nil, err
+	}
+
+	if err := validatePermissions(claims, n.Permissions); err != nil {
+		return nil, err
+	}
+	if err := checkName(ctx, db, accountID, "", n.Name); err != nil {
+		return nil, err
+	}
+
+	r := Role{
+		ID:          uuid.New().String(),
+		AccountID:   &accountID,
+		Name:        n.Name,
+		Permissions: n.Permissions,
+		CreatedAt:   now.UTC(),
+		UpdatedAt:   now.UTC().Unix(),
+	}
+
+	const q = `INSERT INTO roles
+		(role_id, account_id, name, permissions, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := db.ExecContext(
+		ctx, q,
+		r.ID, r.AccountID, r.Name, r.Permissions,
+		r.CreatedAt, r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting role")
+	}
+
+	return &r, nil
+}
+
+// Update modifies a role of the caller's account. System roles cannot be
+// modified and roles held by users cannot be renamed. Only superadmins may
+// modify a role they hold and a role cannot be given a permission the caller
+// does not hold.
+func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, upd UpdateRole, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.role.Update")
+	defer span.End()
+
+	r, err := retrieveFor(ctx, claims, db, id, policy.RoleUpdate)
+	if err != nil {
+		return err
+	}
+	if r.AccountID == nil {
+		return ErrForbidden
+	}
+	if claims.HasRole(r.Name) && !claims.HasRole(auth.RoleSuperAdmin) {
+		return ErrOwnRole
+	}
+
+	name := r.Name
+	if upd.Name != nil && *upd.Name != r.Name {
+		if err := checkName(ctx, db, *r.AccountID, id, *upd.Name); err != nil {
+			return err
+		}
+		r.Name = *upd.Name
+	}
+	if upd.Permissions != nil {
+		if err := validatePermissions(claims, upd.Permissions); err != nil {
+			return err
+		}
+		r.Permissions = upd.Permissions
+	}
+
+	r.UpdatedAt = now.Unix()
+
+	// The role is renamed only if no user holds it by its current name.
+	const q = `UPDATE roles SET
+		"name" = $2,
+		"permissions" = $3,
+		"updated_at" = $4
+		WHERE role_id = $1 AND ($2 = $5 OR NOT EXISTS (
+			SELECT 1 FROM users WHERE users.account_id = roles.account_id AND $5 = ANY(users.roles)
+		))`
+	res, err := db.ExecContext(ctx, q, id, r.Name, r.Permissions, r.UpdatedAt, name)
+	if err != nil {
+		return errors.Wrap(err, "updating role")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrInUse
+	}
+
+	return nil
+}
+
+// Delete removes a role of the caller's account. System roles and roles held
+// by users, deleted or not, cannot be removed.
+func Delete(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) error {
+	ctx, span := trace.StartSpan(ctx, "internal.role.Delete")
+	defer span.End()
+
+	r, err := retrieveFor(ctx, claims, db, id, policy.RoleDelete)
+	if err != nil {
+		return err
+	}
+	if r.AccountID == nil {
+		return ErrForbidden
+	}
+
+	const q = `DELETE FROM roles WHERE role_id = $1 AND NOT EXISTS (
+		SELECT 1 FROM users WHERE users.account_id = roles.account_id AND roles.name = ANY(users.roles)
+	)`
+	res, err := db.ExecContext(ctx, q, id)
+	if err != nil {
+		return errors.Wrapf(err, "deleting role %s", id)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrInUse
+	}
+
+	return nil
+}
+
+// Exist returns ErrNotFound unless every named role is available to the
+// account.
+func Exist(ctx context.Context, db *sqlx.DB, accountID string, names []string) error {
+	ctx, span := trace.StartSpan(ctx, "internal.role.Exist")
+	defer span.End()
+
+	var found []string
+	const q = `SELECT name FROM roles WHERE name = ANY($1) AND (account_id IS NULL OR account_id = $2)`
+	if err := db.SelectContext(ctx, &found, q, pq.StringArray(names), accountID); err != nil {
+		return errors.Wrap(err, "selecting roles")
+	}
+
+	set := make(map[string]bool, len(found))
+	for _, n := range found {
+		set[n] = true
+	}
+	for _, n := range names {
+		if !set[n] {
+			return ErrNotFound
+		}
+	}
+
+	return nil
+}
+
+// Permissions resolves the permissions granted by the named roles in the
+// account. Roles that no longer exist grant nothing.
+func Permissions(ctx context.Context, db *sqlx.DB, accountID string, names []string) ([]string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.role.Permissions")
+	defer span.End()
+
+	perms := []string{}
+	const q = `SELECT DISTINCT unnest(permissions) FROM roles
+		WHERE name = ANY($1) AND (account_id IS NULL OR account_id = $2)
+		ORDER BY 1`
+	if err := db.SelectContext(ctx, &perms, q, pq.StringArray(names), accountID); err != nil {
+		return nil, errors.Wrap(err, "resolving permissions")
+	}
+
+	return perms, nil
+}
+
+// checkName returns ErrNameTaken if a role other than the one with the
+// specified id already uses the name in the account.
+func checkName(ctx context.Context, db *sqlx.DB, accountID, id, name string) error {
+	var n int
+	const q = `SELECT count(*) FROM roles
+		WHERE name = $1 AND (account_id IS NULL OR account_id = $2) AND role_id::text <> $3`
+	if err := db.GetContext(ctx, &n, q, name, accountID, id); err != nil {
+		return errors.Wrap(err, "checking role name")
+	}
+	if n > 0 {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+// validatePermissions returns ErrInvalidPermission unless every permission is
+// one of auth.Permissions and ErrPermissionNotHeld unless the claims hold them
+// all.
+func validatePermissions(claims auth.Claims, perms []string) error {
+	for _, p := range perms {
+		var ok bool
+		for _, known := range auth.Permissions {
+			if p == known {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return ErrInvalidPermission
+		}
+	}
+	if !claims.HasPermission(perms...) {
+		return ErrPermissionNotHeld
+	}
+	return nil
+}
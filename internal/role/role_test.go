@@ -0,0 +1,174 @@
+package role_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/account"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/role"
+	"github.com/sankarvj/seedgo/internal/tests"
+	"github.com/sankarvj/seedgo/internal/user"
+)
+
+// TestRole validates the full set of CRUD operations on Role values and the
+// lookups resolving the roles of users.
+func TestRole(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to work with Role records.")
+	{
+		t.Log("\tWhen handling the roles of an account.")
+		{
+			ctx := tests.Context()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			a, err := account.Create(ctx, db, account.NewAccount{Name: "Wayplot", Domain: "Wayplot"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to create account.", tests.Success)
+
+			// claims is information about the admin making the requests.
+			claims := auth.NewClaims(
+				"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+				[]string{auth.RoleAdmin},
+				now, time.Hour,
+			)
+			claims.AccountID = a.ID
+
+			nr := role.NewRole{Name: "support", Permissions: []string{auth.PermUsersRead}}
+			r, err := role.Create(ctx, claims, db, nr, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create role : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to create role.", tests.Success)
+
+			saved, err := role.Retrieve(ctx, claims, db, r.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to retrieve role by ID : %s.", tests.Failed, err)
+			}
+			if diff := cmp.Diff(r, saved); diff != "" {
+				t.Fatalf("\t%s\tShould get back the same role. Diff:\n%s", tests.Failed, diff)
+			}
+			t.Logf("\t%s\tShould get back the same role.", tests.Success)
+
+			other := claims
+			other.AccountID = "a9b8b1f0-66b4-4b0e-9c1f-3e1a0d3f5c11" // Some other account.
+			if _, err := role.Retrieve(ctx, other, db, r.ID); errors.Cause(err) != role.ErrNotFound {
+				t.Fatalf("\t%s\tShould NOT be able to retrieve role from another account : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to retrieve role from another account.", tests.Success)
+
+			for _, name := range []string{"support", auth.RoleAdmin} {
+				if _, err := role.Create(ctx, claims, db, role.NewRole{Name: name, Permissions: []string{auth.PermUsersRead}}, now); err != role.ErrNameTaken {
+					t.Fatalf("\t%s\tShould NOT be able to reuse the name %q : %v.", tests.Failed, name, err)
+				}
+			}
+			t.Logf("\t%s\tShould NOT be able to reuse the name of a role of the account or of the system.", tests.Success)
+
+			if _, err := role.Create(ctx, claims, db, role.NewRole{Name: "bogus", Permissions: []string{"bogus:write"}}, now); err != role.ErrInvalidPermission {
+				t.Fatalf("\t%s\tShould NOT be able to create a role with an unknown permission : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to create a role with an unknown permission.", tests.Success)
+
+			// limited may manage roles but holds no other permission.
+			limited := claims
+			limited.Roles = []string{"support"}
+			limited.Permissions = []string{auth.PermRolesRead, auth.PermRolesWrite, auth.PermUsersRead}
+			if _, err := role.Create(ctx, limited, db, role.NewRole{Name: "owner", Permissions: []string{auth.PermAccountsWrite}}, now); err != role.ErrPermissionNotHeld {
+				t.Fatalf("\t%s\tShould NOT be able to grant a permission not held : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to grant a permission not held.", tests.Success)
+
+			upd := role.UpdateRole{Permissions: []string{auth.PermUsersRead, auth.PermUsersWrite}}
+			if err := role.Update(ctx, limited, db, r.ID, upd, now); err != role.ErrOwnRole {
+				t.Fatalf("\t%s\tShould NOT be able to modify a role held : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to modify a role held.", tests.Success)
+
+			roles, err := role.List(ctx, claims, db)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to list roles : %s.", tests.Failed, err)
+			}
+			var system *role.Role
+			var names []string
+			for i := range roles {
+				names = append(names, roles[i].Name)
+				if roles[i].Name == auth.RoleUser {
+					system = &roles[i]
+				}
+			}
+			if exp := []string{auth.RoleAdmin, auth.RoleSuperAdmin, auth.RoleUser, "support"}; !cmp.Equal(names, exp) {
+				t.Fatalf("\t%s\tShould list the system roles and the roles of the account : got %v, want %v.", tests.Failed, names, exp)
+			}
+			t.Logf("\t%s\tShould list the system roles and the roles of the account.", tests.Success)
+
+			if err := role.Update(ctx, claims, db, system.ID, upd, now); err != role.ErrForbidden {
+				t.Fatalf("\t%s\tShould NOT be able to modify a system role : %v.", tests.Failed, err)
+			}
+			if err := role.Delete(ctx, claims, db, system.ID); err != role.ErrForbidden {
+				t.Fatalf("\t%s\tShould NOT be able to delete a system role : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to modify or delete a system role.", tests.Success)
+
+			if err := role.Exist(ctx, db, a.ID, []string{"support", auth.RoleUser}); err != nil {
+				t.Fatalf("\t%s\tShould find the roles available to the account : %s.", tests.Failed, err)
+			}
+			if err := role.Exist(ctx, db, a.ID, []string{"support", "missing"}); err != role.ErrNotFound {
+				t.Fatalf("\t%s\tShould NOT find a role that does not exist : %v.", tests.Failed, err)
+			}
+			if err := role.Exist(ctx, db, other.AccountID, []string{"support"}); err != role.ErrNotFound {
+				t.Fatalf("\t%s\tShould NOT find a role of another account : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould find only the roles available to the account.", tests.Success)
+
+			if err := role.Update(ctx, claims, db, r.ID, upd, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to update role : %s.", tests.Failed, err)
+			}
+			perms, err := role.Permissions(ctx, db, a.ID, []string{"support", auth.RoleUser, "missing"})
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to resolve permissions : %s.", tests.Failed, err)
+			}
+			if exp := []string{auth.PermAccountsRead, auth.PermUsersRead, auth.PermUsersWrite}; !cmp.Equal(perms, exp) {
+				t.Fatalf("\t%s\tShould resolve the permissions of the roles : got %v, want %v.", tests.Failed, perms, exp)
+			}
+			t.Logf("\t%s\tShould resolve the permissions of the updated roles.", tests.Success)
+
+			nu := user.NewUser{
+				AccountID:       a.ID,
+				Name:            "Anna Walker",
+				Email:           "anna@ardanlabs.com",
+				Roles:           []string{"support"},
+				Password:        "gophers",
+				PasswordConfirm: "gophers",
+			}
+			if _, err := user.Create(ctx, claims, db, nu, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+			}
+			name := "helpdesk"
+			if err := role.Update(ctx, claims, db, r.ID, role.UpdateRole{Name: &name}, now); err != role.ErrInUse {
+				t.Fatalf("\t%s\tShould NOT be able to rename a role held by users : %v.", tests.Failed, err)
+			}
+			if err := role.Delete(ctx, claims, db, r.ID); err != role.ErrInUse {
+				t.Fatalf("\t%s\tShould NOT be able to delete a role held by users : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to rename or delete a role held by users.", tests.Success)
+
+			unused, err := role.Create(ctx, claims, db, role.NewRole{Name: "unused", Permissions: []string{auth.PermUsersRead}}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create role : %s.", tests.Failed, err)
+			}
+			if err := role.Delete(ctx, claims, db, unused.ID); err != nil {
+				t.Fatalf("\t%s\tShould be able to delete role : %s.", tests.Failed, err)
+			}
+			if _, err := role.Retrieve(ctx, claims, db, unused.ID); errors.Cause(err) != role.ErrNotFound {
+				t.Fatalf("\t%s\tShould NOT be able to retrieve a deleted role : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to delete role.", tests.Success)
+		}
+	}
+}
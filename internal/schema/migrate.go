@@ -70,4 +70,220 @@ var migrations = []darwin.Migration{
 		);
 		`,
 	},
+	{
+		Version:     3,
+		Description: "Add refresh tokens",
+		Script: `
+		CREATE TABLE refresh_tokens (
+			token_id      UUID,
+			family_id     UUID,
+			user_id       UUID REFERENCES users ON DELETE CASCADE,
+			token_hash    TEXT UNIQUE,
+			expires_at    TIMESTAMP,
+			used_at       TIMESTAMP,
+			revoked_at    TIMESTAMP,
+			created_at    TIMESTAMP,
+			PRIMARY KEY (token_id)
+		);
+		CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family_id);
+		CREATE INDEX refresh_tokens_user_idx ON refresh_tokens (user_id);
+		`,
+	},
+	{
+		Version:     4,
+		Description: "Add token revocations",
+		Script: `
+		CREATE TABLE revoked_tokens (
+			jti           TEXT,
+			user_id       TEXT,
+			expires_at    TIMESTAMP,
+			revoked_at    TIMESTAMP,
+			PRIMARY KEY (jti)
+		);
+		CREATE TABLE revoked_users (
+			user_id        UUID,
+			revoked_before TIMESTAMP,
+			PRIMARY KEY (user_id)
+		);
+		`,
+	},
+	{
+		Version:     5,
+		Description: "Separate provider credentials from passwords",
+		Script: `
+		ALTER TABLE users ADD COLUMN provider_uid TEXT;
+		UPDATE users SET provider_uid = password_hash, password_hash = NULL
+			WHERE provider IS NOT NULL AND password_hash NOT LIKE '$2%';
+		CREATE UNIQUE INDEX users_provider_uid_idx ON users (provider, provider_uid);
+		`,
+	},
+	{
+		Version:     6,
+		Description: "Add roles",
+		Script: `
+		CREATE TABLE roles (
+			role_id       UUID,
+			account_id    UUID REFERENCES accounts ON DELETE CASCADE,
+			name          TEXT,
+			permissions   TEXT[],
+			created_at    TIMESTAMP,
+			updated_at    BIGINT,
+			PRIMARY KEY (role_id),
+			UNIQUE (account_id, name)
+		);
+		CREATE UNIQUE INDEX roles_system_name_idx ON roles (name) WHERE account_id IS NULL;
+		INSERT INTO roles (role_id, account_id, name, permissions, created_at, updated_at) VALUES
+			('8a4c6f8e-5b5e-4f43-9a52-0f3b6d9c1a01', NULL, 'ADMIN', '{users:read,users:write,accounts:read,roles:read,roles:write}', now(), EXTRACT(EPOCH FROM now())::BIGINT),
+			('8a4c6f8e-5b5e-4f43-9a52-0f3b6d9c1a02', NULL, 'USER', '{accounts:read}', now(), EXTRACT(EPOCH FROM now())::BIGINT);
+		`,
+	},
+	{
+		Version:     7,
+		Description: "Add superadmin role",
+		Script: `
+		INSERT INTO roles (role_id, account_id, name, permissions, created_at, updated_at) VALUES
+			('8a4c6f8e-5b5e-4f43-9a52-0f3b6d9c1a03', NULL, 'SUPERADMIN', '{users:read,users:write,accounts:read,roles:read,roles:write}', now(), EXTRACT(EPOCH FROM now())::BIGINT);
+		`,
+	},
+	{
+		Version:     8,
+		Description: "Add api keys",
+		Script: `
+		CREATE TABLE api_keys (
+			key_id        UUID,
+			account_id    UUID REFERENCES accounts ON DELETE CASCADE,
+			name          TEXT,
+			prefix        TEXT,
+			key_hash      TEXT,
+			scopes        TEXT[],
+			created_by    TEXT,
+			expires_at    TIMESTAMP,
+			last_used_at  TIMESTAMP,
+			revoked_at    TIMESTAMP,
+			created_at    TIMESTAMP,
+			PRIMARY KEY (key_id),
+			UNIQUE (key_hash)
+		);
+		CREATE INDEX api_keys_account_idx ON api_keys (account_id);
+		UPDATE roles SET permissions = permissions || '{apikeys:read,apikeys:write}'
+			WHERE account_id IS NULL AND name IN ('ADMIN', 'SUPERADMIN');
+		`,
+	},
+	{
+		Version:     9,
+		Description: "Add password resets",
+		Script: `
+		CREATE TABLE password_resets (
+			token_id      UUID,
+			user_id       UUID REFERENCES users ON DELETE CASCADE,
+			token_hash    TEXT UNIQUE,
+			expires_at    TIMESTAMP,
+			used_at       TIMESTAMP,
+			created_at    TIMESTAMP,
+			PRIMARY KEY (token_id)
+		);
+		CREATE INDEX password_resets_user_idx ON password_resets (user_id);
+		`,
+	},
+	{
+		Version:     10,
+		Description: "Add two-factor authentication",
+		Script: `
+		CREATE TABLE user_mfa (
+			user_id       UUID REFERENCES users ON DELETE CASCADE,
+			secret        TEXT,
+			last_step     BIGINT DEFAULT 0,
+			confirmed_at  TIMESTAMP,
+			created_at    TIMESTAMP,
+			PRIMARY KEY (user_id)
+		);
+		CREATE TABLE mfa_recovery_codes (
+			code_hash     TEXT,
+			user_id       UUID REFERENCES users ON DELETE CASCADE,
+			used_at       TIMESTAMP,
+			PRIMARY KEY (code_hash)
+		);
+		CREATE INDEX mfa_recovery_codes_user_idx ON mfa_recovery_codes (user_id);
+		CREATE TABLE mfa_challenges (
+			challenge_hash TEXT,
+			user_id        UUID REFERENCES users ON DELETE CASCADE,
+			attempts       INT DEFAULT 0,
+			expires_at     TIMESTAMP,
+			used_at        TIMESTAMP,
+			created_at     TIMESTAMP,
+			PRIMARY KEY (challenge_hash)
+		);
+		ALTER TABLE accounts ADD COLUMN mfa_required_for_admins BOOLEAN NOT NULL DEFAULT FALSE;
+		UPDATE roles SET permissions = permissions || '{accounts:write}'
+			WHERE account_id IS NULL AND name IN ('ADMIN', 'SUPERADMIN');
+		`,
+	},
+	{
+		Version:     11,
+		Description: "Add sessions",
+		Script: `
+		CREATE TABLE sessions (
+			session_id    UUID,
+			user_id       UUID REFERENCES users ON DELETE CASCADE,
+			device        TEXT,
+			ip            TEXT,
+			user_agent    TEXT,
+			created_at    TIMESTAMP,
+			last_seen_at  TIMESTAMP,
+			terminated_at TIMESTAMP,
+			PRIMARY KEY (session_id)
+		);
+		CREATE INDEX sessions_user_idx ON sessions (user_id);
+		CREATE TABLE revoked_sessions (
+			session_id    TEXT,
+			expires_at    TIMESTAMP,
+			revoked_at    TIMESTAMP,
+			PRIMARY KEY (session_id)
+		);
+		`,
+	},
+	{
+		Version:     12,
+		Description: "Index users for listing",
+		Script: `
+		CREATE INDEX users_account_created_idx ON users (account_id, created_at, user_id);
+		`,
+	},
+	{
+		Version:     13,
+		Description: "Add soft delete",
+		Script: `
+		ALTER TABLE accounts ADD COLUMN deleted_at TIMESTAMP;
+		ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+		CREATE INDEX accounts_deleted_idx ON accounts (deleted_at) WHERE deleted_at IS NOT NULL;
+		CREATE INDEX users_deleted_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+		`,
+	},
+	{
+		Version:     14,
+		Description: "Add user versions",
+		Script: `
+		ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+		`,
+	},
+	{
+		Version:     15,
+		Description: "Key token revocations by user",
+		Script: `
+		UPDATE revoked_tokens SET user_id = '' WHERE user_id IS NULL;
+		ALTER TABLE revoked_tokens DROP CONSTRAINT revoked_tokens_pkey;
+		ALTER TABLE revoked_tokens ADD PRIMARY KEY (jti, user_id);
+		`,
+	},
+	{
+		Version:     16,
+		Description: "Expire user revocations",
+		Script: `
+		ALTER TABLE revoked_users ADD COLUMN expires_at TIMESTAMP;
+		UPDATE revoked_users SET expires_at = revoked_before + INTERVAL '24 hours';
+		CREATE INDEX revoked_tokens_expires_idx ON revoked_tokens (expires_at);
+		CREATE INDEX revoked_sessions_expires_idx ON revoked_sessions (expires_at);
+		CREATE INDEX revoked_users_expires_idx ON revoked_users (expires_at);
+		`,
+	},
 }
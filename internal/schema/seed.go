@@ -34,8 +34,8 @@ INSERT INTO accounts (account_id, name, domain, avatar, plan, mode, timezone, la
 	('3cf27266-3473-4006-984f-9325122678b7', 'Wayplot', 'Wayplot', 'http://gravatar/vj', 0, 0, 'IST', 'EN', 'IN', '2019-11-20 00:00:00', '2020-11-20 00:00:00', '2019-11-20 00:00:00', 1574239364000)
 	ON CONFLICT DO NOTHING;
 -- Create admin and regular User with password "gophers"
-INSERT INTO users (user_id, account_id, name, avatar, email, phone, verified, roles, password_hash, provider, issued_at, created_at, updated_at) VALUES
-	('5cf37266-3473-4006-984f-9325122678b7', '3cf27266-3473-4006-984f-9325122678b7', 'vijayasankar', 'http://gravatar/vj', 'vijayasankarmail@gmail.com', '9944293499', true, '{ADMIN,USER}', 'cfr07IBEBCfGxp9dxjBOGYdkjHG2', 'firebase', '2019-11-20 00:00:00', '2019-11-20 00:00:00', 1574239364000),
-	('45b5fbd3-755f-4379-8f07-a58d4a30fa2f', '3cf27266-3473-4006-984f-9325122678b7', 'vijay', 'http://gravatar/vj', 'vijayasankarj@gmail.com', '9940209164', true, '{USER}', 'ggOv3mMCqVZ6nFqaco4lD9qjxc63', 'firebase', '2019-11-20 00:00:00', '2019-11-20 00:00:00', 1574239364000)
+INSERT INTO users (user_id, account_id, name, avatar, email, phone, verified, roles, password_hash, provider, provider_uid, issued_at, created_at, updated_at) VALUES
+	('5cf37266-3473-4006-984f-9325122678b7', '3cf27266-3473-4006-984f-9325122678b7', 'vijayasankar', 'http://gravatar/vj', 'vijayasankarmail@gmail.com', '9944293499', true, '{ADMIN,USER}', '$2a$10$reGQqQ4xwp1JXFgC5BiAveapMuBit03LVd7OLGZaVRDgC2KS5EczK', 'firebase', 'cfr07IBEBCfGxp9dxjBOGYdkjHG2', '2019-11-20 00:00:00', '2019-11-20 00:00:00', 1574239364000),
+	('45b5fbd3-755f-4379-8f07-a58d4a30fa2f', '3cf27266-3473-4006-984f-9325122678b7', 'vijay', 'http://gravatar/vj', 'vijayasankarj@gmail.com', '9940209164', true, '{USER}', '$2a$10$reGQqQ4xwp1JXFgC5BiAveapMuBit03LVd7OLGZaVRDgC2KS5EczK', 'firebase', 'ggOv3mMCqVZ6nFqaco4lD9qjxc63', '2019-11-20 00:00:00', '2019-11-20 00:00:00', 1574239364000)
 	ON CONFLICT DO NOTHING;
 `
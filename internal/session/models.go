@@ -0,0 +1,26 @@
+package session
+
+import (
+	"time"
+)
+
+// Session represents a login of a user on a device. It lives as long as the
+// refresh tokens issued for the login, which share its ID as their family.
+type Session struct {
+	ID           string     `db:"session_id" json:"id"`
+	UserID       string     `db:"user_id" json:"user_id"`
+	Device       string     `db:"device" json:"device"`
+	IP           string     `db:"ip" json:"ip"`
+	UserAgent    string     `db:"user_agent" json:"user_agent"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	LastSeenAt   time.Time  `db:"last_seen_at" json:"last_seen_at"`
+	TerminatedAt *time.Time `db:"terminated_at" json:"terminated_at,omitempty"`
+}
+
+// NewSession contains information needed to create a new Session.
+type NewSession struct {
+	UserID    string
+	Device    string
+	IP        string
+	UserAgent string
+}
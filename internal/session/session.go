@@ -0,0 +1,139 @@
+// Package session keeps the inventory of where users are logged in.
+package session
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+var (
+	// ErrNotFound is used when a specific Session is requested but does not
+	// exist or was already terminated.
+	ErrNotFound = errors.New("Session not found")
+
+	// ErrInvalidID occurs when an ID is not in a valid form.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+)
+
+// Create records a new session.
+func Create(ctx context.Context, db *sqlx.DB, n NewSession, now time.Time) (*Session, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.session.Create")
+	defer span.End()
+
+	s := Session{
+		ID:         uuid.New().String(),
+		UserID:     n.UserID,
+		Device:     n.Device,
+		IP:         n.IP,
+		UserAgent:  n.UserAgent,
+		CreatedAt:  now.UTC(),
+		LastSeenAt: now.UTC(),
+	}
+
+	const q = `INSERT INTO sessions
+		(session_id, user_id, device, ip, user_agent, created_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := db.ExecContext(
+		ctx, q,
+		s.ID, s.UserID, s.Device, s.IP, s.UserAgent,
+		s.CreatedAt, s.LastSeenAt,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting session")
+	}
+
+	return &s, nil
+}
+
+// List retrieves the sessions of the user that are not terminated, most
+// recently seen first.
+func List(ctx context.Context, db *sqlx.DB, userID string) ([]Session, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.session.List")
+	defer span.End()
+
+	sessions := []Session{}
+	const q = `SELECT * FROM sessions
+		WHERE user_id = $1 AND terminated_at IS NULL
+		ORDER BY last_seen_at DESC`
+
+	if err := db.SelectContext(ctx, &sessions, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting sessions")
+	}
+
+	return sessions, nil
+}
+
+// Touch records that the session was just used, such as to refresh its
+// access token.
+func Touch(ctx context.Context, db *sqlx.DB, id, ip string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.session.Touch")
+	defer span.End()
+
+	const q = `UPDATE sessions SET last_seen_at = $2, ip = $3 WHERE session_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, now.UTC(), ip); err != nil {
+		return errors.Wrapf(err, "touching session %s", id)
+	}
+
+	return nil
+}
+
+// Terminate ends a session of the user.
+func Terminate(ctx context.Context, db *sqlx.DB, userID, id string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.session.Terminate")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `UPDATE sessions SET terminated_at = $3
+		WHERE session_id = $1 AND user_id = $2 AND terminated_at IS NULL`
+	res, err := db.ExecContext(ctx, q, id, userID, now.UTC())
+	if err != nil {
+		return errors.Wrapf(err, "terminating session %s", id)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// TerminateAll ends every session of the user.
+func TerminateAll(ctx context.Context, db *sqlx.DB, userID string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.session.TerminateAll")
+	defer span.End()
+
+	const q = `UPDATE sessions SET terminated_at = $2 WHERE user_id = $1 AND terminated_at IS NULL`
+	if _, err := db.ExecContext(ctx, q, userID, now.UTC()); err != nil {
+		return errors.Wrapf(err, "terminating sessions of user %s", userID)
+	}
+
+	return nil
+}
+
+// Device guesses a short description of the client device from its user
+// agent. It returns an empty string when the device is not recognized.
+func Device(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	for _, d := range []struct{ token, name string }{
+		{"iphone", "iPhone"},
+		{"ipad", "iPad"},
+		{"android", "Android"},
+		{"windows", "Windows"},
+		{"macintosh", "Mac"},
+		{"cros", "Chromebook"},
+		{"linux", "Linux"},
+	} {
+		if strings.Contains(ua, d.token) {
+			return d.name
+		}
+	}
+	return ""
+}
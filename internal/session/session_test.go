@@ -0,0 +1,114 @@
+package session_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sankarvj/seedgo/internal/account"
+	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/session"
+	"github.com/sankarvj/seedgo/internal/tests"
+	"github.com/sankarvj/seedgo/internal/user"
+)
+
+// TestSession validates the sessions of a user can be listed and terminated
+// one at a time or all at once without affecting other users.
+func TestSession(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to work with Session records.")
+	{
+		t.Log("\tWhen handling the sessions of a user.")
+		{
+			ctx := tests.Context()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			a, err := account.Create(ctx, db, account.NewAccount{Name: "Wayplot", Domain: "Wayplot"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
+			}
+
+			claims := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleAdmin}, now, time.Hour)
+			claims.AccountID = a.ID
+
+			var users []string
+			for _, email := range []string{"bill@ardanlabs.com", "jill@ardanlabs.com"} {
+				nu := user.NewUser{
+					AccountID:       a.ID,
+					Name:            "Bill Kennedy",
+					Email:           email,
+					Roles:           []string{auth.RoleUser},
+					Password:        "gophers",
+					PasswordConfirm: "gophers",
+				}
+				u, err := user.Create(ctx, claims, db, nu, now)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+				}
+				users = append(users, u.ID)
+			}
+			t.Logf("\t%s\tShould be able to create users.", tests.Success)
+
+			var sessions []*session.Session
+			for i, userID := range []string{users[0], users[0], users[0], users[1]} {
+				ns := session.NewSession{UserID: userID, Device: "Linux", IP: "10.0.0.1"}
+				s, err := session.Create(ctx, db, ns, now.Add(time.Duration(i)*time.Minute))
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to create session : %s.", tests.Failed, err)
+				}
+				sessions = append(sessions, s)
+			}
+			t.Logf("\t%s\tShould be able to create sessions.", tests.Success)
+
+			if err := session.Touch(ctx, db, sessions[0].ID, "10.0.0.2", now.Add(time.Hour)); err != nil {
+				t.Fatalf("\t%s\tShould be able to touch session : %s.", tests.Failed, err)
+			}
+
+			list, err := session.List(ctx, db, users[0])
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to list sessions : %s.", tests.Failed, err)
+			}
+			if len(list) != 3 || list[0].ID != sessions[0].ID || list[0].IP != "10.0.0.2" || list[1].ID != sessions[2].ID || list[2].ID != sessions[1].ID {
+				t.Fatalf("\t%s\tShould list the sessions of the user most recently seen first : %+v.", tests.Failed, list)
+			}
+			t.Logf("\t%s\tShould list the sessions of the user most recently seen first.", tests.Success)
+
+			if err := session.Terminate(ctx, db, users[0], "bogus", now); err != session.ErrInvalidID {
+				t.Fatalf("\t%s\tShould NOT be able to terminate a session with an invalid ID : %v.", tests.Failed, err)
+			}
+			if err := session.Terminate(ctx, db, users[1], sessions[0].ID, now); err != session.ErrNotFound {
+				t.Fatalf("\t%s\tShould NOT be able to terminate a session of another user : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to terminate a session of another user.", tests.Success)
+
+			if err := session.Terminate(ctx, db, users[0], sessions[0].ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to terminate a session : %s.", tests.Failed, err)
+			}
+			list, err = session.List(ctx, db, users[0])
+			if err != nil || len(list) != 2 || list[0].ID != sessions[2].ID || list[1].ID != sessions[1].ID {
+				t.Fatalf("\t%s\tShould list only the sessions left : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould list only the sessions left.", tests.Success)
+
+			if err := session.Terminate(ctx, db, users[0], sessions[0].ID, now); err != session.ErrNotFound {
+				t.Fatalf("\t%s\tShould NOT be able to terminate a session twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to terminate a session twice.", tests.Success)
+
+			if err := session.TerminateAll(ctx, db, users[0], now); err != nil {
+				t.Fatalf("\t%s\tShould be able to terminate all sessions : %s.", tests.Failed, err)
+			}
+			if list, err := session.List(ctx, db, users[0]); err != nil || len(list) != 0 {
+				t.Fatalf("\t%s\tShould list no session once all are terminated : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould list no session once all are terminated.", tests.Success)
+
+			list, err = session.List(ctx, db, users[1])
+			if err != nil || len(list) != 1 || list[0].ID != sessions[3].ID {
+				t.Fatalf("\t%s\tShould keep the sessions of other users : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould keep the sessions of other users.", tests.Success)
+		}
+	}
+}
@@ -92,11 +92,42 @@ func NewUnit(t *testing.T) (*sqlx.DB, func()) {
 	return db, teardown
 }
 
+// These are the identity provider credentials of the users in the seed data.
+// FakeProvider built by NewIntegration accepts them.
+const (
+	AdminCredential = "cfr07IBEBCfGxp9dxjBOGYdkjHG2"
+	UserCredential  = "ggOv3mMCqVZ6nFqaco4lD9qjxc63"
+)
+
+// FakeProvider is an auth.IdentityProvider for tests. It accepts the
+// credentials registered in Identities and rejects everything else without
+// making any network calls.
+type FakeProvider struct {
+	ProviderName string
+	Identities   map[string]auth.Identity
+}
+
+// Name implements auth.IdentityProvider.
+func (p *FakeProvider) Name() string {
+	return p.ProviderName
+}
+
+// Verify implements auth.IdentityProvider.
+func (p *FakeProvider) Verify(ctx context.Context, credential string) (auth.Identity, error) {
+	id, ok := p.Identities[credential]
+	if !ok {
+		return auth.Identity{}, auth.ErrInvalidCredential
+	}
+	id.Provider = p.ProviderName
+	return id, nil
+}
+
 // Test owns state for running and shutting down tests.
 type Test struct {
 	DB            *sqlx.DB
 	Log           *log.Logger
 	Authenticator *auth.Authenticator
+	Provider      *FakeProvider
 
 	t       *testing.T
 	cleanup func()
@@ -122,19 +153,28 @@ func NewIntegration(t *testing.T) *Test {
 		t.Fatal(err)
 	}
 
-	googleFilePath := "../../config/mobile-pwa-project-e0f81b1c14b0.json"
 	// Build an authenticator using this static key.
 	kid := "4754d86b-7a6d-4df5-9c65-224741361492"
-	kf := auth.NewSimpleKeyLookupFunc(kid, key.Public().(*rsa.PublicKey))
-	authenticator, err := auth.NewAuthenticator(key, googleFilePath, kid, "RS256", kf)
+	kf := auth.NewSimpleKeyLookupFunc(kid, auth.VerifyKey{Algorithm: auth.AlgRS256, Key: &key.PublicKey})
+	authenticator, err := auth.NewAuthenticator(key, kid, "RS256", kf)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	// Accept the seeded users' firebase credentials.
+	provider := FakeProvider{
+		ProviderName: "firebase",
+		Identities: map[string]auth.Identity{
+			AdminCredential: {Subject: AdminCredential, Email: "vijayasankarmail@gmail.com", EmailVerified: true},
+			UserCredential:  {Subject: UserCredential, Email: "vijayasankarj@gmail.com", EmailVerified: true},
+		},
+	}
+
 	return &Test{
 		DB:            db,
 		Log:           logger,
 		Authenticator: authenticator,
+		Provider:      &provider,
 		t:             t,
 		cleanup:       cleanup,
 	}
@@ -151,7 +191,7 @@ func (test *Test) Token(email, pass string) string {
 
 	claims, err := user.Authenticate(
 		context.Background(), test.DB, time.Now(),
-		email, pass,
+		"", email, pass, time.Hour,
 	)
 	if err != nil {
 		test.t.Fatal(err)
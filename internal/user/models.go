@@ -18,14 +18,21 @@ type User struct {
 	Roles        pq.StringArray `db:"roles" json:"roles"`
 	PasswordHash []byte         `db:"password_hash" json:"-"`
 	Provider     *string        `db:"provider" json:"provider"`
+	ProviderUID  *string        `db:"provider_uid" json:"-"`
 	IssuedAt     *string        `db:"issued_at" json:"issued_at"`
 	CreatedAt    time.Time      `db:"created_at" json:"created_at"`
 	UpdatedAt    int64          `db:"updated_at" json:"updated_at"`
+	DeletedAt    *time.Time     `db:"deleted_at" json:"deleted_at,omitempty"`
+
+	// Version is incremented by every change to the user. It is sent as the
+	// ETag of the user.
+	Version int64 `db:"version" json:"-"`
 }
 
-// NewUser contains information needed to create a new User.
+// NewUser contains information needed to create a new User. AccountID
+// defaults to the account of the caller.
 type NewUser struct {
-	AccountID       string   `json:"account_id" validate:"required"`
+	AccountID       string   `json:"account_id"`
 	Name            string   `json:"name" validate:"required"`
 	Email           string   `json:"email" validate:"required"`
 	Roles           []string `json:"roles" validate:"required"`
@@ -47,3 +54,55 @@ type UpdateUser struct {
 	Password        *string  `json:"password"`
 	PasswordConfirm *string  `json:"password_confirm" validate:"omitempty,eqfield=Password"`
 }
+
+// PatchUser is the document of a user that JSON merge patches (RFC 7396)
+// apply to. It covers every field a user may change. Members patched to null
+// are cleared. Password is write only: it is never part of the document read
+// back and is changed only when a patch sets it.
+type PatchUser struct {
+	Name     *string  `json:"name"`
+	Avatar   *string  `json:"avatar"`
+	Email    string   `json:"email" validate:"required"`
+	Phone    *string  `json:"phone"`
+	Roles    []string `json:"roles" validate:"required"`
+	Password *string  `json:"password,omitempty" validate:"omitempty,min=1"`
+}
+
+// ListUsers holds the filters, sort order and page requested when listing
+// users. Name matches a prefix of the name, case insensitively. Provider
+// "password" matches the users without an identity provider. Sort is one of
+// created_at, name or email, prefixed with a minus sign for descending order.
+// Cursor is the next_cursor of the previous page. Deleted lists the users
+// awaiting purge instead of the active ones.
+type ListUsers struct {
+	Email    string `query:"email"`
+	Role     string `query:"role"`
+	Verified *bool  `query:"verified"`
+	Provider string `query:"provider"`
+	Name     string `query:"name"`
+	Sort     string `query:"sort" validate:"omitempty,oneof=created_at -created_at name -name email -email"`
+	Cursor   string `query:"cursor"`
+	Limit    int    `query:"limit" validate:"omitempty,min=1,max=100"`
+	Deleted  bool   `query:"deleted"`
+}
+
+// Page is one page of users. NextCursor is blank on the last page.
+type Page struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ScopedTokenRequest is the body of a request for a token narrowed to scopes.
+// ExpiresIn is in seconds and defaults to the lifetime of access tokens.
+type ScopedTokenRequest struct {
+	Scope     []string `json:"scope" validate:"required,min=1"`
+	ExpiresIn int64    `json:"expires_in" validate:"omitempty,min=1"`
+}
+
+// Credentials is the body of a password grant request. The account is only
+// needed when the email and password match users of several accounts.
+type Credentials struct {
+	AccountID string `json:"account_id"`
+	Email     string `json:"email" validate:"required"`
+	Password  string `json:"password" validate:"required"`
+}
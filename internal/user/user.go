@@ -1,15 +1,21 @@
 package user
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/account"
 	"github.com/sankarvj/seedgo/internal/platform/auth"
+	"github.com/sankarvj/seedgo/internal/policy"
+	"github.com/sankarvj/seedgo/internal/role"
 	"go.opencensus.io/trace"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -27,23 +33,120 @@ var (
 	// anything goes wrong.
 	ErrAuthenticationFailure = errors.New("Authentication failed")
 
+	// ErrAccountRequired occurs when the email and password match users of
+	// several accounts and no account was specified to choose between them.
+	ErrAccountRequired = errors.New("Credentials match several accounts, an account is required")
+
 	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
-	ErrForbidden = errors.New("Attempted action is not allowed")
+	ErrForbidden = policy.ErrForbidden
+
+	// ErrNotVerified occurs when a user whose email is not verified attempts
+	// to authenticate and verification is required.
+	ErrNotVerified = errors.New("Email is not verified")
+
+	// ErrVersionMismatch occurs when a user is modified after the version the
+	// caller based its changes on.
+	ErrVersionMismatch = errors.New("User has been modified since it was read")
+
+	// ErrInvalidCursor occurs when a page cursor is malformed or was issued
+	// for another sort order.
+	ErrInvalidCursor = errors.New("Cursor is not valid")
 )
 
-// List retrieves a list of existing users from the database.
-func List(ctx context.Context, db *sqlx.DB) ([]User, error) {
+// defaultPageSize is the number of users listed when no limit is requested.
+const defaultPageSize = 50
+
+// passwordProvider filters the users without an identity provider.
+const passwordProvider = "password"
+
+// sortColumns maps the sort orders accepted by List to the expression they
+// order by. Names are optional so missing ones sort as blank.
+var sortColumns = map[string]string{
+	"created_at": "created_at",
+	"name":       "COALESCE(name, '')",
+	"email":      "email",
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// List retrieves a page of the users of the caller's account from the
+// database. Superadmins get the users of every account. Pages are keyed on
+// the sort column and the user id so rows inserted between requests neither
+// repeat nor go missing.
+func List(ctx context.Context, claims auth.Claims, db *sqlx.DB, f ListUsers) (*Page, error) {
 	ctx, span := trace.StartSpan(ctx, "internal.user.List")
 	defer span.End()
 
-	users := []User{}
-	const q = `SELECT * FROM users`
+	if err := policy.Authorize(claims, policy.UserList, policy.Resource{}); err != nil {
+		return nil, err
+	}
+
+	if f.Sort == "" {
+		f.Sort = "created_at"
+	}
+	if f.Limit == 0 {
+		f.Limit = defaultPageSize
+	}
+	key, dir, cmp := strings.TrimPrefix(f.Sort, "-"), "ASC", ">"
+	if strings.HasPrefix(f.Sort, "-") {
+		dir, cmp = "DESC", "<"
+	}
+	col := sortColumns[key]
+
+	q := `SELECT * FROM users WHERE (account_id::text = $1 OR $2) AND (deleted_at IS NOT NULL) = $3`
+	args := []interface{}{claims.AccountID, claims.HasRole(auth.RoleSuperAdmin), f.Deleted}
+	where := func(cond string, vals ...interface{}) {
+		refs := make([]interface{}, len(vals))
+		for i, v := range vals {
+			args = append(args, v)
+			refs[i] = len(args)
+		}
+		q += " AND " + fmt.Sprintf(cond, refs...)
+	}
 
-	if err := db.SelectContext(ctx, &users, q); err != nil {
+	if f.Email != "" {
+		where("lower(email) = lower($%d)", f.Email)
+	}
+	if f.Role != "" {
+		where("$%d = ANY(roles)", f.Role)
+	}
+	if f.Verified != nil {
+		where("verified = $%d", *f.Verified)
+	}
+	switch f.Provider {
+	case "":
+	case passwordProvider:
+		q += " AND provider IS NULL"
+	default:
+		where("provider = $%d", f.Provider)
+	}
+	if f.Name != "" {
+		where(`name ILIKE $%d ESCAPE '\'`, likeEscaper.Replace(f.Name)+"%")
+	}
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor, f.Sort)
+		if err != nil {
+			return nil, err
+		}
+		where("("+col+", user_id) "+cmp+" ($%d, $%d)", c.value(key), c.ID)
+	}
+
+	// One more row than requested tells whether there is a next page.
+	q += fmt.Sprintf(" ORDER BY %s %s, user_id %s LIMIT %d", col, dir, dir, f.Limit+1)
+
+	users := []User{}
+	if err := db.SelectContext(ctx, &users, q, args...); err != nil {
 		return nil, errors.Wrap(err, "selecting users")
 	}
 
-	return users, nil
+	p := Page{Users: users}
+	if len(users) > f.Limit {
+		p.Users = users[:f.Limit]
+		p.NextCursor = encodeCursor(p.Users[f.Limit-1], f.Sort)
+	}
+
+	return &p, nil
 }
 
 // Retrieve gets the specified user from the database.
@@ -51,17 +154,21 @@ func Retrieve(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) (
 	ctx, span := trace.StartSpan(ctx, "internal.user.Retrieve")
 	defer span.End()
 
+	return RetrieveFor(ctx, claims, db, id, policy.UserRead)
+}
+
+// RetrieveFor gets the specified user from the database once the policy
+// allows the action on them.
+func RetrieveFor(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, action policy.Action) (*User, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.user.RetrieveFor")
+	defer span.End()
+
 	if _, err := uuid.Parse(id); err != nil {
 		return nil, ErrInvalidID
 	}
 
-	// If you are not an admin and looking to retrieve someone else then you are rejected.
-	if !claims.HasRole(auth.RoleAdmin) && claims.Subject != id {
-		return nil, ErrForbidden
-	}
-
 	var u User
-	const q = `SELECT * FROM users WHERE user_id = $1`
+	const q = `SELECT * FROM users WHERE user_id = $1 AND deleted_at IS NULL`
 	if err := db.GetContext(ctx, &u, q, id); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
@@ -70,14 +177,43 @@ func Retrieve(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) (
 		return nil, errors.Wrapf(err, "selecting user %q", id)
 	}
 
-	return &u, nil
+	// Users of other accounts are reported as missing so their existence is
+	// not leaked across tenants.
+	res := policy.Resource{AccountID: u.AccountID, OwnerID: u.ID, Roles: u.Roles}
+	switch err := policy.Authorize(claims, action, res); err {
+	case nil:
+		return &u, nil
+	case policy.ErrOutsideTenant:
+		return nil, ErrNotFound
+	default:
+		return nil, err
+	}
 }
 
-// Create inserts a new user into the database.
-func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User, error) {
+// Create inserts a new user into the database. The user is created in the
+// caller's account unless the caller is a superadmin. Every role must be
+// available to the account or role.ErrNotFound is returned.
+func Create(ctx context.Context, claims auth.Claims, db *sqlx.DB, n NewUser, now time.Time) (*User, error) {
 	ctx, span := trace.StartSpan(ctx, "internal.user.Create")
 	defer span.End()
 
+	if n.AccountID == "" {
+		n.AccountID = claims.AccountID
+	}
+	switch err := policy.Authorize(claims, policy.UserCreate, policy.Resource{AccountID: n.AccountID}); err {
+	case nil:
+	case policy.ErrOutsideTenant:
+		return nil, account.ErrNotFound
+	default:
+		return nil, err
+	}
+	if err := role.Exist(ctx, db, n.AccountID, n.Roles); err != nil {
+		return nil, err
+	}
+	if err := grantRoles(ctx, claims, db, n.AccountID, nil, n.Roles); err != nil {
+		return nil, err
+	}
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(n.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, errors.Wrap(err, "generating password hash")
@@ -92,6 +228,7 @@ func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User,
 		Roles:        n.Roles,
 		CreatedAt:    now.UTC(),
 		UpdatedAt:    now.UTC().Unix(),
+		Version:      1,
 	}
 
 	const q = `INSERT INTO users
@@ -110,23 +247,33 @@ func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User,
 	return &u, nil
 }
 
-// Update replaces a user document in the database.
-func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, upd UpdateUser, now time.Time) error {
+// Update replaces a user document in the database. A non zero version must
+// match the version of the user or ErrVersionMismatch is returned. The write
+// only succeeds if the user did not change since it was read so concurrent
+// updates never overwrite each other.
+func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, upd UpdateUser, version int64, now time.Time) error {
 	ctx, span := trace.StartSpan(ctx, "internal.user.Update")
 	defer span.End()
 
-	u, err := Retrieve(ctx, claims, db, id)
+	u, err := RetrieveFor(ctx, claims, db, id, policy.UserUpdate)
 	if err != nil {
 		return err
 	}
+	if version != 0 && version != u.Version {
+		return ErrVersionMismatch
+	}
 
 	if upd.Name != nil {
 		u.Name = upd.Name
 	}
-	if upd.Email != nil {
+	if upd.Email != nil && *upd.Email != u.Email {
 		u.Email = *upd.Email
+		u.Verified = false
 	}
 	if upd.Roles != nil {
+		if err := grantRoles(ctx, claims, db, u.AccountID, u.Roles, upd.Roles); err != nil {
+			return err
+		}
 		u.Roles = upd.Roles
 	}
 	if upd.Password != nil {
@@ -139,56 +286,344 @@ func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, upd
 
 	u.UpdatedAt = now.Unix()
 
+	return save(ctx, db, u)
+}
+
+// PatchDocument returns the document of the user merge patches apply to. The
+// password is never read back so it is only present once patched.
+func PatchDocument(u *User) PatchUser {
+	return PatchUser{
+		Name:   u.Name,
+		Avatar: u.Avatar,
+		Email:  u.Email,
+		Phone:  u.Phone,
+		Roles:  u.Roles,
+	}
+}
+
+// Patch replaces the mutable fields of a user with the document, which is
+// the result of merging a patch into the PatchDocument of the user at the
+// version. It returns ErrVersionMismatch if the user is no longer at that
+// version, so the patch is never applied to changes it did not see.
+func Patch(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, doc PatchUser, version int64, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.user.Patch")
+	defer span.End()
+
+	u, err := RetrieveFor(ctx, claims, db, id, policy.UserUpdate)
+	if err != nil {
+		return err
+	}
+	if version != u.Version {
+		return ErrVersionMismatch
+	}
+	if err := grantRoles(ctx, claims, db, u.AccountID, u.Roles, doc.Roles); err != nil {
+		return err
+	}
+
+	u.Name = doc.Name
+	u.Avatar = doc.Avatar
+	u.Phone = doc.Phone
+	u.Roles = doc.Roles
+	if doc.Email != u.Email {
+		u.Email = doc.Email
+		u.Verified = false
+	}
+	if doc.Password != nil {
+		pw, err := bcrypt.GenerateFromPassword([]byte(*doc.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return errors.Wrap(err, "generating password hash")
+		}
+		u.PasswordHash = pw
+	}
+
+	u.UpdatedAt = now.Unix()
+
+	return save(ctx, db, u)
+}
+
+// grantRoles returns ErrForbidden if the claims may not give the roles of the
+// account to a user holding the roles in held, such as roles granting
+// permissions the caller does not hold. Roles already held are not granted
+// again so they are not checked.
+func grantRoles(ctx context.Context, claims auth.Claims, db *sqlx.DB, accountID string, held, roles []string) error {
+	var granted []string
+	for _, r := range roles {
+		if !contains(held, r) {
+			granted = append(granted, r)
+		}
+	}
+	if len(granted) == 0 {
+		return nil
+	}
+
+	perms, err := role.Permissions(ctx, db, accountID, granted)
+	if err != nil {
+		return err
+	}
+	return policy.GrantRoles(claims, granted, perms)
+}
+
+// contains reports whether roles contains the named role.
+func contains(roles []string, name string) bool {
+	for _, r := range roles {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the mutable fields of the user to the database. It returns
+// ErrVersionMismatch if the user changed since it was read.
+func save(ctx context.Context, db *sqlx.DB, u *User) error {
 	const q = `UPDATE users SET
 		"name" = $2,
-		"email" = $3,
-		"roles" = $4,
-		"password_hash" = $5,
-		"updated_at" = $6
-		WHERE user_id = $1`
-	_, err = db.ExecContext(ctx, q, id,
-		u.Name, u.Email, u.Roles,
-		u.PasswordHash, u.UpdatedAt,
+		"avatar" = $3,
+		"email" = $4,
+		"phone" = $5,
+		"roles" = $6,
+		"password_hash" = $7,
+		"verified" = $8,
+		"updated_at" = $9,
+		"version" = version + 1
+		WHERE user_id = $1 AND deleted_at IS NULL AND version = $10`
+	res, err := db.ExecContext(ctx, q, u.ID,
+		u.Name, u.Avatar, u.Email, u.Phone, u.Roles,
+		u.PasswordHash, u.Verified, u.UpdatedAt, u.Version,
 	)
 	if err != nil {
 		return errors.Wrap(err, "updating user")
 	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrVersionMismatch
+	}
 
 	return nil
 }
 
-// Delete removes a user from the database.
-func Delete(ctx context.Context, db *sqlx.DB, id string) error {
+// Delete marks a user of the caller's account as deleted. Deleted users
+// cannot authenticate and are left out of every query until they are restored
+// or purged. Superadmins may delete the users of every account.
+func Delete(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, now time.Time) error {
 	ctx, span := trace.StartSpan(ctx, "internal.user.Delete")
 	defer span.End()
 
+	u, err := RetrieveFor(ctx, claims, db, id, policy.UserDelete)
+	if err != nil {
+		return err
+	}
+
+	const q = `UPDATE users SET deleted_at = $3, updated_at = $4, version = version + 1
+		WHERE user_id = $1 AND account_id = $2 AND deleted_at IS NULL`
+
+	res, err := db.ExecContext(ctx, q, id, u.AccountID, now.UTC(), now.Unix())
+	if err != nil {
+		return errors.Wrapf(err, "deleting user %s", id)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Restore brings back a deleted user of the caller's account. It returns
+// ErrNotFound unless the user is deleted and their account is not. The email
+// of a deleted user stays taken until it is purged so it can always be
+// restored.
+func Restore(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.user.Restore")
+	defer span.End()
+
 	if _, err := uuid.Parse(id); err != nil {
 		return ErrInvalidID
 	}
 
-	const q = `DELETE FROM users WHERE user_id = $1`
+	var u User
+	const q = `SELECT * FROM users WHERE user_id = $1 AND deleted_at IS NOT NULL`
+	if err := db.GetContext(ctx, &u, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
 
-	if _, err := db.ExecContext(ctx, q, id); err != nil {
-		return errors.Wrapf(err, "deleting user %s", id)
+		return errors.Wrapf(err, "selecting user %q", id)
+	}
+
+	res := policy.Resource{AccountID: u.AccountID, OwnerID: u.ID, Roles: u.Roles}
+	switch err := policy.Authorize(claims, policy.UserRestore, res); err {
+	case nil:
+	case policy.ErrOutsideTenant:
+		return ErrNotFound
+	default:
+		return err
+	}
+
+	const r = `UPDATE users SET deleted_at = NULL, updated_at = $2, version = version + 1
+		WHERE user_id = $1 AND deleted_at IS NOT NULL AND account_id IN (
+			SELECT account_id FROM accounts WHERE deleted_at IS NULL
+		)`
+	result, err := db.ExecContext(ctx, r, id, now.Unix())
+	if err != nil {
+		return errors.Wrapf(err, "restoring user %s", id)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Purge permanently removes the users deleted before the time. It returns
+// how many were removed.
+func Purge(ctx context.Context, db *sqlx.DB, before time.Time) (int64, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.user.Purge")
+	defer span.End()
+
+	const q = `DELETE FROM users WHERE deleted_at < $1`
+	res, err := db.ExecContext(ctx, q, before.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "purging users")
+	}
+
+	return res.RowsAffected()
+}
+
+// Verify marks the email of the user as verified. It returns ErrNotFound if
+// the user no longer has that email.
+func Verify(ctx context.Context, db *sqlx.DB, id, email string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.user.Verify")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `UPDATE users SET verified = TRUE, updated_at = $3, version = version + 1
+		WHERE user_id = $1 AND email = $2 AND deleted_at IS NULL`
+	res, err := db.ExecContext(ctx, q, id, email, now.Unix())
+	if err != nil {
+		return errors.Wrapf(err, "verifying user %s", id)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// SetPassword replaces the password of the user.
+func SetPassword(ctx context.Context, db *sqlx.DB, id, password string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.user.SetPassword")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "generating password hash")
+	}
+
+	const q = `UPDATE users SET password_hash = $2, updated_at = $3, version = version + 1
+		WHERE user_id = $1 AND deleted_at IS NULL`
+	res, err := db.ExecContext(ctx, q, id, hash, now.Unix())
+	if err != nil {
+		return errors.Wrapf(err, "setting password of user %s", id)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
 	}
 
 	return nil
 }
 
-// Authenticate finds a user by their email and verifies their password. On
-// success it returns a Claims value representing this user. The claims can be
-// used to generate a token for future authentication.
-func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, password string) (auth.Claims, error) {
+// ListByEmail retrieves the users with the email. The same email may belong
+// to users of several accounts.
+func ListByEmail(ctx context.Context, db *sqlx.DB, email string) ([]User, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.user.ListByEmail")
+	defer span.End()
+
+	users := []User{}
+	const q = `SELECT * FROM users WHERE email = $1 AND deleted_at IS NULL`
+
+	if err := db.SelectContext(ctx, &users, q, email); err != nil {
+		return nil, errors.Wrap(err, "selecting users by email")
+	}
+
+	return users, nil
+}
+
+// ListUnverified retrieves the users with the email that have not verified
+// it yet. The same email may belong to users of several accounts.
+func ListUnverified(ctx context.Context, db *sqlx.DB, email string) ([]User, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.user.ListUnverified")
+	defer span.End()
+
+	users := []User{}
+	const q = `SELECT * FROM users WHERE email = $1 AND NOT verified AND deleted_at IS NULL`
+
+	if err := db.SelectContext(ctx, &users, q, email); err != nil {
+		return nil, errors.Wrap(err, "selecting unverified users")
+	}
+
+	return users, nil
+}
+
+// Authenticate finds a user by their email and verifies their password. The
+// same email may belong to users of several accounts: the password is checked
+// against each of them unless an account is specified. On success it returns
+// a Claims value representing this user which expires after the specified
+// duration. The claims can be used to generate a token for future
+// authentication.
+func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, accountID, email, password string, expires time.Duration) (auth.Claims, error) {
 	ctx, span := trace.StartSpan(ctx, "internal.user.Authenticate")
 	defer span.End()
 
-	const q = `SELECT * FROM users WHERE email = $1`
+	const q = `SELECT * FROM users WHERE email = $1 AND deleted_at IS NULL
+		AND ($2 = '' OR account_id::text = $2)
+		ORDER BY created_at, user_id`
 
-	var u User
-	if err := db.GetContext(ctx, &u, q, email); err != nil {
+	// Normally we would return ErrNotFound when no user has the email but we
+	// do not want to leak to an unauthenticated user which emails are in the
+	// system.
+	var users []User
+	if err := db.SelectContext(ctx, &users, q, email, accountID); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "selecting users")
+	}
 
-		// Normally we would return ErrNotFound in this scenario but we do not want
-		// to leak to an unauthenticated user which emails are in the system.
+	// Compare the provided password with the saved hashes. Use the bcrypt
+	// comparison function so it is cryptographically secure.
+	var match *User
+	for i := range users {
+		if err := bcrypt.CompareHashAndPassword(users[i].PasswordHash, []byte(password)); err != nil {
+			continue
+		}
+		if match != nil {
+			return auth.Claims{}, ErrAccountRequired
+		}
+		match = &users[i]
+	}
+	if match == nil {
+		return auth.Claims{}, ErrAuthenticationFailure
+	}
+
+	return claimsFor(ctx, db, *match, now, expires)
+}
+
+// AuthenticateProvider finds the user linked to an identity verified by an
+// external provider such as Firebase. The provider's user id is a credential
+// of its own and is never compared with the password. On success it returns
+// a Claims value representing this user which expires after the specified
+// duration.
+func AuthenticateProvider(ctx context.Context, db *sqlx.DB, now time.Time, provider, uid string, expires time.Duration) (auth.Claims, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.user.AuthenticateProvider")
+	defer span.End()
+
+	const q = `SELECT * FROM users WHERE provider = $1 AND provider_uid = $2 AND deleted_at IS NULL`
+
+	var u User
+	if err := db.GetContext(ctx, &u, q, provider, uid); err != nil {
 		if err == sql.ErrNoRows {
 			return auth.Claims{}, ErrAuthenticationFailure
 		}
@@ -196,10 +631,166 @@ func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, passwo
 		return auth.Claims{}, errors.Wrap(err, "selecting single user")
 	}
 
-	res := bytes.Compare(u.PasswordHash, []byte(password))
-	if res != 0 { //not equal
-		return auth.Claims{}, ErrAuthenticationFailure
+	return claimsFor(ctx, db, u, now, expires)
+}
+
+// Provision creates the user for an identity verified by an external provider
+// on its first login. When the account already has a user with the same email
+// that is not linked to any provider, and the provider has verified the email,
+// that user is linked instead of creating a new one. It returns
+// ErrAuthenticationFailure if the email belongs to a user that cannot be
+// linked.
+func Provision(ctx context.Context, db *sqlx.DB, accountID string, roles []string, id auth.Identity, now time.Time) (*User, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.user.Provision")
+	defer span.End()
+
+	optional := func(s string) *string {
+		if s == "" {
+			return nil
+		}
+		return &s
+	}
+
+	var issuedAt interface{}
+	if !id.IssuedAt.IsZero() {
+		issuedAt = id.IssuedAt.UTC()
+	}
+
+	u := User{
+		ID:          uuid.New().String(),
+		AccountID:   accountID,
+		Name:        optional(id.Name),
+		Avatar:      optional(id.Avatar),
+		Email:       id.Email,
+		Phone:       optional(id.Phone),
+		Verified:    id.EmailVerified,
+		Roles:       roles,
+		Provider:    &id.Provider,
+		ProviderUID: &id.Subject,
+		CreatedAt:   now.UTC(),
+		UpdatedAt:   now.UTC().Unix(),
+	}
+
+	const q = `INSERT INTO users
+		(user_id, account_id, name, avatar, email, phone, verified, roles, provider, provider_uid, issued_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (account_id, email) DO UPDATE SET
+			provider = EXCLUDED.provider,
+			provider_uid = EXCLUDED.provider_uid,
+			verified = TRUE,
+			updated_at = EXCLUDED.updated_at,
+			version = users.version + 1
+		WHERE users.provider_uid IS NULL AND users.deleted_at IS NULL AND EXCLUDED.verified
+		RETURNING *`
+	err := db.GetContext(
+		ctx, &u, q,
+		u.ID, u.AccountID, u.Name, u.Avatar, u.Email, u.Phone,
+		u.Verified, u.Roles, u.Provider, u.ProviderUID, issuedAt,
+		u.CreatedAt, u.UpdatedAt,
+	)
+	if err != nil {
+
+		// No row is returned when the email belongs to a user we must not link.
+		if err == sql.ErrNoRows {
+			return nil, ErrAuthenticationFailure
+		}
+
+		return nil, errors.Wrap(err, "provisioning user")
+	}
+
+	return &u, nil
+}
+
+// Claims builds the Claims of an existing user without checking any
+// credentials. It is used when the caller has already proven who the user is,
+// such as by presenting a valid refresh token.
+func Claims(ctx context.Context, db *sqlx.DB, now time.Time, id string, expires time.Duration) (auth.Claims, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.user.Claims")
+	defer span.End()
+
+	const q = `SELECT * FROM users WHERE user_id = $1 AND deleted_at IS NULL`
+
+	var u User
+	if err := db.GetContext(ctx, &u, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, ErrAuthenticationFailure
+		}
+
+		return auth.Claims{}, errors.Wrapf(err, "selecting user %q", id)
+	}
+
+	return claimsFor(ctx, db, u, now, expires)
+}
+
+// claimsFor builds the Claims of the user. The permissions granted by the
+// user's roles are resolved now and carried in the claims.
+func claimsFor(ctx context.Context, db *sqlx.DB, u User, now time.Time, expires time.Duration) (auth.Claims, error) {
+	perms, err := role.Permissions(ctx, db, u.AccountID, u.Roles)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	claims := auth.NewClaims(u.ID, u.Roles, now, expires)
+	claims.AccountID = u.AccountID
+	claims.Permissions = perms
+
+	return claims, nil
+}
+
+// cursor marks the last user of a page. It holds the sort order it was issued
+// for and the value of the sort column of the user.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// encodeCursor returns the cursor of the page ending with the user.
+func encodeCursor(u User, sort string) string {
+	c := cursor{Sort: sort, ID: u.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case "created_at":
+		c.Value = u.CreatedAt.Format(time.RFC3339Nano)
+	case "name":
+		if u.Name != nil {
+			c.Value = *u.Name
+		}
+	case "email":
+		c.Value = u.Email
 	}
-	return auth.NewClaims(u.ID, u.Roles, now, 24*time.Hour), nil
 
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor issued by encodeCursor for the sort order.
+func decodeCursor(s, sort string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != sort {
+		return c, ErrInvalidCursor
+	}
+	if _, err := uuid.Parse(c.ID); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if strings.TrimPrefix(sort, "-") == "created_at" {
+		if _, err := time.Parse(time.RFC3339Nano, c.Value); err != nil {
+			return c, ErrInvalidCursor
+		}
+	}
+
+	return c, nil
+}
+
+// value returns the value of the sort column held by the cursor as a query
+// argument.
+func (c cursor) value(key string) interface{} {
+	if key == "created_at" {
+		t, _ := time.Parse(time.RFC3339Nano, c.Value)
+		return t
+	}
+	return c.Value
 }
@@ -1,6 +1,9 @@
 package user_test
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -42,6 +45,7 @@ func TestUser(t *testing.T) {
 				t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to create account.", tests.Success)
+			claims.AccountID = a.ID
 
 			nu := user.NewUser{
 				AccountID:       a.ID,
@@ -52,12 +56,19 @@ func TestUser(t *testing.T) {
 				PasswordConfirm: "gophers",
 			}
 
-			u, err := user.Create(ctx, db, nu, now)
+			u, err := user.Create(ctx, claims, db, nu, now)
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to create user.", tests.Success)
 
+			other := claims
+			other.AccountID = "a9b8b1f0-66b4-4b0e-9c1f-3e1a0d3f5c11" // Some other account.
+			if _, err := user.Retrieve(ctx, other, db, u.ID); errors.Cause(err) != user.ErrNotFound {
+				t.Fatalf("\t%s\tShould NOT be able to retrieve user from another account : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to retrieve user from another account.", tests.Success)
+
 			savedU, err := user.Retrieve(ctx, claims, db, u.ID)
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to retrieve user by ID: %s.", tests.Failed, err)
@@ -69,16 +80,35 @@ func TestUser(t *testing.T) {
 			}
 			t.Logf("\t%s\tShould get back the same user.", tests.Success)
 
+			page, err := user.List(ctx, claims, db, user.ListUsers{Name: "bill", Limit: 1})
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to list users : %s.", tests.Failed, err)
+			}
+			if len(page.Users) != 1 || page.Users[0].ID != u.ID || page.NextCursor != "" {
+				t.Fatalf("\t%s\tShould list only the user on a single page : %+v.", tests.Failed, page)
+			}
+			t.Logf("\t%s\tShould be able to list users.", tests.Success)
+
+			if _, err := user.List(ctx, claims, db, user.ListUsers{Sort: "-name", Cursor: "bogus"}); err != user.ErrInvalidCursor {
+				t.Fatalf("\t%s\tShould reject an invalid cursor : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould reject an invalid cursor.", tests.Success)
+
 			upd := user.UpdateUser{
 				Name:  tests.StringPointer("Jacob Walker"),
 				Email: tests.StringPointer("jacob@ardanlabs.com"),
 			}
 
-			if err := user.Update(ctx, claims, db, u.ID, upd, now); err != nil {
+			if err := user.Update(ctx, claims, db, u.ID, upd, u.Version, now); err != nil {
 				t.Fatalf("\t%s\tShould be able to update user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to update user.", tests.Success)
 
+			if err := user.Update(ctx, claims, db, u.ID, upd, u.Version, now); err != user.ErrVersionMismatch {
+				t.Fatalf("\t%s\tShould NOT be able to update a stale version of the user : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to update a stale version of the user.", tests.Success)
+
 			savedU, err = user.Retrieve(ctx, claims, db, u.ID)
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to retrieve user : %s.", tests.Failed, err)
@@ -101,7 +131,27 @@ func TestUser(t *testing.T) {
 				t.Logf("\t%s\tShould be able to see updates to Email.", tests.Success)
 			}
 
-			if err := user.Delete(ctx, db, u.ID); err != nil {
+			doc := user.PatchDocument(savedU)
+			doc.Name = nil
+			doc.Phone = tests.StringPointer("+1 555 0100")
+			if err := user.Patch(ctx, claims, db, u.ID, doc, savedU.Version, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to patch user : %s.", tests.Failed, err)
+			}
+			savedU, err = user.Retrieve(ctx, claims, db, u.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to retrieve user : %s.", tests.Failed, err)
+			}
+			if savedU.Name != nil || savedU.Phone == nil || *savedU.Phone != *doc.Phone {
+				t.Fatalf("\t%s\tShould be able to clear the name and set the phone : %+v.", tests.Failed, savedU)
+			}
+			t.Logf("\t%s\tShould be able to patch user.", tests.Success)
+
+			if err := user.Delete(ctx, other, db, u.ID, now); errors.Cause(err) != user.ErrNotFound {
+				t.Fatalf("\t%s\tShould NOT be able to delete user from another account : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to delete user from another account.", tests.Success)
+
+			if err := user.Delete(ctx, claims, db, u.ID, now); err != nil {
 				t.Fatalf("\t%s\tShould be able to delete user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to delete user.", tests.Success)
@@ -111,6 +161,19 @@ func TestUser(t *testing.T) {
 				t.Fatalf("\t%s\tShould NOT be able to retrieve user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould NOT be able to retrieve user.", tests.Success)
+
+			if _, err := user.Authenticate(ctx, db, now, "", *upd.Email, "gophers", time.Hour); errors.Cause(err) != user.ErrAuthenticationFailure {
+				t.Fatalf("\t%s\tShould NOT be able to authenticate deleted user : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to authenticate deleted user.", tests.Success)
+
+			if err := user.Restore(ctx, claims, db, u.ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to restore user : %s.", tests.Failed, err)
+			}
+			if _, err := user.Retrieve(ctx, claims, db, u.ID); err != nil {
+				t.Fatalf("\t%s\tShould be able to retrieve restored user : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to restore user.", tests.Success)
 		}
 	}
 }
@@ -147,23 +210,37 @@ func TestAuthenticate(t *testing.T) {
 				PasswordConfirm: "goroutines",
 			}
 
-			u, err := user.Create(ctx, db, nu, now)
+			admin := auth.Claims{Roles: []string{auth.RoleSuperAdmin}}
+			u, err := user.Create(ctx, admin, db, nu, now)
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to create user.", tests.Success)
 
-			claims, err := user.Authenticate(ctx, db, now, "anna@ardanlabs.com", "goroutines")
+			claims, err := user.Authenticate(ctx, db, now, "", "anna@ardanlabs.com", "goroutines", time.Hour)
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to generate claims : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to generate claims.", tests.Success)
 
 			want := auth.Claims{}
+			want.Id = claims.Id
 			want.Subject = u.ID
+			want.AccountID = a.ID
 			want.Roles = u.Roles
+			want.Permissions = []string{
+				auth.PermAccountsRead,
+				auth.PermAccountsWrite,
+				auth.PermAPIKeysRead,
+				auth.PermAPIKeysWrite,
+				auth.PermRolesRead,
+				auth.PermRolesWrite,
+				auth.PermUsersRead,
+				auth.PermUsersWrite,
+			}
 			want.ExpiresAt = now.Add(time.Hour).Unix()
 			want.IssuedAt = now.Unix()
+			want.IssuedAtMicros = now.UnixNano() / int64(time.Microsecond)
 
 			if diff := cmp.Diff(want, claims); diff != "" {
 				t.Fatalf("\t%s\tShould get back the expected claims. Diff:\n%s", tests.Failed, diff)
@@ -172,3 +249,169 @@ func TestAuthenticate(t *testing.T) {
 		}
 	}
 }
+
+// TestAuthenticateAccounts validates users sharing an email across accounts
+// can each log in with their own password.
+func TestAuthenticateAccounts(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to authenticate users sharing an email across accounts")
+	{
+		t.Log("\tWhen the same email belongs to users of two accounts.")
+		{
+			ctx := tests.Context()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			admin := auth.Claims{Roles: []string{auth.RoleSuperAdmin}}
+
+			var users []*user.User
+			for i, password := range []string{"gophers", "goroutines", "goroutines"} {
+				a, err := account.Create(ctx, db, account.NewAccount{Name: "Wayplot", Domain: fmt.Sprintf("wayplot%d", i)}, now)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
+				}
+				nu := user.NewUser{
+					AccountID:       a.ID,
+					Name:            "Anna Walker",
+					Email:           "anna@ardanlabs.com",
+					Roles:           []string{auth.RoleUser},
+					Password:        password,
+					PasswordConfirm: password,
+				}
+				u, err := user.Create(ctx, admin, db, nu, now)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+				}
+				users = append(users, u)
+			}
+			t.Logf("\t%s\tShould be able to create the users.", tests.Success)
+
+			claims, err := user.Authenticate(ctx, db, now, "", "anna@ardanlabs.com", "gophers", time.Hour)
+			if err != nil || claims.Subject != users[0].ID {
+				t.Fatalf("\t%s\tShould log in the only user with the password : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould log in the only user with the password.", tests.Success)
+
+			if _, err := user.Authenticate(ctx, db, now, "", "anna@ardanlabs.com", "goroutines", time.Hour); err != user.ErrAccountRequired {
+				t.Fatalf("\t%s\tShould require an account when several users have the password : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould require an account when several users have the password.", tests.Success)
+
+			claims, err = user.Authenticate(ctx, db, now, users[2].AccountID, "anna@ardanlabs.com", "goroutines", time.Hour)
+			if err != nil || claims.Subject != users[2].ID {
+				t.Fatalf("\t%s\tShould log in the user of the specified account : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould log in the user of the specified account.", tests.Success)
+
+			if _, err := user.Authenticate(ctx, db, now, users[0].AccountID, "anna@ardanlabs.com", "goroutines", time.Hour); err != user.ErrAuthenticationFailure {
+				t.Fatalf("\t%s\tShould NOT log in with the password of another account : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT log in with the password of another account.", tests.Success)
+		}
+	}
+}
+
+// TestListPages validates following the cursors of List visits every user
+// once in the requested order, including users tied on the sort column.
+func TestListPages(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to page through users.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		a, err := account.Create(ctx, db, account.NewAccount{Name: "Wayplot", Domain: "Wayplot"}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create account : %s.", tests.Failed, err)
+		}
+		claims := auth.NewClaims("718ffbea-f4a1-4667-8ae3-b349da52675e", []string{auth.RoleAdmin}, now, time.Hour)
+		claims.AccountID = a.ID
+
+		// Names and creation times repeat so pages have to break ties.
+		var users []*user.User
+		for i, name := range []string{"Carl", "Anna", "Eve", "Anna", "Dora", "Carl", "Bill"} {
+			nu := user.NewUser{
+				AccountID:       a.ID,
+				Name:            name,
+				Email:           fmt.Sprintf("%s%d@ardanlabs.com", string(rune('g'-i)), i),
+				Roles:           []string{auth.RoleUser},
+				Password:        "gophers",
+				PasswordConfirm: "gophers",
+			}
+			u, err := user.Create(ctx, claims, db, nu, now.Add(time.Duration(i/2)*time.Minute))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+			}
+			users = append(users, u)
+		}
+		t.Logf("\t%s\tShould be able to create users.", tests.Success)
+
+		// less orders users on the sort column then on their ID.
+		less := map[string]func(a, b *user.User) bool{
+			"created_at": func(a, b *user.User) bool {
+				if !a.CreatedAt.Equal(b.CreatedAt) {
+					return a.CreatedAt.Before(b.CreatedAt)
+				}
+				return a.ID < b.ID
+			},
+			"name": func(a, b *user.User) bool {
+				if *a.Name != *b.Name {
+					return *a.Name < *b.Name
+				}
+				return a.ID < b.ID
+			},
+			"email": func(a, b *user.User) bool {
+				return a.Email < b.Email
+			},
+		}
+
+		for _, order := range []string{"created_at", "-created_at", "name", "-name", "email", "-email"} {
+			t.Logf("\tWhen paging through users sorted by %s.", order)
+			{
+				want := append([]*user.User(nil), users...)
+				key, desc := strings.TrimPrefix(order, "-"), strings.HasPrefix(order, "-")
+				sort.Slice(want, func(i, j int) bool {
+					if desc {
+						return less[key](want[j], want[i])
+					}
+					return less[key](want[i], want[j])
+				})
+
+				var got []string
+				var pages int
+				f := user.ListUsers{Sort: order, Limit: 2}
+				for {
+					page, err := user.List(ctx, claims, db, f)
+					if err != nil {
+						t.Fatalf("\t%s\tShould be able to list users : %s.", tests.Failed, err)
+					}
+					pages++
+					for _, u := range page.Users {
+						got = append(got, u.ID)
+					}
+					if page.NextCursor == "" {
+						break
+					}
+					if pages == len(users) {
+						t.Fatalf("\t%s\tShould reach the last page.", tests.Failed)
+					}
+					f.Cursor = page.NextCursor
+				}
+
+				var exp []string
+				for _, u := range want {
+					exp = append(exp, u.ID)
+				}
+				if diff := cmp.Diff(exp, got); diff != "" {
+					t.Fatalf("\t%s\tShould visit every user once in order. Diff:\n%s", tests.Failed, diff)
+				}
+				if pages != 4 {
+					t.Fatalf("\t%s\tShould list the users on 4 pages : got %d.", tests.Failed, pages)
+				}
+				t.Logf("\t%s\tShould visit every user once in order.", tests.Success)
+			}
+		}
+	}
+}
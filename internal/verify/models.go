@@ -0,0 +1,12 @@
+package verify
+
+// VerifyRequest is the body of a request to verify an email.
+type VerifyRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ResendRequest is the body of a request to send the verification email
+// again.
+type ResendRequest struct {
+	Email string `json:"email" validate:"required"`
+}
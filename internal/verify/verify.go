@@ -0,0 +1,138 @@
+// Package verify issues and checks the tokens proving a user received an
+// email at their address.
+package verify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/sankarvj/seedgo/internal/platform/mail"
+)
+
+// audience separates verification tokens from every other token signed by
+// the service.
+const audience = "email-verification"
+
+// ErrInvalidToken occurs when a verification token is malformed, has a bad
+// signature or has expired.
+var ErrInvalidToken = errors.New("Verification token is not valid")
+
+// Config holds the settings used to construct a Verifier.
+type Config struct {
+
+	// Secret is the HMAC key signing the tokens.
+	Secret []byte
+
+	// TTL is how long a token stays valid.
+	TTL time.Duration
+
+	// URL is the page users open to verify their email. The token is added to
+	// it as the token query parameter. Blank sends the bare token.
+	URL string
+}
+
+// Verifier issues verification tokens and mails them to users.
+type Verifier struct {
+	cfg    Config
+	mailer mail.Mailer
+	parser *jwt.Parser
+}
+
+// tokenClaims are the claims of a verification token. The email is part of
+// the token so changing the email of a user invalidates it.
+type tokenClaims struct {
+	Email string `json:"email"`
+	jwt.StandardClaims
+}
+
+// New constructs a Verifier sending tokens through the mailer.
+func New(cfg Config, mailer mail.Mailer) (*Verifier, error) {
+	if len(cfg.Secret) == 0 {
+		return nil, errors.New("verification secret cannot be blank")
+	}
+	if cfg.TTL <= 0 {
+		return nil, errors.New("verification ttl must be positive")
+	}
+
+	v := Verifier{
+		cfg:    cfg,
+		mailer: mailer,
+		parser: &jwt.Parser{
+			ValidMethods: []string{jwt.SigningMethodHS256.Name},
+		},
+	}
+
+	return &v, nil
+}
+
+// Token generates a token proving the user owns the email.
+func (v *Verifier) Token(userID, email string, now time.Time) (string, error) {
+	c := tokenClaims{
+		Email: email,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  audience,
+			Subject:   userID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(v.cfg.TTL).Unix(),
+		},
+	}
+
+	str, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(v.cfg.Secret)
+	if err != nil {
+		return "", errors.Wrap(err, "signing verification token")
+	}
+
+	return str, nil
+}
+
+// Parse checks the token and returns the user and email it was issued for.
+func (v *Verifier) Parse(token string) (string, string, error) {
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		return v.cfg.Secret, nil
+	}
+
+	var c tokenClaims
+	if _, err := v.parser.ParseWithClaims(token, &c, keyFunc); err != nil {
+		return "", "", ErrInvalidToken
+	}
+	if !c.VerifyAudience(audience, true) || c.Subject == "" || c.ExpiresAt == 0 {
+		return "", "", ErrInvalidToken
+	}
+
+	return c.Subject, c.Email, nil
+}
+
+// Send mails a verification token to the user.
+func (v *Verifier) Send(ctx context.Context, userID, email string, now time.Time) error {
+	token, err := v.Token(userID, email, now)
+	if err != nil {
+		return err
+	}
+
+	link := token
+	if v.cfg.URL != "" {
+		u, err := url.Parse(v.cfg.URL)
+		if err != nil {
+			return errors.Wrap(err, "parsing verification url")
+		}
+		q := u.Query()
+		q.Set("token", token)
+		u.RawQuery = q.Encode()
+		link = u.String()
+	}
+
+	m := mail.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body:    fmt.Sprintf("Confirm your email address by opening the link below. It expires in %s.\n\n%s", v.cfg.TTL, link),
+	}
+	if err := v.mailer.Send(ctx, m); err != nil {
+		return errors.Wrap(err, "sending verification email")
+	}
+
+	return nil
+}
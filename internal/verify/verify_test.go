@@ -0,0 +1,79 @@
+package verify_test
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/sankarvj/seedgo/internal/platform/mail"
+	"github.com/sankarvj/seedgo/internal/tests"
+	"github.com/sankarvj/seedgo/internal/verify"
+)
+
+// TestVerifier validates verification tokens are mailed to users and only
+// accepted while valid.
+func TestVerifier(t *testing.T) {
+	var out bytes.Buffer
+	v, err := verify.New(verify.Config{
+		Secret: []byte("secret"),
+		TTL:    time.Hour,
+		URL:    "https://app.example.com/verify",
+	}, mail.NewLog(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const userID = "5cf37266-3473-4006-984f-9325122678b7"
+
+	t.Log("Given the need to verify the email of users.")
+	{
+		t.Log("\tWhen a verification email is sent.")
+		{
+			if err := v.Send(context.Background(), userID, "anna@example.com", time.Now()); err != nil {
+				t.Fatalf("\t%s\tShould be able to send the email : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to send the email.", tests.Success)
+
+			link := regexp.MustCompile(`https://app\.example\.com/verify\?\S+`).FindString(out.String())
+			u, err := url.Parse(link)
+			if err != nil || link == "" {
+				t.Fatalf("\t%s\tShould mail a verification link : %q.", tests.Failed, out.String())
+			}
+			t.Logf("\t%s\tShould mail a verification link.", tests.Success)
+
+			id, email, err := v.Parse(u.Query().Get("token"))
+			if err != nil || id != userID || email != "anna@example.com" {
+				t.Fatalf("\t%s\tShould accept the mailed token : %s %s %v.", tests.Failed, id, email, err)
+			}
+			t.Logf("\t%s\tShould accept the mailed token.", tests.Success)
+		}
+
+		t.Log("\tWhen the token is not valid.")
+		{
+			expired, err := v.Token(userID, "anna@example.com", time.Now().Add(-2*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := v.Parse(expired); err != verify.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould reject an expired token : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould reject an expired token.", tests.Success)
+
+			other, err := verify.New(verify.Config{Secret: []byte("other"), TTL: time.Hour}, mail.NewLog(&out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			forged, err := other.Token(userID, "anna@example.com", time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := v.Parse(forged); err != verify.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould reject a token signed with another secret : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould reject a token signed with another secret.", tests.Success)
+		}
+	}
+}